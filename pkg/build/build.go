@@ -30,18 +30,47 @@ import (
 // Build will create all artifacts required by the manifest
 // This assumes the working directory has been setup and sources resolved.
 func Build(manifest model.Manifest) error {
+	util.SetLogStage("signing")
+	var signer *PackageSigner
+	if flags.gpgKey != "" {
+		var err error
+		if signer, err = NewPackageSigner(manifest, flags.gpgKey); err != nil {
+			return fmt.Errorf("failed to setup package signing: %v", err)
+		}
+		if err := signer.ExportPublicKey(path.Join(manifest.OutDir(), "istio-packages.asc")); err != nil {
+			return err
+		}
+	}
+
+	// Outputs whose inputs are unchanged since the --reuse-from build are copied forward rather than rebuilt.
+	reuse, err := newOutputReuse(manifest, flags.reuseFrom, signer)
+	if err != nil {
+		return err
+	}
+
 	if _, f := manifest.BuildOutputs[model.Docker]; f {
-		if err := Docker(manifest); err != nil {
+		util.SetLogStage("docker")
+		if err := reuse.Build(model.Docker, func() error { return Docker(manifest) }); err != nil {
 			return fmt.Errorf("failed to build Docker: %v", err)
 		}
 	}
 
+	if _, f := manifest.BuildOutputs[model.ImageScan]; f {
+		util.SetLogStage("imagescan")
+		if manifest.DockerOutput == model.DockerOutputContext {
+			log.Warnf("Docker output in 'context' mode; will not scan images.")
+		} else if err := ScanImages(manifest); err != nil {
+			return fmt.Errorf("failed image vulnerability scan: %v", err)
+		}
+	}
+
+	util.SetLogStage("helm")
 	if err := SanitizeAllCharts(manifest); err != nil {
 		return fmt.Errorf("failed to sanitize charts: %v", err)
 	}
 	if util.IsValidSemver(manifest.Version) {
 		if _, f := manifest.BuildOutputs[model.Helm]; f {
-			if err := HelmCharts(manifest); err != nil {
+			if err := reuse.Build(model.Helm, func() error { return HelmCharts(manifest) }); err != nil {
 				return fmt.Errorf("failed to build HelmCharts: %v", err)
 			}
 		}
@@ -50,29 +79,45 @@ func Build(manifest model.Manifest) error {
 	}
 
 	if _, f := manifest.BuildOutputs[model.Debian]; f {
-		if err := Debian(manifest); err != nil {
+		util.SetLogStage("debian")
+		if err := reuse.Build(model.Debian, func() error { return Debian(manifest, signer) }); err != nil {
 			return fmt.Errorf("failed to build Debian: %v", err)
 		}
 	}
 
 	if _, f := manifest.BuildOutputs[model.Rpm]; f {
-		if err := Rpm(manifest); err != nil {
+		util.SetLogStage("rpm")
+		if err := reuse.Build(model.Rpm, func() error { return Rpm(manifest, signer) }); err != nil {
 			return fmt.Errorf("failed to build Rpm: %v", err)
 		}
 	}
 
+	if _, f := manifest.BuildOutputs[model.PackageRepository]; f {
+		util.SetLogStage("repository")
+		if err := reuse.Build(model.PackageRepository, func() error { return PackageRepository(manifest, signer) }); err != nil {
+			return fmt.Errorf("failed to build PackageRepository: %v", err)
+		}
+	}
+
 	if _, f := manifest.BuildOutputs[model.Archive]; f {
-		if err := Archive(manifest); err != nil {
+		util.SetLogStage("archive")
+		if err := reuse.Build(model.Archive, func() error { return Archive(manifest) }); err != nil {
 			return fmt.Errorf("failed to build Archive: %v", err)
 		}
 	}
 
 	if _, f := manifest.BuildOutputs[model.Grafana]; f {
-		if err := Grafana(manifest); err != nil {
+		util.SetLogStage("grafana")
+		if err := reuse.Build(model.Grafana, func() error { return Grafana(manifest) }); err != nil {
 			return fmt.Errorf("failed to build Grafana: %v", err)
 		}
 	}
 
+	if err := reuse.Write(); err != nil {
+		return err
+	}
+
+	util.SetLogStage("bundle")
 	// Bundle all sources used in the build
 	cmd := util.VerboseCommand("tar", "-czf", "out/sources.tar.gz", "sources")
 	cmd.Dir = path.Join(manifest.Directory)
@@ -84,15 +129,21 @@ func Build(manifest model.Manifest) error {
 		return fmt.Errorf("failed to write manifest: %v", err)
 	}
 
+	util.SetLogStage("licenses")
 	if err := writeLicense(manifest); err != nil {
 		return fmt.Errorf("failed to package license file: %v", err)
 	}
 
+	if err := LicenseReport(manifest); err != nil {
+		return fmt.Errorf("failed license check: %v", err)
+	}
+
 	if manifest.DockerOutput == model.DockerOutputContext {
 		log.Warnf("Docker output in 'context' mode; will not produce SBOM.")
 	} else if manifest.SkipGenerateBillOfMaterials {
 		log.Warnf("Input manifest set SkipGenerateBillOfMaterials; will not produce SBOM.")
 	} else {
+		util.SetLogStage("sbom")
 		if err := GenerateBillOfMaterials(manifest); err != nil {
 			return fmt.Errorf("failed to generate sbom: %v", err)
 		}
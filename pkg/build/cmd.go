@@ -16,6 +16,7 @@ package build
 
 import (
 	"fmt"
+	"path"
 
 	"github.com/spf13/cobra"
 
@@ -29,6 +30,9 @@ var (
 		manifest        string
 		githubTokenFile string
 		buildBaseImages bool
+		gpgKey          string
+		dryRun          bool
+		reuseFrom       string
 	}{
 		manifest: "example/manifest.yaml",
 	}
@@ -37,6 +41,13 @@ var (
 		Short:        "Builds a release of Istio",
 		SilenceUsage: true,
 		Args:         cobra.ExactArgs(0),
+		PreRunE: func(c *cobra.Command, _ []string) error {
+			// Only the base image scan has a dry run; a release build with --dry-run would silently build for real.
+			if flags.dryRun && !flags.buildBaseImages {
+				return fmt.Errorf("--dry-run is only supported with --build-base-images")
+			}
+			return nil
+		},
 		RunE: func(c *cobra.Command, _ []string) error {
 			inManifest, err := pkg.ReadInManifest(flags.manifest)
 			if err != nil {
@@ -58,6 +69,10 @@ var (
 				return fmt.Errorf("failed to setup work dir: %v", err)
 			}
 
+			// Capture the output of every command, so it can be inspected when a release is investigated.
+			util.EnableCommandLogs(path.Join(manifest.OutDir(), "logs"))
+
+			util.SetLogStage("sources")
 			if err := pkg.Sources(manifest); err != nil {
 				return fmt.Errorf("failed to fetch sources: %v", err)
 			}
@@ -68,16 +83,20 @@ var (
 			}
 
 			if flags.buildBaseImages {
-				token, err := util.GetGithubToken(flags.githubTokenFile)
-				if err != nil {
-					return err
-				}
-
-				// Validate GitHub token for base image scanning (always creates PRs)
-				if err := util.ValidateGithubToken(token); err != nil {
-					return err
+				util.SetLogStage("base-images")
+				token := ""
+				if !flags.dryRun {
+					var err error
+					if token, err = util.GetGithubToken(flags.githubTokenFile); err != nil {
+						return err
+					}
+
+					// Validate GitHub token for base image scanning (always creates PRs)
+					if err := util.ValidateGithubToken(token); err != nil {
+						return err
+					}
 				}
-				if err := Scanner(manifest, token, savedIstioGit, savedIstioBranch); err != nil {
+				if err := Scanner(manifest, token, savedIstioGit, savedIstioBranch, flags.dryRun); err != nil {
 					return fmt.Errorf("failed image scan: %v", err)
 				}
 				return nil
@@ -100,6 +119,12 @@ func init() {
 		"The file containing a github token.")
 	buildCmd.PersistentFlags().BoolVar(&flags.buildBaseImages, "build-base-images", flags.buildBaseImages,
 		"When set scan base images for vulnerabilities and build new ones if needed.")
+	buildCmd.PersistentFlags().BoolVar(&flags.dryRun, "dry-run", flags.dryRun,
+		"With --build-base-images, scan and print the planned rebuild and PR diff, without building, pushing or creating a PR.")
+	buildCmd.PersistentFlags().StringVar(&flags.reuseFrom, "reuse-from", flags.reuseFrom,
+		"The output directory of a previous build. Outputs whose inputs are unchanged are copied from it rather than rebuilt.")
+	buildCmd.PersistentFlags().StringVar(&flags.gpgKey, "gpgkey", flags.gpgKey,
+		"The file containing an armored, unencrypted GPG private key to sign the deb and rpm packages with.")
 }
 
 func GetBuildCommand() *cobra.Command {
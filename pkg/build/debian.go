@@ -23,17 +23,15 @@ import (
 	"istio.io/release-builder/pkg/util"
 )
 
-// Debian produces a debian package just for the sidecar
-func Debian(manifest model.Manifest) error {
+// Debian produces a debian package just for the sidecar. If signer is set, a detached signature is written
+// next to each package.
+func Debian(manifest model.Manifest, signer *PackageSigner) error {
 	for _, plat := range manifest.Architectures {
 		_, arch, _ := strings.Cut(plat, "/")
 		envs := []string{"TARGET_ARCH=" + arch}
-		output := "istio-sidecar.deb"
-		if arch != "amd64" {
-			output = fmt.Sprintf("istio-sidecar-%s.deb", arch)
-		}
+		output := util.SidecarPackageName(arch, "deb")
 
-		if err := runDeb(manifest, envs, arch, output); err != nil {
+		if err := runDeb(manifest, envs, arch, output, signer); err != nil {
 			return fmt.Errorf("failed to run deb for arch %s: %v", arch, err)
 		}
 	}
@@ -41,7 +39,7 @@ func Debian(manifest model.Manifest) error {
 	return nil
 }
 
-func runDeb(manifest model.Manifest, envs []string, arch, output string) error {
+func runDeb(manifest model.Manifest, envs []string, arch, output string, signer *PackageSigner) error {
 	if err := util.RunMake(manifest, "istio", envs, "deb/fpm"); err != nil {
 		return fmt.Errorf("failed to build sidecar.deb: %v", err)
 	}
@@ -49,6 +47,12 @@ func runDeb(manifest model.Manifest, envs []string, arch, output string) error {
 	if err := util.CopyFile(path.Join(manifest.RepoArchOutDir("istio", arch), "istio-sidecar.deb"), path.Join(manifest.OutDir(), "deb", output)); err != nil {
 		return fmt.Errorf("failed to package istio-sidecar.deb: %v", err)
 	}
+	if signer != nil {
+		deb := path.Join(manifest.OutDir(), "deb", output)
+		if err := signer.DetachSign(deb, deb+".asc"); err != nil {
+			return err
+		}
+	}
 	if err := util.CreateSha(path.Join(manifest.OutDir(), "deb", output)); err != nil {
 		return fmt.Errorf("failed to package istio-sidecar.deb: %v", err)
 	}
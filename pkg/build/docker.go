@@ -16,8 +16,17 @@ package build
 
 import (
 	"fmt"
+	"os"
 	"path"
+	"sort"
+	"strings"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"istio.io/istio/pkg/log"
 	"istio.io/release-builder/pkg/model"
 	"istio.io/release-builder/pkg/util"
 )
@@ -25,8 +34,8 @@ import (
 // Docker builds all docker images and outputs them as tar.gz files
 // docker.save in the repos does most of the work, we just need to call this and copy the files over
 func Docker(manifest model.Manifest) error {
-	// Build both default and distroless variants
-	env := []string{"DOCKER_BUILD_VARIANTS=debug distroless"}
+	// Build the default image, along with the configured variants
+	env := []string{"DOCKER_BUILD_VARIANTS=" + strings.Join(manifest.Variants, " ")}
 
 	if manifest.ProxyOverride != "" {
 		// Add the vars to tell Istio to use our own Envoy binary
@@ -40,6 +49,9 @@ func Docker(manifest model.Manifest) error {
 	if err := util.RunMake(manifest, "istio", env, target); err != nil {
 		return fmt.Errorf("failed to create %v docker archives: %v", "istio", err)
 	}
+	if manifest.DockerOutput == model.DockerOutputOCI {
+		return writeOCILayouts(manifest, path.Join(manifest.RepoOutDir("istio"), "docker"))
+	}
 	if util.FileExists(path.Join(manifest.RepoOutDir("istio"), "docker")) {
 		// Some repos output docker files to the source repo
 		if err := util.CopyFilesToDir(path.Join(manifest.RepoOutDir("istio"), "docker"), path.Join(manifest.OutDir(), "docker")); err != nil {
@@ -49,3 +61,63 @@ func Docker(manifest model.Manifest) error {
 
 	return nil
 }
+
+// writeOCILayouts converts the `docker save` archives into one OCI image layout per image, at out/docker/<image>.
+// The index of each layout holds one entry per variant, which is itself an index of all architectures for that variant.
+func writeOCILayouts(manifest model.Manifest, archiveDir string) error {
+	archives, err := os.ReadDir(archiveDir)
+	if err != nil {
+		return fmt.Errorf("failed to read docker archives: %v", err)
+	}
+	// image name -> variant -> images for each architecture
+	images := map[string]map[string][]v1.Image{}
+	for _, f := range archives {
+		if !strings.HasSuffix(f.Name(), ".tar.gz") {
+			return fmt.Errorf("invalid image found in docker folder: %v", f.Name())
+		}
+		imageName, variant, _, err := util.ImageNameVariant(manifest, f.Name())
+		if err != nil {
+			return err
+		}
+		img, err := util.ImageFromArchive(path.Join(archiveDir, f.Name()))
+		if err != nil {
+			return err
+		}
+		if images[imageName] == nil {
+			images[imageName] = map[string][]v1.Image{}
+		}
+		images[imageName][variant] = append(images[imageName][variant], img)
+	}
+
+	for imageName, variants := range images {
+		dir := path.Join(manifest.OutDir(), "docker", imageName)
+		lp, err := layout.Write(dir, empty.Index)
+		if err != nil {
+			return fmt.Errorf("failed to create image layout %v: %v", dir, err)
+		}
+		// Keep the index stable between builds
+		names := make([]string, 0, len(variants))
+		for variant := range variants {
+			names = append(names, variant)
+		}
+		sort.Strings(names)
+		for _, variant := range names {
+			index, err := util.PlatformIndex(variants[variant], types.OCIImageIndex)
+			if err != nil {
+				return fmt.Errorf("failed to build index for %v: %v", imageName, err)
+			}
+			tag := manifest.Version
+			if variant != "" {
+				tag += "-" + variant
+			}
+			if err := lp.AppendIndex(index, layout.WithAnnotations(map[string]string{
+				util.ImageRefNameAnnotation: tag,
+				util.ImageVariantAnnotation: variant,
+			})); err != nil {
+				return fmt.Errorf("failed to write %v:%v to image layout: %v", imageName, tag, err)
+			}
+		}
+		log.Infof("Wrote image layout %v", dir)
+	}
+	return nil
+}
@@ -18,8 +18,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"path"
+	"slices"
 	"strings"
 
 	"istio.io/istio/pkg/log"
@@ -40,6 +42,7 @@ func Grafana(manifest model.Manifest) error {
 		return fmt.Errorf("failed to read dashboards: %v", err)
 	}
 
+	packaged := []string{}
 	for _, dashboard := range dashboards {
 		if !strings.HasSuffix(dashboard.Name(), "-dashboard.json") && !strings.HasSuffix(dashboard.Name(), "-dashboard.gen.json") {
 			log.Infof("skipping non-dashboard file dashboard %v", dashboard.Name())
@@ -56,10 +59,156 @@ func Grafana(manifest model.Manifest) error {
 		); err != nil {
 			return err
 		}
+		packaged = append(packaged, strings.TrimSuffix(sanitized, ".json"))
+	}
+	return writeDashboardInventory(manifest, packaged)
+}
+
+// DashboardStatus describes how a dashboard is published to grafana.com.
+type DashboardStatus string
+
+const (
+	// DashboardMapped dashboards are published to their grafana.com ID.
+	DashboardMapped DashboardStatus = "mapped"
+	// DashboardUnmapped dashboards are packaged, but not listed in the manifest, so they are never published.
+	DashboardUnmapped DashboardStatus = "unmapped"
+	// DashboardUnknownID dashboards are listed in the manifest without a grafana.com ID, so they are never published.
+	DashboardUnknownID DashboardStatus = "unknown-id"
+	// DashboardMissing dashboards are listed in the manifest, but were not found in the Istio repo.
+	DashboardMissing DashboardStatus = "missing"
+)
+
+// DashboardInventoryEntry records the grafana.com ID of a single dashboard.
+type DashboardInventoryEntry struct {
+	Name   string          `json:"name"`
+	ID     int             `json:"id,omitempty"`
+	Status DashboardStatus `json:"status"`
+}
+
+// dashboardInventory maps each packaged dashboard to its grafana.com ID, along with any mapped dashboards that
+// were not packaged. Entries are sorted by name.
+func dashboardInventory(packaged []string, mapping map[string]int) []DashboardInventoryEntry {
+	res := []DashboardInventoryEntry{}
+	seen := map[string]struct{}{}
+	for _, name := range packaged {
+		seen[name] = struct{}{}
+		id, f := mapping[name]
+		switch {
+		case !f:
+			res = append(res, DashboardInventoryEntry{Name: name, Status: DashboardUnmapped})
+		case id <= 0:
+			res = append(res, DashboardInventoryEntry{Name: name, Status: DashboardUnknownID})
+		default:
+			res = append(res, DashboardInventoryEntry{Name: name, ID: id, Status: DashboardMapped})
+		}
+	}
+	for name, id := range mapping {
+		if _, f := seen[name]; !f {
+			res = append(res, DashboardInventoryEntry{Name: name, ID: id, Status: DashboardMissing})
+		}
+	}
+	slices.SortFunc(res, func(a, b DashboardInventoryEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res
+}
+
+// writeDashboardInventory writes the dashboard inventory to grafana-inventory.json, reporting any dashboard that
+// would not be published. With StrictDashboards set, these fail the build.
+func writeDashboardInventory(manifest model.Manifest, packaged []string) error {
+	inventory := dashboardInventory(packaged, manifest.GrafanaDashboards)
+	problems := []string{}
+	for _, e := range inventory {
+		if e.Status != DashboardMapped {
+			log.Warnf("dashboard %v will not be published to grafana.com: %v", e.Name, e.Status)
+			problems = append(problems, fmt.Sprintf("%v (%v)", e.Name, e.Status))
+		}
+	}
+	by, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal dashboard inventory: %v", err)
+	}
+	if err := os.WriteFile(path.Join(manifest.OutDir(), "grafana-inventory.json"), by, 0o644); err != nil {
+		return fmt.Errorf("failed to write dashboard inventory: %v", err)
+	}
+	if manifest.StrictDashboards && len(problems) > 0 {
+		return fmt.Errorf("dashboards are not mapped to grafana.com: %v", strings.Join(problems, ", "))
 	}
 	return nil
 }
 
+// grafanaSchemaVersions maps dashboard schemaVersions to the Grafana release that introduced them, sorted by schema.
+// A dashboard requires the release of the highest schema at or below its own.
+var grafanaSchemaVersions = []struct {
+	schema  int64
+	version string
+}{
+	{16, "5.0.0"},
+	{27, "7.4.0"},
+	{30, "8.0.0"},
+	{36, "9.0.0"},
+	{38, "10.0.0"},
+}
+
+// grafanaVersion returns the minimum Grafana version that can import a dashboard, based on its schemaVersion.
+func grafanaVersion(msg map[string]any) (string, error) {
+	n, ok := msg["schemaVersion"].(json.Number)
+	if !ok {
+		return "", fmt.Errorf("no schemaVersion: %v", msg["schemaVersion"])
+	}
+	schema, err := n.Int64()
+	if err != nil {
+		return "", fmt.Errorf("invalid schemaVersion %v: %v", n, err)
+	}
+	if schema < grafanaSchemaVersions[0].schema {
+		return "", fmt.Errorf("schemaVersion %v is older than Grafana %v", schema, grafanaSchemaVersions[0].version)
+	}
+	version := ""
+	for _, v := range grafanaSchemaVersions {
+		if v.schema <= schema {
+			version = v.version
+		}
+	}
+	return version, nil
+}
+
+// dashboardDatasource is the __inputs variable replacing concrete Prometheus datasources.
+const dashboardDatasource = "${DS_PROMETHEUS}"
+
+// pluginNames holds the display names of the plugins that may be listed in __requires. Unknown plugins use their ID.
+var pluginNames = map[string]string{
+	"bargauge":   "Bar gauge",
+	"barchart":   "Bar chart",
+	"dashlist":   "Dashboard list",
+	"gauge":      "Gauge",
+	"graph":      "Graph",
+	"heatmap":    "Heatmap",
+	"histogram":  "Histogram",
+	"logs":       "Logs",
+	"loki":       "Loki",
+	"piechart":   "Pie chart",
+	"prometheus": "Prometheus",
+	"singlestat": "Singlestat",
+	"stat":       "Stat",
+	"table":      "Table",
+	"text":       "Text",
+	"timeseries": "Time series",
+}
+
+// builtinDatasources are provided by Grafana itself, such as the annotation datasource, and need no templating.
+var builtinDatasources = map[string]struct{}{
+	"-- Grafana --":   {},
+	"-- Mixed --":     {},
+	"-- Dashboard --": {},
+	"grafana":         {},
+}
+
+// builtinDatasourceTypes are the plugin types of the builtin datasources, which may be referred to by type alone.
+var builtinDatasourceTypes = map[string]struct{}{
+	"datasource": {},
+	"grafana":    {},
+}
+
 // externalizeDashboard converts a grafana dashboard from the "internal" representation, which is used
 // in the charts, to the "external" representation. This is the form needed to publish to grafana.com
 // This has two fields added, __inputs and __requires, and the datasource is not hardcoded.
@@ -68,78 +217,239 @@ func externalizeDashboard(version, file string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read %v: %v", file, err)
 	}
-	var msg map[string]json.RawMessage
-	if err := json.Unmarshal(original, &msg); err != nil {
-		return fmt.Errorf("failed to unmarshall %v: %v", file, err)
-	}
-
-	var inputMsg json.RawMessage
-	if err := json.Unmarshal([]byte(`[
-    {
-      "name": "DS_PROMETHEUS",
-      "label": "Prometheus",
-      "description": "",
-      "type": "datasource",
-      "pluginId": "prometheus",
-      "pluginName": "Prometheus"
-    }
-  ]`), &inputMsg); err != nil {
-		return fmt.Errorf("failed to construct __inputs: %v", err)
-	}
-	msg["__inputs"] = inputMsg
-
-	var requiresMesh json.RawMessage
-	if err := json.Unmarshal([]byte(`[
-    {
-      "type": "grafana",
-      "id": "grafana",
-      "name": "Grafana",
-      "version": "6.4.3"
-    },
-    {
-      "type": "panel",
-      "id": "graph",
-      "name": "Graph",
-      "version": ""
-    },
-    {
-      "type": "datasource",
-      "id": "prometheus",
-      "name": "Prometheus",
-      "version": "5.0.0"
-    },
-    {
-      "type": "panel",
-      "id": "table",
-      "name": "Table",
-      "version": ""
-    }
-  ]`), &requiresMesh); err != nil {
-		return fmt.Errorf("failed to construct __requires: %v", err)
-	}
-	msg["__requires"] = requiresMesh
+	result, err := externalizeDashboardJSON(version, original)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, result, 0o644); err != nil {
+		return fmt.Errorf("failed to write: %v", err)
+	}
+	return nil
+}
+
+func externalizeDashboardJSON(version string, original []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(original))
+	// Keep numbers as written, rather than round tripping them through float64
+	dec.UseNumber()
+	var msg map[string]any
+	if err := dec.Decode(&msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshall: %v", err)
+	}
 
 	// Validate there is not already a description, and that there is a tile
-	if by, _ := msg["description"].MarshalJSON(); string(by) != `""` && string(by) != `null` {
-		return fmt.Errorf("already has a description: %v", string(by))
+	if desc, f := msg["description"]; f && desc != nil && desc != "" {
+		return nil, fmt.Errorf("already has a description: %v", desc)
 	}
-	titleBytes := msg["title"][1 : len(msg["title"])-1]
-	if len(titleBytes) == 0 {
-		return fmt.Errorf("no title: %v", string(titleBytes))
+	title, _ := msg["title"].(string)
+	if title == "" {
+		return nil, fmt.Errorf("no title: %v", msg["title"])
 	}
-
 	// Set the description, so the version is included
-	msg["description"] = []byte(fmt.Sprintf(`"%s version %s"`, string(titleBytes), version))
+	msg["description"] = fmt.Sprintf("%s version %s", title, version)
+
+	grafana, err := grafanaVersion(msg)
+	if err != nil {
+		return nil, err
+	}
+	d := &dashboardWalker{
+		variables:   datasourceVariables(msg),
+		panels:      map[string]struct{}{},
+		datasources: map[string]struct{}{},
+	}
+	// The plugins selected by datasource variables are required as well, even if no panel names them directly
+	for _, plugin := range d.variables {
+		if plugin != "" {
+			d.datasources[plugin] = struct{}{}
+		}
+	}
+	if err := d.walk(msg, ""); err != nil {
+		return nil, err
+	}
+
+	inputs := []any{}
+	if d.templated {
+		inputs = append(inputs, map[string]any{
+			"name":        "DS_PROMETHEUS",
+			"label":       "Prometheus",
+			"description": "",
+			"type":        "datasource",
+			"pluginId":    "prometheus",
+			"pluginName":  "Prometheus",
+		})
+	}
+	msg["__inputs"] = inputs
+	msg["__requires"] = d.requires(grafana)
 
 	// Write out the result
 	result, err := json.MarshalIndent(msg, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal%v", err)
+		return nil, fmt.Errorf("failed to marshal%v", err)
 	}
-	// Substitute the datasource with the variable placeholder
-	result = bytes.ReplaceAll(result, []byte(`"datasource": "Prometheus"`), []byte(`"datasource": "${DS_PROMETHEUS}"`))
-	if err := os.WriteFile(file, result, 0o644); err != nil {
-		return fmt.Errorf("failed to write: %v", err)
+	return result, nil
+}
+
+// datasourceVariables returns the templating variables of type datasource, mapped to the plugin they select.
+func datasourceVariables(msg map[string]any) map[string]string {
+	res := map[string]string{}
+	templating, _ := msg["templating"].(map[string]any)
+	list, _ := templating["list"].([]any)
+	for _, v := range list {
+		variable, _ := v.(map[string]any)
+		if variable["type"] != "datasource" {
+			continue
+		}
+		name, _ := variable["name"].(string)
+		plugin, _ := variable["query"].(string)
+		res[name] = plugin
+	}
+	return res
+}
+
+// dashboardWalker rewrites datasource references in a dashboard, recording the plugins it uses along the way.
+type dashboardWalker struct {
+	// variables holds the datasource templating variables of the dashboard, by name.
+	variables map[string]string
+	// panels and datasources hold the IDs of the plugins in use.
+	panels      map[string]struct{}
+	datasources map[string]struct{}
+	// templated is set once any reference is replaced with the DS_PROMETHEUS input.
+	templated bool
+}
+
+func (d *dashboardWalker) walk(node any, key string) error {
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			if k == "datasource" {
+				ds, err := d.datasource(v)
+				if err != nil {
+					return err
+				}
+				n[k] = ds
+				continue
+			}
+			if err := d.walk(v, k); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range n {
+			// Panels may be nested in rows, so every "panels" list is considered
+			if p, ok := v.(map[string]any); ok && key == "panels" {
+				if t, _ := p["type"].(string); t != "" && t != "row" {
+					d.panels[t] = struct{}{}
+				}
+			}
+			if err := d.walk(v, key); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
+
+// datasource rewrites a single datasource reference. Older dashboards refer to a datasource by name, while newer
+// ones use an object with the plugin type and datasource uid.
+func (d *dashboardWalker) datasource(ds any) (any, error) {
+	switch v := ds.(type) {
+	case nil:
+		// The default datasource
+		return v, nil
+	case string:
+		plugin, replace, err := d.datasourceReference(v, "")
+		if err != nil {
+			return nil, err
+		}
+		if plugin != "" {
+			d.datasources[plugin] = struct{}{}
+		}
+		if replace {
+			return dashboardDatasource, nil
+		}
+		return v, nil
+	case map[string]any:
+		uid, _ := v["uid"].(string)
+		typ, _ := v["type"].(string)
+		if uid == "" {
+			// Only the type is set, which selects the default datasource of that type. That is only portable for
+			// Prometheus, which the dashboard is imported with, and the builtin datasources.
+			if _, f := builtinDatasourceTypes[typ]; f || typ == "" {
+				return v, nil
+			}
+			if typ != "prometheus" {
+				return nil, fmt.Errorf("datasource of type %q cannot be templatized; only Prometheus is supported", typ)
+			}
+			d.datasources[typ] = struct{}{}
+			return v, nil
+		}
+		plugin, replace, err := d.datasourceReference(uid, typ)
+		if err != nil {
+			return nil, err
+		}
+		if plugin != "" {
+			d.datasources[plugin] = struct{}{}
+		}
+		if replace {
+			v["uid"] = dashboardDatasource
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unexpected datasource %v", ds)
+	}
+}
+
+// datasourceReference resolves a datasource name or uid, along with its plugin type if known. It returns the plugin
+// used, and whether the reference must be replaced with the DS_PROMETHEUS input.
+func (d *dashboardWalker) datasourceReference(ref, typ string) (string, bool, error) {
+	if _, f := builtinDatasources[ref]; f {
+		return "", false, nil
+	}
+	if ref == dashboardDatasource {
+		d.templated = true
+		return "prometheus", false, nil
+	}
+	if strings.HasPrefix(ref, "$") {
+		name := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(ref, "$"), "{"), "}")
+		plugin, f := d.variables[name]
+		if !f {
+			return "", false, fmt.Errorf("datasource %v refers to unknown variable %q", ref, name)
+		}
+		if typ != "" {
+			plugin = typ
+		}
+		return plugin, false, nil
+	}
+	if typ == "prometheus" || (typ == "" && strings.EqualFold(ref, "prometheus")) {
+		d.templated = true
+		return "prometheus", true, nil
+	}
+	return "", false, fmt.Errorf("datasource %q (type %q) cannot be templatized; only Prometheus is supported", ref, typ)
+}
+
+// requires builds the __requires list, sorted so the output is stable.
+func (d *dashboardWalker) requires(grafana string) []any {
+	res := []any{map[string]any{
+		"type":    "grafana",
+		"id":      "grafana",
+		"name":    "Grafana",
+		"version": grafana,
+	}}
+	for _, kind := range []struct {
+		typ     string
+		plugins map[string]struct{}
+	}{{"datasource", d.datasources}, {"panel", d.panels}} {
+		for _, id := range slices.Sorted(maps.Keys(kind.plugins)) {
+			name := pluginNames[id]
+			if name == "" {
+				name = id
+			}
+			res = append(res, map[string]any{
+				"type":    kind.typ,
+				"id":      id,
+				"name":    name,
+				"version": "",
+			})
+		}
+	}
+	return res
+}
@@ -0,0 +1,165 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExternalizeDashboard(t *testing.T) {
+	cases := []struct {
+		name      string
+		dashboard string
+		requires  []string
+		grafana   string
+		inputs    int
+		contains  []string
+		err       string
+	}{
+		{
+			name: "legacy string datasource",
+			dashboard: `{"schemaVersion": 27, "title": "Mesh", "panels": [
+				{"type": "graph", "datasource": "Prometheus"},
+				{"type": "table", "datasource": "Prometheus"}
+			]}`,
+			requires: []string{"grafana/grafana", "datasource/prometheus", "panel/graph", "panel/table"},
+			grafana:  "7.4.0",
+			inputs:   1,
+			contains: []string{`"datasource": "${DS_PROMETHEUS}"`},
+		},
+		{
+			name: "object datasource and nested rows",
+			dashboard: `{"schemaVersion": 27, "title": "Mesh", "panels": [
+				{"type": "row", "panels": [{"type": "timeseries", "datasource": {"type": "prometheus", "uid": "abc123"}}]},
+				{"type": "stat", "datasource": {"type": "prometheus", "uid": "Prometheus"}}
+			], "annotations": {"list": [{"datasource": {"type": "grafana", "uid": "-- Grafana --"}}]}}`,
+			requires: []string{"grafana/grafana", "datasource/prometheus", "panel/stat", "panel/timeseries"},
+			grafana:  "7.4.0",
+			inputs:   1,
+			contains: []string{`"uid": "${DS_PROMETHEUS}"`, `"uid": "-- Grafana --"`},
+		},
+		{
+			name: "datasource variable",
+			dashboard: `{"schemaVersion": 27, "title": "Mesh", "panels": [
+				{"type": "timeseries", "datasource": {"type": "prometheus", "uid": "${datasource}"}},
+				{"type": "gauge", "datasource": "$datasource"}
+			], "templating": {"list": [{"name": "datasource", "type": "datasource", "query": "prometheus"}]}}`,
+			requires: []string{"grafana/grafana", "datasource/prometheus", "panel/gauge", "panel/timeseries"},
+			grafana:  "7.4.0",
+			inputs:   0,
+			contains: []string{`"uid": "${datasource}"`, `"datasource": "$datasource"`},
+		},
+		{
+			name:      "unknown datasource",
+			dashboard: `{"schemaVersion": 27, "title": "Logs", "panels": [{"type": "logs", "datasource": {"type": "loki", "uid": "loki"}}]}`,
+			err:       "cannot be templatized",
+		},
+		{
+			name:      "default datasource of unknown type",
+			dashboard: `{"schemaVersion": 27, "title": "Logs", "panels": [{"type": "logs", "datasource": {"type": "loki"}}]}`,
+			err:       "cannot be templatized",
+		},
+		{
+			name: "version from schema",
+			dashboard: `{"schemaVersion": 39, "title": "Mesh", "panels": [
+				{"type": "timeseries", "datasource": {"type": "prometheus"}}
+			]}`,
+			requires: []string{"grafana/grafana", "datasource/prometheus", "panel/timeseries"},
+			grafana:  "10.0.0",
+			inputs:   0,
+		},
+		{
+			name:      "missing schema",
+			dashboard: `{"title": "Mesh"}`,
+			err:       "no schemaVersion",
+		},
+		{
+			name:      "unknown variable",
+			dashboard: `{"schemaVersion": 27, "title": "Mesh", "panels": [{"type": "graph", "datasource": "$ds"}]}`,
+			err:       "unknown variable",
+		},
+		{
+			name:      "existing description",
+			dashboard: `{"schemaVersion": 27, "title": "Mesh", "description": "hello"}`,
+			err:       "already has a description",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := externalizeDashboardJSON("1.2.3", []byte(tc.dashboard))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range tc.contains {
+				if !strings.Contains(string(result), c) {
+					t.Errorf("expected output to contain %v:\n%s", c, result)
+				}
+			}
+			var out struct {
+				Description string `json:"description"`
+				Inputs      []any  `json:"__inputs"`
+				Requires    []struct {
+					Type    string `json:"type"`
+					ID      string `json:"id"`
+					Version string `json:"version"`
+				} `json:"__requires"`
+			}
+			if err := json.Unmarshal(result, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.Description != "Mesh version 1.2.3" {
+				t.Errorf("unexpected description %q", out.Description)
+			}
+			if len(out.Inputs) != tc.inputs {
+				t.Errorf("expected %d inputs, got %v", tc.inputs, out.Inputs)
+			}
+			requires := []string{}
+			for _, r := range out.Requires {
+				requires = append(requires, r.Type+"/"+r.ID)
+			}
+			if out.Requires[0].Version != tc.grafana {
+				t.Errorf("expected grafana %v, got %v", tc.grafana, out.Requires[0].Version)
+			}
+			if !reflect.DeepEqual(requires, tc.requires) {
+				t.Errorf("expected requires %v, got %v", tc.requires, requires)
+			}
+		})
+	}
+}
+
+func TestDashboardInventory(t *testing.T) {
+	got := dashboardInventory(
+		[]string{"pilot-dashboard", "ztunnel-dashboard", "new-dashboard"},
+		map[string]int{"pilot-dashboard": 7645, "ztunnel-dashboard": 0, "old-dashboard": 1234},
+	)
+	want := []DashboardInventoryEntry{
+		{Name: "new-dashboard", Status: DashboardUnmapped},
+		{Name: "old-dashboard", ID: 1234, Status: DashboardMissing},
+		{Name: "pilot-dashboard", ID: 7645, Status: DashboardMapped},
+		{Name: "ztunnel-dashboard", Status: DashboardUnknownID},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+)
+
+// UnknownLicense is reported for dependencies whose license could not be detected.
+const UnknownLicense = "UNKNOWN"
+
+// LicenseEntry records the license of a single dependency.
+type LicenseEntry struct {
+	// Module is the path of the dependency within the licenses directory, typically the Go module path.
+	Module string `json:"module"`
+	// License is the SPDX license ID, or an SPDX expression joining several with AND.
+	License string `json:"license"`
+	// Repo is the source repository depending on the module.
+	Repo string `json:"repo"`
+}
+
+// licenseRule detects a license from its (normalized) text. All phrases must be present.
+type licenseRule struct {
+	id      string
+	phrases []string
+}
+
+// licenseRules are checked in order, so more specific licenses must come first.
+var licenseRules = []licenseRule{
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"MPL-2.0", []string{"mozilla public license", "version 2.0"}},
+	{"AGPL-3.0-only", []string{"gnu affero general public license"}},
+	{"LGPL-3.0-only", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1-only", []string{"gnu lesser general public license"}},
+	{"LGPL-2.0-only", []string{"gnu library general public license"}},
+	{"GPL-3.0-only", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0-only", []string{"gnu general public license", "version 2"}},
+	{"EPL-2.0", []string{"eclipse public license - v 2.0"}},
+	{"EPL-1.0", []string{"eclipse public license - v 1.0"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"BSL-1.0", []string{"boost software license - version 1.0"}},
+	{"Zlib", []string{"altered source versions must be plainly marked"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+}
+
+// classifyLicense returns the SPDX ID of a license text, or UnknownLicense.
+func classifyLicense(text []byte) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(string(text))), " ")
+	for _, rule := range licenseRules {
+		matched := true
+		for _, p := range rule.phrases {
+			if !strings.Contains(normalized, p) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rule.id
+		}
+	}
+	return UnknownLicense
+}
+
+// isLicenseFile determines if a file holds license text. NOTICE files are attributions, not licenses.
+func isLicenseFile(name string) bool {
+	upper := strings.ToUpper(name)
+	return strings.Contains(upper, "LICENSE") || strings.Contains(upper, "LICENCE") || strings.HasPrefix(upper, "COPYING")
+}
+
+// repoLicenses classifies the licenses in a repo's licenses directory. Each directory holding a license file is
+// a dependency, named by its path relative to the licenses directory.
+func repoLicenses(repo, dir string, overrides map[string]string) ([]LicenseEntry, error) {
+	found := map[string]map[string]struct{}{}
+	if err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !isLicenseFile(fi.Name()) {
+			return nil
+		}
+		module, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		text, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if found[module] == nil {
+			found[module] = map[string]struct{}{}
+		}
+		found[module][classifyLicense(text)] = struct{}{}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read licenses of %v: %v", repo, err)
+	}
+	res := []LicenseEntry{}
+	for module, ids := range found {
+		license := overrides[module]
+		if license == "" {
+			// Only fall back to unknown if nothing else was detected, as license directories often hold extra files
+			if len(ids) > 1 {
+				delete(ids, UnknownLicense)
+			}
+			sorted := []string{}
+			for id := range ids {
+				sorted = append(sorted, id)
+			}
+			slices.Sort(sorted)
+			license = strings.Join(sorted, " AND ")
+		}
+		res = append(res, LicenseEntry{Module: module, License: license, Repo: repo})
+	}
+	return res, nil
+}
+
+// licenseViolations returns a description of each entry with a denied or unknown license.
+func licenseViolations(entries []LicenseEntry, deny []string) []string {
+	res := []string{}
+	for _, e := range entries {
+		for _, id := range strings.Split(e.License, " AND ") {
+			if id == UnknownLicense {
+				res = append(res, fmt.Sprintf("%v (from %v) has no detectable license", e.Module, e.Repo))
+				continue
+			}
+			for _, d := range deny {
+				if m, _ := path.Match(d, id); m {
+					res = append(res, fmt.Sprintf("%v (from %v) uses denied license %v", e.Module, e.Repo, id))
+				}
+			}
+		}
+	}
+	return res
+}
+
+var licenseReportTemplate = template.Must(template.New("licenses").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Istio {{ .Version }} licenses</title>
+</head>
+<body>
+<h1>Istio {{ .Version }} licenses</h1>
+<table>
+<tr><th>Module</th><th>License</th><th>Repository</th></tr>
+{{- range .Entries }}
+<tr><td>{{ .Module }}</td><td>{{ .License }}</td><td>{{ .Repo }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// LicenseReport builds a consolidated inventory of the licenses of all dependencies, from the licenses directory
+// of each repo. This is written as licenses/report.json and licenses/report.html. The build fails if any dependency
+// uses a license on the manifest deny-list, or has no detectable license.
+func LicenseReport(manifest model.Manifest) error {
+	entries := []LicenseEntry{}
+	for repo := range manifest.Dependencies.Get() {
+		src := filepath.Join(manifest.RepoDir(repo), "licenses")
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		repoEntries, err := repoLicenses(repo, src, manifest.Licenses.Overrides)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, repoEntries...)
+	}
+	slices.SortFunc(entries, func(a, b LicenseEntry) int {
+		if c := strings.Compare(a.Repo, b.Repo); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Module, b.Module)
+	})
+
+	dir := filepath.Join(manifest.OutDir(), "licenses")
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create license dir: %v", err)
+	}
+	js, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal license report: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "report.json"), js, 0o644); err != nil {
+		return fmt.Errorf("failed to write license report: %v", err)
+	}
+	html := &bytes.Buffer{}
+	if err := licenseReportTemplate.Execute(html, map[string]any{"Version": manifest.Version, "Entries": entries}); err != nil {
+		return fmt.Errorf("failed to render license report: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "report.html"), html.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write license report: %v", err)
+	}
+	log.Infof("Wrote license report for %d dependencies", len(entries))
+
+	if violations := licenseViolations(entries, manifest.Licenses.Deny); len(violations) > 0 {
+		for _, v := range violations {
+			log.Errorf("license violation: %v", v)
+		}
+		return fmt.Errorf("found %d license violations: %v", len(violations), strings.Join(violations, "; "))
+	}
+	return nil
+}
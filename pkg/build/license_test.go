@@ -0,0 +1,74 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRepoLicenses(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"github.com/a/apache/LICENSE": `Apache License
+                           Version 2.0, January 2004`,
+		"github.com/b/mit/LICENSE.md": `Permission is hereby granted, free of charge, to any person obtaining a copy`,
+		"github.com/b/mit/NOTICE":     `Copyright b`,
+		"github.com/c/bsd/LICENSE": `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+   * Neither the name of Google Inc. nor the names of its contributors`,
+		"github.com/d/gpl/COPYING":         `GNU GENERAL PUBLIC LICENSE Version 3, 29 June 2007`,
+		"github.com/e/unknown/LICENSE":     `All rights reserved.`,
+		"github.com/f/override/LICENSE":    `Custom terms`,
+		"github.com/g/dual/LICENSE-MIT":    `Permission is hereby granted, free of charge`,
+		"github.com/g/dual/LICENSE-APACHE": `Apache License Version 2.0`,
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := repoLicenses("istio", dir, map[string]string{"github.com/f/override": "MIT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, e := range entries {
+		got[e.Module] = e.License
+	}
+	want := map[string]string{
+		"github.com/a/apache":   "Apache-2.0",
+		"github.com/b/mit":      "MIT",
+		"github.com/c/bsd":      "BSD-3-Clause",
+		"github.com/d/gpl":      "GPL-3.0-only",
+		"github.com/e/unknown":  UnknownLicense,
+		"github.com/f/override": "MIT",
+		"github.com/g/dual":     "Apache-2.0 AND MIT",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	violations := licenseViolations(entries, []string{"GPL-*"})
+	if len(violations) != 2 {
+		t.Fatalf("expected violations for gpl and unknown, got %v", violations)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+	"istio.io/release-builder/pkg/util"
+)
+
+const (
+	// aptSuite is the single suite (and codename) of the APT repository. Each release gets its own repository,
+	// so there is no need to distinguish between suites.
+	aptSuite = "stable"
+	// aptComponent is the single component of the APT repository.
+	aptComponent = "main"
+)
+
+// PackageRepository turns the sidecar packages produced by Debian and Rpm into APT and YUM repositories.
+// The repositories are written to out/apt and out/yum, so they are uploaded along with the rest of the release
+// by the GCS and S3 publishers and can be consumed directly with `apt install istio-sidecar` or `yum install istio-sidecar`.
+// If signer is set, the repository metadata is signed as well.
+func PackageRepository(manifest model.Manifest, signer *PackageSigner) error {
+	if _, f := manifest.BuildOutputs[model.Debian]; f {
+		if err := aptRepository(manifest, signer); err != nil {
+			return fmt.Errorf("failed to create apt repository: %v", err)
+		}
+	} else {
+		log.Warnf("Debian output not enabled; skipping apt repository")
+	}
+	if _, f := manifest.BuildOutputs[model.Rpm]; f {
+		if err := yumRepository(manifest, signer); err != nil {
+			return fmt.Errorf("failed to create yum repository: %v", err)
+		}
+	} else {
+		log.Warnf("Rpm output not enabled; skipping yum repository")
+	}
+	return nil
+}
+
+// aptRepository lays out a flat APT repository:
+//
+//	apt/pool/main/i/istio-sidecar/istio-sidecar_<version>_<arch>.deb
+//	apt/dists/stable/main/binary-<arch>/Packages{,.gz}
+//	apt/dists/stable/Release
+//	apt/dists/stable/{InRelease,Release.gpg} (if signed)
+func aptRepository(manifest model.Manifest, signer *PackageSigner) error {
+	repo := path.Join(manifest.OutDir(), "apt")
+	pool := path.Join("pool", aptComponent, "i", "istio-sidecar")
+	archs := []string{}
+	for _, plat := range manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		archs = append(archs, arch)
+		src := path.Join(manifest.OutDir(), "deb", util.SidecarPackageName(arch, "deb"))
+		dst := path.Join(repo, pool, fmt.Sprintf("istio-sidecar_%s_%s.deb", manifest.Version, arch))
+		if err := util.CopyFile(src, dst); err != nil {
+			return err
+		}
+	}
+
+	for _, arch := range archs {
+		binaryDir := path.Join(repo, "dists", aptSuite, aptComponent, "binary-"+arch)
+		if err := os.MkdirAll(binaryDir, 0o750); err != nil {
+			return err
+		}
+		// Paths in the index must be relative to the repository root, so run from there.
+		packages, err := runInDir(repo, "apt-ftparchive", "--arch", arch, "packages", "pool")
+		if err != nil {
+			return fmt.Errorf("failed to index packages for %v: %v", arch, err)
+		}
+		if err := os.WriteFile(path.Join(binaryDir, "Packages"), packages, 0o644); err != nil {
+			return err
+		}
+		if err := writeGzip(path.Join(binaryDir, "Packages.gz"), packages); err != nil {
+			return err
+		}
+	}
+
+	release, err := runInDir(repo, "apt-ftparchive",
+		"-o", "APT::FTPArchive::Release::Origin=Istio",
+		"-o", "APT::FTPArchive::Release::Label=Istio",
+		"-o", "APT::FTPArchive::Release::Suite="+aptSuite,
+		"-o", "APT::FTPArchive::Release::Codename="+aptSuite,
+		"-o", "APT::FTPArchive::Release::Version="+manifest.Version,
+		"-o", "APT::FTPArchive::Release::Architectures="+strings.Join(archs, " "),
+		"-o", "APT::FTPArchive::Release::Components="+aptComponent,
+		"release", path.Join("dists", aptSuite))
+	if err != nil {
+		return fmt.Errorf("failed to generate Release: %v", err)
+	}
+	releaseFile := path.Join(repo, "dists", aptSuite, "Release")
+	if err := os.WriteFile(releaseFile, release, 0o644); err != nil {
+		return err
+	}
+	if signer != nil {
+		if err := signer.ClearSign(releaseFile, path.Join(repo, "dists", aptSuite, "InRelease")); err != nil {
+			return err
+		}
+		if err := signer.DetachSign(releaseFile, releaseFile+".gpg"); err != nil {
+			return err
+		}
+	}
+	log.Infof("Wrote apt repository to %v", repo)
+	return nil
+}
+
+// yumRepository lays out a YUM repository holding all architectures:
+//
+//	yum/Packages/istio-sidecar-<version>.<rpm arch>.rpm
+//	yum/repodata/repomd.xml
+//	yum/repodata/primary.xml.gz
+//	yum/repodata/repomd.xml.asc (if signed)
+func yumRepository(manifest model.Manifest, signer *PackageSigner) error {
+	repo := path.Join(manifest.OutDir(), "yum")
+	for _, plat := range manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		rpmArch, f := util.RpmArchitectures[arch]
+		if !f {
+			return fmt.Errorf("unsupported rpm architecture: %v", arch)
+		}
+		src := path.Join(manifest.OutDir(), "rpm", util.SidecarPackageName(arch, "rpm"))
+		dst := path.Join(repo, "Packages", fmt.Sprintf("istio-sidecar-%s.%s.rpm", manifest.Version, rpmArch))
+		if err := util.CopyFile(src, dst); err != nil {
+			return err
+		}
+	}
+	// gzip metadata is readable by every yum and dnf version, unlike the zstd default of newer createrepo_c.
+	cmd := util.VerboseCommand("createrepo_c", "--no-database", "--simple-md-filenames", "--general-compress-type", "gz", ".")
+	cmd.Dir = repo
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to generate repodata: %v", err)
+	}
+	if signer != nil {
+		repomd := path.Join(repo, "repodata", "repomd.xml")
+		if err := signer.DetachSign(repomd, repomd+".asc"); err != nil {
+			return err
+		}
+	}
+	log.Infof("Wrote yum repository to %v", repo)
+	return nil
+}
+
+// runInDir runs a command in the given directory, returning its stdout.
+func runInDir(dir string, name string, arg ...string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	cmd := util.VerboseCommand(name, arg...)
+	cmd.Dir = dir
+	cmd.Stdout = buf
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeGzip(dst string, contents []byte) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %v: %v", dst, err)
+	}
+	defer f.Close()
+	w := gzip.NewWriter(f)
+	if _, err := w.Write(contents); err != nil {
+		return fmt.Errorf("failed to write %v: %v", dst, err)
+	}
+	return w.Close()
+}
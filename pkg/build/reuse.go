@@ -0,0 +1,270 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+	"istio.io/release-builder/pkg/util"
+)
+
+// fingerprintsFile records the input fingerprint of each output in the output directory, so a later build
+// can reuse the outputs whose inputs are unchanged.
+const fingerprintsFile = "fingerprints.json"
+
+// reusableOutput describes an output that can be copied forward from a previous build.
+type reusableOutput struct {
+	name string
+	// repos are the repositories the output is built from.
+	repos []string
+	// files are the paths, relative to the output directory, holding the output. These may be globs.
+	files []string
+	// signed is set for outputs signed with the package signing key.
+	signed bool
+	// config returns output specific manifest configuration that affects the output.
+	config func(manifest model.Manifest) any
+}
+
+var reusableOutputs = map[model.BuildOutput]reusableOutput{
+	model.Docker: {
+		name:  "docker",
+		repos: []string{"istio", "proxy", "ztunnel"},
+		files: []string{"docker"},
+		config: func(manifest model.Manifest) any {
+			return []any{manifest.DockerOutput, manifest.ProxyOverride, manifest.Variants}
+		},
+	},
+	model.Helm: {
+		name:  "helm",
+		repos: []string{"istio"},
+		files: []string{"helm"},
+	},
+	model.Debian: {
+		name:   "debian",
+		repos:  []string{"istio", "proxy"},
+		files:  []string{"deb"},
+		signed: true,
+		config: func(manifest model.Manifest) any {
+			return manifest.ProxyOverride
+		},
+	},
+	model.Rpm: {
+		name:   "rpm",
+		repos:  []string{"istio", "proxy"},
+		files:  []string{"rpm"},
+		signed: true,
+		config: func(manifest model.Manifest) any {
+			return manifest.ProxyOverride
+		},
+	},
+	model.PackageRepository: {
+		name:   "repository",
+		repos:  []string{"istio", "proxy"},
+		files:  []string{"apt", "yum"},
+		signed: true,
+		config: func(manifest model.Manifest) any {
+			_, deb := manifest.BuildOutputs[model.Debian]
+			_, rpm := manifest.BuildOutputs[model.Rpm]
+			return []any{manifest.ProxyOverride, deb, rpm}
+		},
+	},
+	model.Archive: {
+		name:  "archive",
+		repos: []string{"istio"},
+		files: []string{"istio-*.tar.gz", "istio-*.tar.gz.sha256", "istio-*.zip", "istio-*.zip.sha256", "istioctl-*"},
+	},
+	model.Grafana: {
+		name:  "grafana",
+		repos: []string{"istio"},
+		files: []string{"grafana", "grafana-inventory.json"},
+		config: func(manifest model.Manifest) any {
+			return []any{manifest.GrafanaDashboards, manifest.StrictDashboards}
+		},
+	},
+}
+
+// OutputFingerprint is the record of a single output in the fingerprints file.
+type OutputFingerprint struct {
+	Fingerprint string `json:"fingerprint"`
+	// Reused is set if the output was copied forward from a previous build.
+	Reused bool `json:"reused"`
+}
+
+// outputReuse builds outputs, or copies them from a previous build when their inputs are unchanged.
+type outputReuse struct {
+	manifest model.Manifest
+	signer   *PackageSigner
+	// previousDir is the output directory of the previous build. If unset, all outputs are built.
+	previousDir string
+	previous    map[string]OutputFingerprint
+	current     map[string]OutputFingerprint
+}
+
+func newOutputReuse(manifest model.Manifest, previousDir string, signer *PackageSigner) (*outputReuse, error) {
+	r := &outputReuse{
+		manifest:    manifest,
+		signer:      signer,
+		previousDir: previousDir,
+		previous:    map[string]OutputFingerprint{},
+		current:     map[string]OutputFingerprint{},
+	}
+	if previousDir == "" {
+		return r, nil
+	}
+	prev, err := filepath.Abs(previousDir)
+	if err != nil {
+		return nil, err
+	}
+	out, err := filepath.Abs(manifest.OutDir())
+	if err != nil {
+		return nil, err
+	}
+	if prev == out {
+		return nil, fmt.Errorf("cannot reuse outputs from the output directory %v", out)
+	}
+	by, err := os.ReadFile(path.Join(previousDir, fingerprintsFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read previous fingerprints: %v", err)
+	}
+	if err := json.Unmarshal(by, &r.previous); err != nil {
+		return nil, fmt.Errorf("failed to parse previous fingerprints: %v", err)
+	}
+	return r, nil
+}
+
+// outputFingerprint hashes the inputs of an output: the version, hub, architectures, SHAs of the repos it is
+// built from, and any output specific configuration.
+func outputFingerprint(manifest model.Manifest, o reusableOutput, signer *PackageSigner) (string, error) {
+	deps := manifest.Dependencies.Get()
+	shas := map[string]string{}
+	for _, repo := range o.repos {
+		if dep := deps[repo]; dep != nil {
+			shas[repo] = dep.Sha
+		}
+	}
+	inputs := map[string]any{
+		"output":        o.name,
+		"version":       manifest.Version,
+		"docker":        manifest.Docker,
+		"architectures": slices.Sorted(slices.Values(manifest.Architectures)),
+		"repos":         shas,
+	}
+	if o.config != nil {
+		inputs["config"] = o.config(manifest)
+	}
+	if o.signed && signer != nil {
+		inputs["signer"] = signer.fingerprint
+	}
+	// Maps are marshaled with sorted keys, so the fingerprint is stable.
+	by, err := json.Marshal(inputs)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(by)), nil
+}
+
+// Build runs build, unless the output is unchanged since the previous build, in which case it is copied forward.
+func (r *outputReuse) Build(output model.BuildOutput, build func() error) error {
+	o, f := reusableOutputs[output]
+	if !f {
+		return build()
+	}
+	fingerprint, err := outputFingerprint(r.manifest, o, r.signer)
+	if err != nil {
+		return fmt.Errorf("failed to fingerprint %v: %v", o.name, err)
+	}
+	reusable := r.previousDir != "" && r.previous[o.name].Fingerprint == fingerprint
+	// Images loaded into the docker context are not in the output directory, so cannot be copied.
+	if output == model.Docker && r.manifest.DockerOutput == model.DockerOutputContext {
+		reusable = false
+	}
+	if reusable {
+		reused, err := r.copyForward(o)
+		if err != nil {
+			return fmt.Errorf("failed to reuse %v: %v", o.name, err)
+		}
+		if reused {
+			r.current[o.name] = OutputFingerprint{Fingerprint: fingerprint, Reused: true}
+			return nil
+		}
+	}
+	if err := build(); err != nil {
+		return err
+	}
+	r.current[o.name] = OutputFingerprint{Fingerprint: fingerprint}
+	return nil
+}
+
+// copyForward copies the files of an output from the previous build. Returns false, without copying anything,
+// if the previous build is missing any of the files.
+func (r *outputReuse) copyForward(o reusableOutput) (bool, error) {
+	matches := []string{}
+	for _, pattern := range o.files {
+		m, err := filepath.Glob(path.Join(r.previousDir, pattern))
+		if err != nil {
+			return false, err
+		}
+		if len(m) == 0 {
+			log.Warnf("Previous build is missing %v for %v; rebuilding", pattern, o.name)
+			return false, nil
+		}
+		matches = append(matches, m...)
+	}
+	for _, src := range matches {
+		dst := path.Join(r.manifest.OutDir(), path.Base(src))
+		fi, err := os.Stat(src)
+		if err != nil {
+			return false, err
+		}
+		if fi.IsDir() {
+			err = util.CopyDir(src, dst)
+		} else {
+			err = util.CopyFile(src, dst)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	log.Infof("Reused %v from %v", o.name, r.previousDir)
+	return true, nil
+}
+
+// Write records the output fingerprints in the output directory, and reports which outputs were reused.
+func (r *outputReuse) Write() error {
+	for _, name := range slices.Sorted(maps.Keys(r.current)) {
+		if r.current[name].Reused {
+			log.Infof("Output %v: reused from %v", name, r.previousDir)
+		} else {
+			log.Infof("Output %v: built", name)
+		}
+	}
+	by, err := json.MarshalIndent(r.current, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal fingerprints: %v", err)
+	}
+	if err := os.WriteFile(path.Join(r.manifest.OutDir(), fingerprintsFile), by, 0o644); err != nil {
+		return fmt.Errorf("failed to write fingerprints: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+func TestOutputReuse(t *testing.T) {
+	manifest := func(dir, proxy string) model.Manifest {
+		return model.Manifest{
+			Directory: dir,
+			Version:   "1.2.3",
+			Docker:    "docker.io/istio",
+			Dependencies: model.IstioDependencies{
+				Istio: &model.Dependency{Sha: "istio-sha"},
+				Proxy: &model.Dependency{Sha: proxy},
+			},
+		}
+	}
+	build := func(m model.Manifest, previous string) (map[string]bool, error) {
+		r, err := newOutputReuse(m, previous, nil)
+		if err != nil {
+			return nil, err
+		}
+		built := map[string]bool{}
+		outputs := map[model.BuildOutput]string{model.Helm: "helm", model.Debian: "deb"}
+		for o, dir := range outputs {
+			if err := r.Build(o, func() error {
+				built[dir] = true
+				return os.WriteFile(filepath.Join(m.OutDir(), dir, "file"), []byte(dir), 0o644)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		return built, r.Write()
+	}
+	setup := func(m model.Manifest) {
+		for _, dir := range []string{"helm", "deb"} {
+			if err := os.MkdirAll(filepath.Join(m.OutDir(), dir), 0o750); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	first := manifest(t.TempDir(), "proxy-1")
+	setup(first)
+	if _, err := build(first, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the proxy changed, so helm is reused and the deb rebuilt.
+	second := manifest(t.TempDir(), "proxy-2")
+	if err := os.MkdirAll(filepath.Join(second.OutDir(), "deb"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	built, err := build(second, first.OutDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built["helm"] || !built["deb"] {
+		t.Fatalf("expected only deb to be built, got %v", built)
+	}
+	if by, err := os.ReadFile(filepath.Join(second.OutDir(), "helm", "file")); err != nil || string(by) != "helm" {
+		t.Fatalf("helm was not copied forward: %v", err)
+	}
+
+	if _, err := newOutputReuse(second, second.OutDir(), nil); err == nil {
+		t.Fatal("expected reusing the output directory to fail")
+	}
+}
@@ -23,30 +23,33 @@ import (
 	"istio.io/release-builder/pkg/util"
 )
 
-// Rpm produces an rpm package just for the sidecar
-func Rpm(manifest model.Manifest) error {
+// Rpm produces an rpm package just for the sidecar. If signer is set, each package gets a header signature.
+func Rpm(manifest model.Manifest, signer *PackageSigner) error {
 	for _, plat := range manifest.Architectures {
 		_, arch, _ := strings.Cut(plat, "/")
 		envs := []string{"TARGET_ARCH=" + arch}
-		output := "istio-sidecar.rpm"
-		if arch != "amd64" {
-			output = fmt.Sprintf("istio-sidecar-%s.rpm", arch)
-		}
+		output := util.SidecarPackageName(arch, "rpm")
 
-		if err := runRpm(manifest, envs, arch, output); err != nil {
+		if err := runRpm(manifest, envs, arch, output, signer); err != nil {
 			return fmt.Errorf("failed to run rpm for arch %s: %v", arch, err)
 		}
 	}
 	return nil
 }
 
-func runRpm(manifest model.Manifest, envs []string, arch, output string) error {
+func runRpm(manifest model.Manifest, envs []string, arch, output string, signer *PackageSigner) error {
 	if err := util.RunMake(manifest, "istio", envs, "rpm/fpm"); err != nil {
 		return fmt.Errorf("failed to build sidecar.rpm: %v", err)
 	}
 	if err := util.CopyFile(path.Join(manifest.RepoArchOutDir("istio", arch), "istio-sidecar.rpm"), path.Join(manifest.OutDir(), "rpm", output)); err != nil {
 		return fmt.Errorf("failed to package istio-sidecar.rpm: %v", err)
 	}
+	// Signing rewrites the package, so this must happen before computing the SHA
+	if signer != nil {
+		if err := signer.SignRpm(path.Join(manifest.OutDir(), "rpm", output)); err != nil {
+			return err
+		}
+	}
 	if err := util.CreateSha(path.Join(manifest.OutDir(), "rpm", output)); err != nil {
 		return fmt.Errorf("failed to package istio-sidecar.rpm: %v", err)
 	}
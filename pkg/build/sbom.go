@@ -19,56 +19,241 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
 	"istio.io/istio/pkg/log"
 	"istio.io/release-builder/pkg/model"
+	"istio.io/release-builder/pkg/sbom"
 	"istio.io/release-builder/pkg/util"
 )
 
-// Sbom generates Software Bill Of Materials for istio repo in an SPDX readable format.
+// Sbom generates Software Bill Of Materials for the istio release, source, and each image, in both SPDX and
+// CycloneDX formats:
+//
+//	istio-release.{spdx,cdx}.json: release artifacts, including archive contents and images
+//	istio-source.{spdx,cdx}.json: Go modules and Rust crates of all source repositories
+//	sbom/<image>[-variant][-arch].{spdx,cdx}.json: a single image, attached to the image on publish
 func GenerateBillOfMaterials(manifest model.Manifest) error {
-	// Retrieve istio repository path to run the sbom generator
-	istioRepoDir := manifest.RepoDir("istio")
-	sourceSbomFile := path.Join(manifest.OutDir(), "istio-source.spdx")
-	sourceSbomNamespace := fmt.Sprintf("https://storage.googleapis.com/istio-release/releases/%s/istio-source.spdx",
-		manifest.Version)
-	releaseSbomFile := path.Join(manifest.OutDir(), "istio-release.spdx")
-	releaseSbomNamespace := fmt.Sprintf("https://storage.googleapis.com/istio-release/releases/%s/istio-release.spdx",
-		manifest.Version)
+	images, err := releaseImages(manifest)
+	if err != nil {
+		return err
+	}
+	imageDocs := []*sbom.Document{}
+	for _, img := range images {
+		tag := manifest.Version
+		if img.variant != "" {
+			tag += "-" + img.variant
+		}
+		doc, err := sbom.Image(img.image, tag, img.img)
+		if err != nil {
+			return err
+		}
+		imageDocs = append(imageDocs, doc)
+	}
 
-	// construct all the docker image tarball names as bom currently cannot accept directory as input
-	dockerDir := path.Join(manifest.OutDir(), "docker")
-	dockerImages := []string{}
-	if err := filepath.Walk(dockerDir, func(path string, fi os.FileInfo, err error) error {
+	log.Infof("Generating Software Bill of Materials for istio release artifacts")
+	release, err := releaseBillOfMaterials(manifest, imageDocs)
+	if err != nil {
+		return fmt.Errorf("couldn't generate sbom for istio release artifacts: %v", err)
+	}
+
+	log.Infof("Generating Software Bill of Materials for istio source code")
+	source, err := sourceBillOfMaterials(manifest)
+	if err != nil {
+		return fmt.Errorf("couldn't generate sbom for istio source: %v", err)
+	}
+
+	// The release and source SBOMs keep their historical tag-value <name>.spdx files, so existing download URLs work.
+	if err := writeBillOfMaterials(manifest, release, "Istio Release "+manifest.Version, "istio-release", true); err != nil {
+		return err
+	}
+	if err := writeBillOfMaterials(manifest, source, "Istio Source "+manifest.Version, "istio-source", true); err != nil {
+		return err
+	}
+
+	// Write an SBOM for each image, so it can be attached to the image when it is published.
+	log.Infof("Generating Software Bill of Materials for istio images")
+	for i, img := range images {
+		name := util.ImageArchiveName(img.image, img.variant, img.arch)
+		if err := writeBillOfMaterials(manifest, imageDocs[i], fmt.Sprintf("Istio %s %s", img.image, manifest.Version),
+			path.Join("sbom", name), false); err != nil {
+			return fmt.Errorf("couldn't generate sbom for image %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// writeBillOfMaterials writes the document as <name>.spdx.json and <name>.cdx.json in the output directory, and with
+// tagValue, additionally as SPDX tag-value in <name>.spdx.
+func writeBillOfMaterials(manifest model.Manifest, doc *sbom.Document, title string, name string, tagValue bool) error {
+	spdxFile := name + ".spdx.json"
+	doc = doc.Named(title, fmt.Sprintf("%s/%s/%s", manifest.SbomNamespace, manifest.Version, spdxFile))
+	spdx, err := doc.SPDX()
+	if err != nil {
+		return fmt.Errorf("failed to marshal %v: %v", spdxFile, err)
+	}
+	cdx, err := doc.CycloneDX()
+	if err != nil {
+		return fmt.Errorf("failed to marshal %v: %v", name, err)
+	}
+	dst := path.Join(manifest.OutDir(), name)
+	if err := os.MkdirAll(path.Dir(dst), 0o750); err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst+".spdx.json", spdx, 0o644); err != nil {
+		return fmt.Errorf("failed to write %v: %v", spdxFile, err)
+	}
+	if err := os.WriteFile(dst+".cdx.json", cdx, 0o644); err != nil {
+		return fmt.Errorf("failed to write %v: %v", name+".cdx.json", err)
+	}
+	if tagValue {
+		// Each SPDX document needs its own namespace, so the tag-value document is named after its file.
+		tv := doc.Named(title, fmt.Sprintf("%s/%s/%s", manifest.SbomNamespace, manifest.Version, name+".spdx")).SPDXTagValue()
+		if err := os.WriteFile(dst+".spdx", tv, 0o644); err != nil {
+			return fmt.Errorf("failed to write %v: %v", name+".spdx", err)
+		}
+	}
+	return nil
+}
+
+// releaseBillOfMaterials describes every artifact in the release output, along with the images.
+func releaseBillOfMaterials(manifest model.Manifest, imageDocs []*sbom.Document) (*sbom.Document, error) {
+	root := &sbom.Package{
+		ID:      sbom.NewID("istio-release"),
+		Name:    "istio-release",
+		Version: manifest.Version,
+		Type:    sbom.Application,
+	}
+	doc := sbom.NewDocument("", "", root)
+	out := manifest.OutDir()
+	if err := filepath.Walk(out, func(p string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if fi == nil {
-			return fmt.Errorf("failed to get fileinfo for file at path %s", path)
+		rel, err := filepath.Rel(out, p)
+		if err != nil {
+			return err
 		}
 		if fi.IsDir() {
+			// Images are described from their contents, licenses are covered by the source SBOM, SBOMs
+			// cannot describe themselves, and logs are not part of the release.
+			if rel == "docker" || rel == "licenses" || rel == "sbom" || rel == "logs" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(rel, ".sha256") || strings.HasSuffix(rel, ".spdx") || strings.HasSuffix(rel, ".spdx.json") ||
+			strings.HasSuffix(rel, ".cdx.json") {
 			return nil
 		}
-		dockerImages = append(dockerImages, path)
+		artifact, err := sbom.Artifact(rel, p)
+		if err != nil {
+			return err
+		}
+		doc.Merge(root.ID, sbom.Contains, artifact)
 		return nil
 	}); err != nil {
-		return fmt.Errorf("failed to walk directory %s: %v", dockerDir, err)
+		return nil, fmt.Errorf("failed to walk directory %s: %v", out, err)
+	}
+	for _, img := range imageDocs {
+		doc.Merge(root.ID, sbom.Contains, img)
 	}
+	return doc, nil
+}
 
-	// Run bom generator to generate the software bill of materials(SBOM) for istio.
-	log.Infof("Generating Software Bill of Materials for istio release artifacts")
-	if err := util.VerboseCommand("bom", "--log-level", "error", "generate", "--name", "Istio Release "+manifest.Version,
-		"--namespace", releaseSbomNamespace, "--ignore", "licenses,'*.sha256',docker", "--dirs", manifest.OutDir(),
-		"--image-archive", strings.Join(dockerImages, ","), "--output", releaseSbomFile).Run(); err != nil {
-		return fmt.Errorf("couldn't generate sbom for istio release artifacts: %v", err)
+// sourceBillOfMaterials describes the Go module graphs and Rust crates of all source repositories.
+func sourceBillOfMaterials(manifest model.Manifest) (*sbom.Document, error) {
+	root := &sbom.Package{
+		ID:      sbom.NewID("istio-source"),
+		Name:    "istio-source",
+		Version: manifest.Version,
+		Type:    sbom.Source,
+	}
+	doc := sbom.NewDocument("", "", root)
+	deps := manifest.Dependencies.Get()
+	repos := []string{}
+	for repo, dep := range deps {
+		if dep != nil {
+			repos = append(repos, repo)
+		}
 	}
+	slices.Sort(repos)
+	for _, repo := range repos {
+		goMod := path.Join(manifest.RepoDir(repo), "go.mod")
+		if _, err := os.Stat(goMod); err == nil {
+			modules, err := sbom.GoModules(goMod)
+			if err != nil {
+				return nil, err
+			}
+			doc.Merge(root.ID, sbom.Contains, modules)
+		}
+		cargoLock := path.Join(manifest.RepoDir(repo), "Cargo.lock")
+		if _, err := os.Stat(cargoLock); err == nil {
+			crates, err := sbom.CargoPackages(repo, cargoLock)
+			if err != nil {
+				return nil, err
+			}
+			doc.Merge(root.ID, sbom.Contains, crates)
+		}
+	}
+	return doc, nil
+}
 
-	// Run bom generator to generate the software bill of materials(SBOM) for istio.
-	log.Infof("Generating Software Bill of Materials for istio source code")
-	if err := util.VerboseCommand("bom", "--log-level", "error", "generate", "--name", "Istio Source "+manifest.Version,
-		"--namespace", sourceSbomNamespace, "--dirs", istioRepoDir, "--output", sourceSbomFile).Run(); err != nil {
-		return fmt.Errorf("couldn't generate sbom for istio source: %v", err)
+// releaseImage is a single architecture of an image in the release.
+type releaseImage struct {
+	image   string
+	variant string
+	arch    string
+	// file is the docker archive or image layout in the docker output holding the image.
+	file string
+	img  v1.Image
+}
+
+// releaseImages reads all images in the docker output, from either docker archives or OCI image layouts.
+func releaseImages(manifest model.Manifest) ([]releaseImage, error) {
+	dockerDir := path.Join(manifest.OutDir(), "docker")
+	entries, err := os.ReadDir(dockerDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker output: %v", err)
 	}
-	return nil
+	res := []releaseImage{}
+	add := func(file, image, variant string, img v1.Image) error {
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return fmt.Errorf("failed to get config of %v: %v", file, err)
+		}
+		res = append(res, releaseImage{image: image, variant: variant, arch: cfg.Architecture, file: file, img: img})
+		return nil
+	}
+	for _, e := range entries {
+		if manifest.DockerOutput == model.DockerOutputOCI {
+			images, err := util.LayoutImages(path.Join(dockerDir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for variant, imgs := range images {
+				for _, img := range imgs {
+					if err := add(e.Name(), e.Name(), variant, img); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+		img, err := util.ImageFromArchive(path.Join(dockerDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		image, variant, _, err := util.ImageNameVariant(manifest, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := add(e.Name(), image, variant, img); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
 }
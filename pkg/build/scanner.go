@@ -15,10 +15,9 @@
 package build
 
 import (
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -46,18 +45,19 @@ var alwaysGenerateBaseImage = func() bool {
 	return b
 }()
 
-// Scanner checks the base image for any CVEs.
-func Scanner(manifest model.Manifest, githubToken, git, branch string) error {
+// baseVersionMakefile holds the BASE_VERSION variable, relative to the istio repo.
+const baseVersionMakefile = "Makefile.core.mk"
+
+// Scanner checks the base image for any CVEs. If any fixable CVEs are found, new base images are built and a PR
+// is created to use them. With dryRun set, the planned rebuild is printed instead.
+func Scanner(manifest model.Manifest, githubToken, git, branch string, dryRun bool) error {
 	// Retrieve BASE_VERSION from the istio/istio Makefile
 	istioDir := manifest.RepoDir("istio")
-	var out bytes.Buffer
-	grepCmd := exec.Command("grep", "BASE_VERSION", istioDir+"/Makefile.core.mk")
-	grepCmd.Stdout = &out
-	err := grepCmd.Run()
+	makefile := path.Join(istioDir, baseVersionMakefile)
+	baseVersion, err := util.ReadMakefileVariable(makefile, "BASE_VERSION")
 	if err != nil {
-		return fmt.Errorf("grep error: %v", err)
+		return err
 	}
-	baseVersion := strings.TrimSpace(strings.Split(out.String(), " ")[2]) // Assumes line of the form BASE_VERSION ?= baseVersion
 
 	// Call image scanner passing in base image name. If request times out, retry the request
 	istioBaseRegistry := os.Getenv("ISTIO_BASE_REGISTRY")
@@ -66,34 +66,27 @@ func Scanner(manifest model.Manifest, githubToken, git, branch string) error {
 	}
 	baseImageName := istioBaseRegistry + "/base:" + baseVersion
 
-	trivyScanOutput, err := util.RunWithOutput(
-		"trivy",
-		"image",
-		"--security-checks", "vuln", // Disable secret scanning which is not relevant
-		"--ignore-unfixed",
-		"--no-progress",
-		"--exit-code",
-		"2",
-		baseImageName,
-	)
-	if err == nil {
+	policy, err := loadVulnerabilityPolicy(manifest)
+	if err != nil {
+		return err
+	}
+	scanner, err := NewVulnerabilityScanner(policy.Scanner)
+	if err != nil {
+		return err
+	}
+	findings, err := scanner.ScanImage(baseImageName)
+	if err != nil {
+		return fmt.Errorf("base image scan of %s failed: %v", baseImageName, err)
+	}
+	// Any fixable vulnerability, of any severity, is worth a rebuild
+	fixable := unacceptedFindings(findings, policy, "UNKNOWN", time.Now())
+	if len(fixable) == 0 {
 		log.Infof("Base image scan of %s was successful", baseImageName)
 		if alwaysGenerateBaseImage {
 			log.Infof("Generating base image anyways due to ALWAYS_GENERATE_BASE_IMAGE=true")
 		} else {
 			return nil
 		}
-	} else {
-		//--exit-code 2 above states to return 2 if vulnerabilities are found. If we get a different error code or we can't check the error code, bail out
-		if exitError, ok := err.(*exec.ExitError); ok {
-			// Scanner failed with an exit code indicating a failure other than vulnerabilities found
-			if exitError.ExitCode() != 2 {
-				return fmt.Errorf("base image scan of %s failed with error:\n %s", baseImageName, err.Error())
-			}
-		} else {
-			// Scanner failed, but not with an ExitError
-			return fmt.Errorf("base image scan of %s failed. Unable to process exit code:\n %s", baseImageName, err.Error())
-		}
 	}
 
 	// Else build a new set of images.
@@ -103,13 +96,6 @@ func Scanner(manifest model.Manifest, githubToken, git, branch string) error {
 	tag := fmt.Sprintf("%s-%s", manifest.Version, time.Now().Format(timeFormat))
 	log.Infof("new base tag: %s", tag)
 
-	// Setup for multiarch build.
-	// See https://medium.com/@artur.klauser/building-multi-architecture-docker-images-with-buildx-27d80f7e2408 for more info
-	if err := util.VerboseCommand("docker",
-		"run", "--rm", "--privileged", "multiarch/qemu-user-static", "--reset", "-p", "yes").Run(); err != nil {
-		return fmt.Errorf("failed to run qemu-user-static container: %v", err)
-	}
-
 	targetArchitecture := os.Getenv("ARCH")
 	if targetArchitecture == "" {
 		targetArchitecture = "linux/amd64,linux/arm64"
@@ -120,6 +106,25 @@ func Scanner(manifest model.Manifest, githubToken, git, branch string) error {
 		dockerHubs = "docker.io/istio gcr.io/istio-release"
 	}
 
+	if dryRun {
+		diff, err := baseVersionDiff(makefile, tag)
+		if err != nil {
+			return err
+		}
+		log.Infof("Base image %s would be rebuilt", baseImageName)
+		log.Infof("Tag: %s, hubs: %s, architectures: %s", tag, dockerHubs, targetArchitecture)
+		log.Infof("Vulnerabilities fixed:\n%s", findingsTable(fixable))
+		log.Infof("PR diff:\n%s", diff)
+		return nil
+	}
+
+	// Setup for multiarch build.
+	// See https://medium.com/@artur.klauser/building-multi-architecture-docker-images-with-buildx-27d80f7e2408 for more info
+	if err := util.VerboseCommand("docker",
+		"run", "--rm", "--privileged", "multiarch/qemu-user-static", "--reset", "-p", "yes").Run(); err != nil {
+		return fmt.Errorf("failed to run qemu-user-static container: %v", err)
+	}
+
 	// Run the script to create the base images
 	buildImageEnv := []string{
 		"DOCKER_ARCHITECTURES=" + targetArchitecture,
@@ -142,11 +147,8 @@ func Scanner(manifest model.Manifest, githubToken, git, branch string) error {
 	}
 
 	// Now create a PR to update the TAG to use the new images
-	sedString := "s/BASE_VERSION ?=.*/BASE_VERSION ?= " + tag + "/"
-	sedCmd := util.VerboseCommand("sed", "-i", sedString, "Makefile.core.mk")
-	sedCmd.Dir = istioDir
-	if err := sedCmd.Run(); err != nil {
-		return fmt.Errorf("failed to run sed command: %v", err)
+	if err := util.WriteMakefileVariable(makefile, "BASE_VERSION", tag); err != nil {
+		return fmt.Errorf("failed to update BASE_VERSION: %v", err)
 	}
 
 	if err := util.CreatePR(
@@ -154,7 +156,7 @@ func Scanner(manifest model.Manifest, githubToken, git, branch string) error {
 		"istio",
 		"newBaseVersion"+tag,
 		"Update BASE_VERSION to "+tag,
-		fmt.Sprintf("```\n%s\n```", trivyScanOutput),
+		fmt.Sprintf("Rebuilding %s fixes the following vulnerabilities:\n\n%s", baseImageName, findingsTable(fixable)),
 		false,
 		githubToken,
 		git,
@@ -165,3 +167,23 @@ func Scanner(manifest model.Manifest, githubToken, git, branch string) error {
 
 	return nil
 }
+
+// baseVersionDiff describes the change to the BASE_VERSION assignment that the PR would make.
+func baseVersionDiff(makefile, tag string) (string, error) {
+	by, err := os.ReadFile(makefile)
+	if err != nil {
+		return "", err
+	}
+	before, err := util.FindMakefileVariable(string(by), "BASE_VERSION")
+	if err != nil {
+		return "", err
+	}
+	updated, err := util.SetMakefileVariable(string(by), "BASE_VERSION", tag)
+	if err != nil {
+		return "", err
+	}
+	oldLine := strings.Split(string(by), "\n")[before.Line]
+	newLine := strings.Split(updated, "\n")[before.Line]
+	return fmt.Sprintf("--- a/%[1]s\n+++ b/%[1]s\n@@ -%[2]d +%[2]d @@\n-%[3]s\n+%[4]s\n",
+		baseVersionMakefile, before.Line+1, oldLine, newLine), nil
+}
@@ -0,0 +1,103 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+	"istio.io/release-builder/pkg/util"
+)
+
+// PackageSigner signs the sidecar packages and the package repository metadata with a GPG key.
+type PackageSigner struct {
+	// home is a private GNUPGHOME holding only the signing key, so the user's keyring is never touched.
+	home string
+	// fingerprint identifies the signing key within home.
+	fingerprint string
+}
+
+// NewPackageSigner imports the (unencrypted) armored private key in keyFile into a keyring under the
+// working directory.
+func NewPackageSigner(manifest model.Manifest, keyFile string) (*PackageSigner, error) {
+	home := path.Join(manifest.WorkDir(), "gnupg")
+	if err := os.MkdirAll(home, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create gpg home: %v", err)
+	}
+	if err := util.VerboseCommand("gpg", "--homedir", home, "--batch", "--import", keyFile).Run(); err != nil {
+		return nil, fmt.Errorf("failed to import signing key %v: %v", keyFile, err)
+	}
+	out, err := runInDir("", "gpg", "--homedir", home, "--batch", "--with-colons", "--list-secret-keys")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %v", err)
+	}
+	fingerprint := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		// The first fpr record following the sec record is the primary key
+		if fields := strings.Split(line, ":"); len(fields) > 9 && fields[0] == "fpr" {
+			fingerprint = fields[9]
+			break
+		}
+	}
+	if fingerprint == "" {
+		return nil, fmt.Errorf("no secret key found in %v", keyFile)
+	}
+	log.Infof("Signing packages with key %v", fingerprint)
+	return &PackageSigner{home: home, fingerprint: fingerprint}, nil
+}
+
+func (s *PackageSigner) gpg(arg ...string) error {
+	args := append([]string{"--homedir", s.home, "--batch", "--yes", "--local-user", s.fingerprint}, arg...)
+	return util.VerboseCommand("gpg", args...).Run()
+}
+
+// DetachSign writes an armored detached signature of file to sig.
+func (s *PackageSigner) DetachSign(file, sig string) error {
+	if err := s.gpg("--armor", "--output", sig, "--detach-sign", file); err != nil {
+		return fmt.Errorf("failed to sign %v: %v", file, err)
+	}
+	return nil
+}
+
+// ClearSign writes an inline signed copy of src to dst, as used for the APT InRelease file.
+func (s *PackageSigner) ClearSign(src, dst string) error {
+	if err := s.gpg("--output", dst, "--clearsign", src); err != nil {
+		return fmt.Errorf("failed to sign %v: %v", src, err)
+	}
+	return nil
+}
+
+// SignRpm adds a header signature to an rpm, equivalent to `rpmsign --addsign`. This rewrites the package.
+func (s *PackageSigner) SignRpm(file string) error {
+	if err := util.VerboseCommand("rpmsign", "--addsign",
+		"--define", "_gpg_path "+s.home,
+		"--define", "_gpg_name "+s.fingerprint,
+		file).Run(); err != nil {
+		return fmt.Errorf("failed to sign %v: %v", file, err)
+	}
+	return nil
+}
+
+// ExportPublicKey writes the armored public key, so users can import it into apt or rpm.
+func (s *PackageSigner) ExportPublicKey(dst string) error {
+	if err := s.gpg("--armor", "--output", dst, "--export", s.fingerprint); err != nil {
+		return fmt.Errorf("failed to export public key: %v", err)
+	}
+	return nil
+}
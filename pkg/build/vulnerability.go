@@ -0,0 +1,240 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+	"istio.io/release-builder/pkg/util"
+)
+
+// severities orders vulnerability severities, from least to most severe.
+var severities = map[string]int{
+	"UNKNOWN":  0,
+	"LOW":      1,
+	"MEDIUM":   2,
+	"HIGH":     3,
+	"CRITICAL": 4,
+}
+
+// Finding is a single vulnerability found in an image.
+type Finding struct {
+	ID               string `json:"id"`
+	Package          string `json:"package"`
+	InstalledVersion string `json:"installedVersion"`
+	FixedVersion     string `json:"fixedVersion,omitempty"`
+	Severity         string `json:"severity"`
+	Title            string `json:"title,omitempty"`
+	// Justification is set when the finding is accepted by the allowlist.
+	Justification string `json:"justification,omitempty"`
+}
+
+// VulnerabilityReport lists the vulnerabilities in a single image.
+type VulnerabilityReport struct {
+	Image    string    `json:"image"`
+	Findings []Finding `json:"findings"`
+}
+
+// ScanImages scans every image in the docker output for vulnerabilities, writing a report per image
+// to vulnerabilities/<image>.json. The build fails on fixable vulnerabilities at or above the policy severity,
+// unless they are accepted by an unexpired allowlist entry.
+func ScanImages(manifest model.Manifest) error {
+	policy, err := loadVulnerabilityPolicy(manifest)
+	if err != nil {
+		return err
+	}
+	scanner, err := NewVulnerabilityScanner(policy.Scanner)
+	if err != nil {
+		return err
+	}
+	archives, err := imageArchives(manifest)
+	if err != nil {
+		return err
+	}
+	dir := path.Join(manifest.OutDir(), "vulnerabilities")
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	violations := []string{}
+	for _, a := range archives {
+		name := util.ImageArchiveName(a.image, a.variant, a.arch)
+		log.Infof("Scanning image %v for vulnerabilities", name)
+		findings, err := scanner.ScanArchive(a.path)
+		if err != nil {
+			return fmt.Errorf("failed to scan image %v: %v", name, err)
+		}
+		report := VulnerabilityReport{Image: name, Findings: findings}
+		for _, f := range unacceptedFindings(report.Findings, policy, vulnerabilitySeverity(policy), time.Now()) {
+			violations = append(violations, fmt.Sprintf("%v: %v (%v) in %v %v, fixed in %v",
+				name, f.ID, f.Severity, f.Package, f.InstalledVersion, f.FixedVersion))
+		}
+		by, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal vulnerability report: %v", err)
+		}
+		if err := os.WriteFile(path.Join(dir, name+".json"), by, 0o644); err != nil {
+			return fmt.Errorf("failed to write vulnerability report: %v", err)
+		}
+	}
+	if len(violations) > 0 {
+		for _, v := range violations {
+			log.Errorf("vulnerability: %v", v)
+		}
+		return fmt.Errorf("found %d fixable vulnerabilities at or above %v", len(violations), vulnerabilitySeverity(policy))
+	}
+	return nil
+}
+
+func vulnerabilitySeverity(policy model.VulnerabilityPolicy) string {
+	if policy.Severity == "" {
+		return "HIGH"
+	}
+	return strings.ToUpper(policy.Severity)
+}
+
+// loadVulnerabilityPolicy returns the manifest vulnerability policy, extended with the allowlist file in the istio repo.
+func loadVulnerabilityPolicy(manifest model.Manifest) (model.VulnerabilityPolicy, error) {
+	policy := manifest.Vulnerabilities
+	allowlistFile := policy.AllowlistFile
+	if allowlistFile == "" {
+		allowlistFile = "vulnerability-allowlist.yaml"
+	}
+	allowlistFile = path.Join(manifest.RepoDir("istio"), allowlistFile)
+	by, err := os.ReadFile(allowlistFile)
+	if err != nil && !os.IsNotExist(err) {
+		return policy, fmt.Errorf("failed to read vulnerability allowlist: %v", err)
+	}
+	if err == nil {
+		entries := []model.VulnerabilityException{}
+		if err := yaml.Unmarshal(by, &entries); err != nil {
+			return policy, fmt.Errorf("failed to parse vulnerability allowlist %v: %v", allowlistFile, err)
+		}
+		policy.Allowlist = append(slices.Clone(policy.Allowlist), entries...)
+	}
+	if err := validateVulnerabilityPolicy(policy); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// validateVulnerabilityPolicy ensures every allowlist entry is justified and expires.
+func validateVulnerabilityPolicy(policy model.VulnerabilityPolicy) error {
+	if _, f := severities[vulnerabilitySeverity(policy)]; !f {
+		return fmt.Errorf("unknown vulnerability severity %q", policy.Severity)
+	}
+	for _, e := range policy.Allowlist {
+		if e.ID == "" || e.Justification == "" {
+			return fmt.Errorf("allowlist entry %+v requires an id and justification", e)
+		}
+		if _, err := time.Parse(time.DateOnly, e.Expires); err != nil {
+			return fmt.Errorf("allowlist entry %v has invalid expiry %q: %v", e.ID, e.Expires, err)
+		}
+	}
+	return nil
+}
+
+// unacceptedFindings marks findings accepted by the allowlist, and returns the fixable findings at or above severity
+// that are not accepted.
+func unacceptedFindings(findings []Finding, policy model.VulnerabilityPolicy, severity string, now time.Time) []Finding {
+	threshold := severities[severity]
+	res := []Finding{}
+	for i, f := range findings {
+		if e := allowlisted(f, policy.Allowlist, now); e != nil {
+			findings[i].Justification = e.Justification
+			continue
+		}
+		if f.FixedVersion == "" || severities[f.Severity] < threshold {
+			continue
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+// allowlisted returns the unexpired allowlist entry accepting the finding, if any.
+func allowlisted(f Finding, allowlist []model.VulnerabilityException, now time.Time) *model.VulnerabilityException {
+	for i, e := range allowlist {
+		if e.ID != f.ID || (e.Package != "" && e.Package != f.Package) {
+			continue
+		}
+		expires, err := time.Parse(time.DateOnly, e.Expires)
+		if err != nil {
+			continue
+		}
+		// Entries are valid through the end of the expiry date
+		if now.After(expires.AddDate(0, 0, 1)) {
+			log.Warnf("allowlist entry for %v expired on %v", e.ID, e.Expires)
+			continue
+		}
+		return &allowlist[i]
+	}
+	return nil
+}
+
+// imageArchive is a docker image tarball, along with the image it holds.
+type imageArchive struct {
+	path    string
+	image   string
+	variant string
+	arch    string
+}
+
+// imageArchives returns a docker archive for each image in the docker output. Images in OCI image layouts
+// are exported to the working directory, as scanners expect archives.
+func imageArchives(manifest model.Manifest) ([]imageArchive, error) {
+	images, err := releaseImages(manifest)
+	if err != nil {
+		return nil, err
+	}
+	dockerDir := path.Join(manifest.OutDir(), "docker")
+	exportDir := path.Join(manifest.WorkDir(), "image-archives")
+	res := []imageArchive{}
+	for _, img := range images {
+		a := imageArchive{image: img.image, variant: img.variant, arch: img.arch}
+		if manifest.DockerOutput == model.DockerOutputOCI {
+			if err := os.MkdirAll(exportDir, 0o750); err != nil {
+				return nil, err
+			}
+			tag := manifest.Version
+			if img.variant != "" {
+				tag += "-" + img.variant
+			}
+			ref, err := name.NewTag(fmt.Sprintf("%s/%s:%s", manifest.Docker, img.image, tag))
+			if err != nil {
+				return nil, err
+			}
+			a.path = path.Join(exportDir, util.ImageArchiveName(img.image, img.variant, img.arch)+".tar")
+			if err := tarball.WriteToFile(a.path, ref, img.img); err != nil {
+				return nil, fmt.Errorf("failed to export %v: %v", ref, err)
+			}
+		} else {
+			a.path = path.Join(dockerDir, img.file)
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
@@ -0,0 +1,78 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+func TestParseScannerReports(t *testing.T) {
+	want := []Finding{
+		{ID: "CVE-2024-1", Package: "libc6", InstalledVersion: "2.36-9", FixedVersion: "2.36-9+deb12u4", Severity: "HIGH"},
+		{ID: "CVE-2024-2", Package: "zlib1g", InstalledVersion: "1.2.13", Severity: "LOW"},
+	}
+	trivy, err := parseTrivy([]byte(`{"Results": [{"Target": "img", "Vulnerabilities": [
+		{"VulnerabilityID": "CVE-2024-1", "PkgName": "libc6", "InstalledVersion": "2.36-9",
+		 "FixedVersion": "2.36-9+deb12u4", "Severity": "HIGH"},
+		{"VulnerabilityID": "CVE-2024-2", "PkgName": "zlib1g", "InstalledVersion": "1.2.13", "Severity": "LOW"}
+	]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(trivy, want) {
+		t.Errorf("trivy: got %+v, want %+v", trivy, want)
+	}
+	grype, err := parseGrype([]byte(`{"matches": [
+		{"vulnerability": {"id": "CVE-2024-1", "severity": "High", "fix": {"versions": ["2.36-9+deb12u4"], "state": "fixed"}},
+		 "artifact": {"name": "libc6", "version": "2.36-9"}},
+		{"vulnerability": {"id": "CVE-2024-2", "severity": "Negligible", "fix": {"versions": [], "state": "not-fixed"}},
+		 "artifact": {"name": "zlib1g", "version": "1.2.13"}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(grype, want) {
+		t.Errorf("grype: got %+v, want %+v", grype, want)
+	}
+}
+
+func TestUnacceptedFindings(t *testing.T) {
+	findings := []Finding{
+		{ID: "CVE-1", Package: "a", FixedVersion: "2", Severity: "CRITICAL"},
+		{ID: "CVE-2", Package: "b", FixedVersion: "2", Severity: "HIGH"},
+		{ID: "CVE-3", Package: "c", FixedVersion: "2", Severity: "HIGH"},
+		{ID: "CVE-4", Package: "d", Severity: "CRITICAL"},
+		{ID: "CVE-5", Package: "e", FixedVersion: "2", Severity: "MEDIUM"},
+	}
+	policy := model.VulnerabilityPolicy{Allowlist: []model.VulnerabilityException{
+		{ID: "CVE-2", Justification: "not reachable", Expires: "2025-01-31"},
+		{ID: "CVE-3", Justification: "expired", Expires: "2025-01-01"},
+	}}
+	now := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	got := []string{}
+	for _, f := range unacceptedFindings(findings, policy, "HIGH", now) {
+		got = append(got, f.ID)
+	}
+	if want := []string{"CVE-1", "CVE-3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if findings[1].Justification != "not reachable" {
+		t.Fatalf("expected allowlisted finding to be justified, got %+v", findings[1])
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// VulnerabilityScanner finds vulnerabilities in container images.
+type VulnerabilityScanner interface {
+	// ScanArchive scans an image in a `docker save` archive.
+	ScanArchive(archive string) ([]Finding, error)
+	// ScanImage scans an image in a registry.
+	ScanImage(ref string) ([]Finding, error)
+}
+
+// NewVulnerabilityScanner returns the scanner with the given name. Defaults to trivy.
+func NewVulnerabilityScanner(name string) (VulnerabilityScanner, error) {
+	switch strings.ToLower(name) {
+	case "", "trivy":
+		return trivyScanner{}, nil
+	case "grype":
+		return grypeScanner{}, nil
+	default:
+		return nil, fmt.Errorf("unknown vulnerability scanner %q", name)
+	}
+}
+
+// trivyScanner scans images with https://github.com/aquasecurity/trivy.
+type trivyScanner struct{}
+
+func (t trivyScanner) ScanArchive(archive string) ([]Finding, error) {
+	return t.scan("--input", archive)
+}
+
+func (t trivyScanner) ScanImage(ref string) ([]Finding, error) {
+	return t.scan(ref)
+}
+
+func (trivyScanner) scan(arg ...string) ([]Finding, error) {
+	args := append([]string{"image", "--scanners", "vuln", "--format", "json", "--quiet"}, arg...)
+	out, err := runInDir("", "trivy", args...)
+	if err != nil {
+		return nil, fmt.Errorf("trivy scan failed: %v", err)
+	}
+	return parseTrivy(out)
+}
+
+func parseTrivy(out []byte) ([]Finding, error) {
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string
+				PkgName          string
+				InstalledVersion string
+				FixedVersion     string
+				Severity         string
+				Title            string
+			}
+		}
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse trivy report: %v", err)
+	}
+	res := []Finding{}
+	for _, r := range report.Results {
+		for _, v := range r.Vulnerabilities {
+			res = append(res, Finding{
+				ID:               v.VulnerabilityID,
+				Package:          v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				FixedVersion:     v.FixedVersion,
+				Severity:         normalizeSeverity(v.Severity),
+				Title:            v.Title,
+			})
+		}
+	}
+	return res, nil
+}
+
+// grypeScanner scans images with https://github.com/anchore/grype.
+type grypeScanner struct{}
+
+func (g grypeScanner) ScanArchive(archive string) ([]Finding, error) {
+	return g.scan("docker-archive:" + archive)
+}
+
+func (g grypeScanner) ScanImage(ref string) ([]Finding, error) {
+	return g.scan("registry:" + ref)
+}
+
+func (grypeScanner) scan(source string) ([]Finding, error) {
+	out, err := runInDir("", "grype", source, "--output", "json", "--quiet")
+	if err != nil {
+		return nil, fmt.Errorf("grype scan failed: %v", err)
+	}
+	return parseGrype(out)
+}
+
+func parseGrype(out []byte) ([]Finding, error) {
+	var report struct {
+		Matches []struct {
+			Vulnerability struct {
+				ID          string `json:"id"`
+				Severity    string `json:"severity"`
+				Description string `json:"description"`
+				Fix         struct {
+					Versions []string `json:"versions"`
+					State    string   `json:"state"`
+				} `json:"fix"`
+			} `json:"vulnerability"`
+			Artifact struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"artifact"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse grype report: %v", err)
+	}
+	res := []Finding{}
+	for _, m := range report.Matches {
+		f := Finding{
+			ID:               m.Vulnerability.ID,
+			Package:          m.Artifact.Name,
+			InstalledVersion: m.Artifact.Version,
+			Severity:         normalizeSeverity(m.Vulnerability.Severity),
+			Title:            m.Vulnerability.Description,
+		}
+		if m.Vulnerability.Fix.State == "fixed" {
+			f.FixedVersion = strings.Join(m.Vulnerability.Fix.Versions, ", ")
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+// normalizeSeverity maps scanner specific severities onto the trivy names used by the vulnerability policy.
+func normalizeSeverity(s string) string {
+	s = strings.ToUpper(s)
+	if s == "NEGLIGIBLE" {
+		return "LOW"
+	}
+	if _, f := severities[s]; !f {
+		return "UNKNOWN"
+	}
+	return s
+}
+
+// findingsTable renders findings as a markdown table.
+func findingsTable(findings []Finding) string {
+	sb := &strings.Builder{}
+	sb.WriteString("| Vulnerability | Severity | Package | Installed | Fixed |\n")
+	sb.WriteString("|---|---|---|---|---|\n")
+	for _, f := range findings {
+		fmt.Fprintf(sb, "| %s | %s | %s | %s | %s |\n", f.ID, f.Severity, f.Package, f.InstalledVersion, f.FixedVersion)
+	}
+	return sb.String()
+}
@@ -34,6 +34,7 @@ func GetRootCmd(args []string) *cobra.Command {
 	rootCmd.AddCommand(build.GetBuildCommand())
 	rootCmd.AddCommand(validate.GetValidateCommand())
 	rootCmd.AddCommand(publish.GetPublishCommand())
+	rootCmd.AddCommand(publish.GetPromoteCommand())
 	rootCmd.AddCommand(branch.GetBranchCommand())
 
 	return rootCmd
@@ -25,6 +25,9 @@ import (
 	"istio.io/release-builder/pkg/model"
 )
 
+// defaultVariants are the docker image variants built when the manifest does not specify any.
+var defaultVariants = []string{"debug", "distroless"}
+
 func InputManifestToManifest(in model.InputManifest) (model.Manifest, error) {
 	wd := in.Directory
 	if wd == "" {
@@ -43,12 +46,18 @@ func InputManifestToManifest(in model.InputManifest) (model.Manifest, error) {
 			outputs[model.Helm] = struct{}{}
 		case "debian":
 			outputs[model.Debian] = struct{}{}
+		case "rpm":
+			outputs[model.Rpm] = struct{}{}
 		case "archive":
 			outputs[model.Archive] = struct{}{}
 		case "grafana":
 			outputs[model.Grafana] = struct{}{}
 		case "scanner":
 			outputs[model.Scanner] = struct{}{}
+		case "repository":
+			outputs[model.PackageRepository] = struct{}{}
+		case "imagescan":
+			outputs[model.ImageScan] = struct{}{}
 		default:
 			return model.Manifest{}, fmt.Errorf("unknown build output: %v", o)
 		}
@@ -66,11 +75,22 @@ func InputManifestToManifest(in model.InputManifest) (model.Manifest, error) {
 	if do == "" {
 		do = model.DockerOutputTar
 	}
+	sbomNamespace := in.SbomNamespace
+	if sbomNamespace == "" {
+		sbomNamespace = "https://storage.googleapis.com/istio-release/releases"
+	}
 	arch := in.Architectures
 	if len(arch) == 0 {
 		// Default to just amd64. In the future we may want to include arm64 by default
 		arch = []string{"linux/amd64"}
 	}
+	variants := in.Variants
+	if variants == nil {
+		variants = defaultVariants
+	} else if len(variants) == 0 {
+		// An empty DOCKER_BUILD_VARIANTS builds no images at all.
+		return model.Manifest{}, fmt.Errorf("variants must not be empty; omit it to build the default variants %v", defaultVariants)
+	}
 	return model.Manifest{
 		Dependencies:                in.Dependencies,
 		Version:                     in.Version,
@@ -80,8 +100,13 @@ func InputManifestToManifest(in model.InputManifest) (model.Manifest, error) {
 		BuildOutputs:                outputs,
 		ProxyOverride:               in.ProxyOverride,
 		GrafanaDashboards:           in.GrafanaDashboards,
+		StrictDashboards:            in.StrictDashboards,
 		SkipGenerateBillOfMaterials: in.SkipGenerateBillOfMaterials,
+		SbomNamespace:               strings.TrimSuffix(sbomNamespace, "/"),
+		Licenses:                    in.Licenses,
+		Vulnerabilities:             in.Vulnerabilities,
 		Architectures:               arch,
+		Variants:                    variants,
 	}, nil
 }
 
@@ -94,6 +119,10 @@ func ReadManifest(manifestFile string) (model.Manifest, error) {
 	if err := yaml.Unmarshal(by, &manifest); err != nil {
 		return manifest, fmt.Errorf("failed to unmarshal manifest file: %v", err)
 	}
+	// Releases built before variants were configurable always built the default variants.
+	if manifest.Variants == nil {
+		manifest.Variants = defaultVariants
+	}
 	return manifest, nil
 }
 
@@ -0,0 +1,57 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+func TestManifestVariants(t *testing.T) {
+	dir := t.TempDir()
+	m, err := InputManifestToManifest(model.InputManifest{Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Variants, defaultVariants) {
+		t.Fatalf("expected default variants, got %v", m.Variants)
+	}
+	m, err = InputManifestToManifest(model.InputManifest{Directory: dir, Variants: []string{"distroless"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Variants, []string{"distroless"}) {
+		t.Fatalf("expected only distroless, got %v", m.Variants)
+	}
+	if _, err := InputManifestToManifest(model.InputManifest{Directory: dir, Variants: []string{}}); err == nil {
+		t.Fatal("expected an empty variant list to be rejected")
+	}
+}
+
+func TestManifestOutputs(t *testing.T) {
+	m, err := InputManifestToManifest(model.InputManifest{
+		Directory:    t.TempDir(),
+		BuildOutputs: []string{"debian", "rpm", "repository"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[model.BuildOutput]struct{}{model.Debian: {}, model.Rpm: {}, model.PackageRepository: {}}
+	if !reflect.DeepEqual(m.BuildOutputs, want) {
+		t.Fatalf("expected deb, rpm and repository outputs, got %v", m.BuildOutputs)
+	}
+}
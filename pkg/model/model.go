@@ -32,6 +32,8 @@ const (
 	Archive
 	Grafana
 	Scanner
+	PackageRepository
+	ImageScan
 
 	// Deps will resolve by looking at the istio.deps file in istio/istio
 	Deps string = "deps"
@@ -123,8 +125,43 @@ const (
 	DockerOutputTar DockerOutput = "tar"
 	// DockerOutputContext loads docker images into the local docker context
 	DockerOutputContext DockerOutput = "context"
+	// DockerOutputOCI outputs one multi-arch OCI image layout per image, holding all variants and architectures
+	DockerOutputOCI DockerOutput = "oci"
 )
 
+// LicensePolicy configures which dependency licenses are acceptable in a release.
+type LicensePolicy struct {
+	// Deny lists SPDX license IDs that fail the build. Entries may be globs, such as `GPL-*`.
+	Deny []string `json:"deny,omitempty"`
+	// Overrides maps a module to its SPDX license ID, for licenses that cannot be detected automatically.
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// VulnerabilityPolicy configures which vulnerabilities in the release images fail the build.
+type VulnerabilityPolicy struct {
+	// Scanner selects the vulnerability scanner: trivy (default) or grype.
+	Scanner string `json:"scanner,omitempty"`
+	// Severity is the lowest severity of fixable vulnerabilities that fail the build. Defaults to HIGH.
+	Severity string `json:"severity,omitempty"`
+	// Allowlist accepts known vulnerabilities.
+	Allowlist []VulnerabilityException `json:"allowlist,omitempty"`
+	// AllowlistFile is a YAML list of additional allowlist entries, relative to the istio repo.
+	// Defaults to vulnerability-allowlist.yaml; a missing file is ignored.
+	AllowlistFile string `json:"allowlistFile,omitempty"`
+}
+
+// VulnerabilityException accepts a single vulnerability until it expires.
+type VulnerabilityException struct {
+	// ID is the vulnerability, such as CVE-2024-1234.
+	ID string `json:"id"`
+	// Package optionally restricts the exception to a single package.
+	Package string `json:"package,omitempty"`
+	// Justification explains why the vulnerability is accepted. Required.
+	Justification string `json:"justification"`
+	// Expires is the date (YYYY-MM-DD) after which the exception no longer applies. Required.
+	Expires string `json:"expires"`
+}
+
 // Manifest defines what is in a release
 type InputManifest struct {
 	// Dependencies declares all git repositories used to build this release
@@ -139,6 +176,10 @@ type InputManifest struct {
 	// Note: this impacts only docker and deb/rpm; istioctl is always built in additional platforms.
 	// Example: []string{"linux/amd64", "linux/arm64"}.
 	Architectures []string `json:"architectures"`
+	// Variants defines exactly the docker image variants to build, as passed to DOCKER_BUILD_VARIANTS. The debug
+	// variant is the default image, which has no variant suffix. An empty list is invalid, as no images would be built.
+	// Defaults to []string{"debug", "distroless"}.
+	Variants []string `json:"variants"`
 	// Directory defines the base working directory for the release.
 	// This is excluded from the final serialization
 	Directory string `json:"directory"`
@@ -149,9 +190,19 @@ type InputManifest struct {
 	BuildOutputs []string `json:"outputs"`
 	// GrafanaDashboards defines a mapping of dashboard name -> ID of the dashboard on grafana.com
 	GrafanaDashboards map[string]int `json:"dashboards"`
+	// StrictDashboards fails the build if any packaged dashboard is not mapped to a grafana.com ID in GrafanaDashboards,
+	// or a mapping refers to an unknown dashboard.
+	StrictDashboards bool `json:"strictDashboards"`
 	// BillOfMaterials flag determines if a Bill of Materials should be produced
 	// by the build.
 	SkipGenerateBillOfMaterials bool `json:"skipGenerateBillOfMaterials"`
+	// SbomNamespace is the base URL of the namespaces of generated SBOM documents. Each document is named
+	// `<sbomNamespace>/<version>/<file>`, so this should be where releases are published.
+	SbomNamespace string `json:"sbomNamespace"`
+	// Licenses configures the license compliance check of dependencies.
+	Licenses LicensePolicy `json:"licenses"`
+	// Vulnerabilities configures the vulnerability scan of the release images.
+	Vulnerabilities VulnerabilityPolicy `json:"vulnerabilities"`
 }
 
 // Manifest defines what is in a release
@@ -168,6 +219,10 @@ type Manifest struct {
 	// Note: this impacts only docker and deb/rpm; istioctl is always built in additional platforms.
 	// Example: []string{"linux/amd64", "linux/arm64"}.
 	Architectures []string `json:"architectures"`
+	// Variants defines exactly the docker image variants to build, as passed to DOCKER_BUILD_VARIANTS. The debug
+	// variant is the default image, which has no variant suffix. An empty list is invalid, as no images would be built.
+	// Defaults to []string{"debug", "distroless"}.
+	Variants []string `json:"variants"`
 	// Directory defines the base working directory for the release.
 	// This is excluded from the final serialization
 	Directory string `json:"-"`
@@ -179,9 +234,19 @@ type Manifest struct {
 	// GrafanaDashboards defines a mapping of dashboard name -> ID of the dashboard on grafana.com
 	// Note: this tool is not yet smart enough to create dashboards that do not already exist, it can only update dashboards.
 	GrafanaDashboards map[string]int `json:"dashboards"`
+	// StrictDashboards fails the build if any packaged dashboard is not mapped to a grafana.com ID in GrafanaDashboards,
+	// or a mapping refers to an unknown dashboard.
+	StrictDashboards bool `json:"strictDashboards"`
 	// BillOfMaterials flag determines if a Bill of Materials should be produced
 	// by the build.
 	SkipGenerateBillOfMaterials bool `json:"skipGenerateBillOfMaterials"`
+	// SbomNamespace is the base URL of the namespaces of generated SBOM documents. Each document is named
+	// `<sbomNamespace>/<version>/<file>`, so this should be where releases are published.
+	SbomNamespace string `json:"sbomNamespace"`
+	// Licenses configures the license compliance check of dependencies.
+	Licenses LicensePolicy `json:"licenses"`
+	// Vulnerabilities configures the vulnerability scan of the release images.
+	Vulnerabilities VulnerabilityPolicy `json:"vulnerabilities"`
 }
 
 // RepoDir is a helper to return the working directory for a repo
@@ -226,3 +291,28 @@ type IstioDep struct {
 	RepoName      string `json:"repoName,omitempty"`
 	LastStableSHA string `json:"lastStableSHA,omitempty"`
 }
+
+// ImageLockfile is the images.yaml written by publish, pinning every pushed image to the digests it was pushed with.
+type ImageLockfile struct {
+	// Version is the version of the release the images are from.
+	Version string      `json:"version"`
+	Images  []ImageLock `json:"images"`
+}
+
+// ImageLock is a single pushed image tag.
+type ImageLock struct {
+	// Image is the name of the image, such as pilot.
+	Image string `json:"image"`
+	// Variant is the variant of the image, such as distroless. Empty for the default variant.
+	Variant string `json:"variant,omitempty"`
+	// Tag is the tag the image was published with, such as 1.2.3.
+	Tag string `json:"tag"`
+	// Reference is the full reference pushed, such as docker.io/istio/pilot:1.2.3-distroless.
+	Reference string `json:"reference"`
+	// Digest is the digest Reference points to. For multi-architecture images, this is the digest of the index.
+	Digest string `json:"digest"`
+	// ManifestList is set if Digest is the index of a multi-architecture image, rather than a single image.
+	ManifestList bool `json:"manifestList"`
+	// Architectures maps each architecture, such as arm64, to the digest of its image.
+	Architectures map[string]string `json:"architectures"`
+}
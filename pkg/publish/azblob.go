@@ -0,0 +1,210 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+)
+
+func NewAzureBlobClient() (*azblob.Client, error) {
+	// A connection string is used for the Azurite emulator, for example:
+	// DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=...;BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1;
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		return azblob.NewClientFromConnectionString(cs, nil)
+	}
+
+	// Otherwise, this will read
+	// * AZURE_STORAGE_ACCOUNT
+	// * AZURE_STORAGE_KEY
+	// from the environment
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	key := os.Getenv("AZURE_STORAGE_KEY")
+	if account == "" || key == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_CONNECTION_STRING, or AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY, are required")
+	}
+	cred, err := azblob.NewSharedKeyCredential(account, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load azure credentials: %v", err)
+	}
+	endpoint := flags.azBlobEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	}
+	return azblob.NewClientWithSharedKeyCredential(endpoint, cred, nil)
+}
+
+// azblobStore is an ObjectStore backed by an Azure Blob Storage container.
+type azblobStore struct {
+	client    *azblob.Client
+	container *container.Client
+	name      string
+}
+
+var _ ObjectStore = &azblobStore{}
+
+// NewAzureBlobStore returns a store for the given Azure Blob Storage container.
+func NewAzureBlobStore(containerName string) (ObjectStore, error) {
+	client, err := NewAzureBlobClient()
+	if err != nil {
+		return nil, err
+	}
+	return &azblobStore{
+		client:    client,
+		container: client.ServiceClient().NewContainerClient(containerName),
+		name:      containerName,
+	}, nil
+}
+
+// ArchiveAzureBlob publishes the final release archive to the given Azure Blob Storage container
+func ArchiveAzureBlob(manifest model.Manifest, bucket string, aliases []string) error {
+	containerName, objectPrefix := splitBucket(bucket)
+	store, err := NewAzureBlobStore(containerName)
+	if err != nil {
+		return err
+	}
+	return PublishArchive(manifest, store, objectPrefix, aliases)
+}
+
+func (a *azblobStore) URL() string {
+	return "az://" + a.name
+}
+
+// azBlockSize is the size of each block of a large upload.
+const azBlockSize = 16 << 20
+
+// Put writes a file to a blob, unless the blob already has the same contents. The MD5 is stored as the Content-MD5
+// of the blob, which Azure only computes itself for blobs uploaded in a single request.
+func (a *azblobStore) Put(ctx context.Context, key string, file string) (PutResult, error) {
+	sum, err := fileMD5(file)
+	if err != nil {
+		return PutResult{}, err
+	}
+	props, err := a.container.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return PutResult{}, fmt.Errorf("failed to get properties of %v: %v", key, err)
+	}
+	if err == nil && bytes.Equal(props.ContentMD5, sum) {
+		skipUpToDate("write %s/%s", a.URL(), key)
+		return PutResult{UpToDate: true}, nil
+	}
+	if planObject(a, key, PlannedObject{Source: file}) {
+		return PutResult{}, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to open %v: %v", file, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return PutResult{}, err
+	}
+	if _, err := a.client.UploadFile(ctx, a.name, key, f, &azblob.UploadFileOptions{
+		BlockSize:   azBlockSize,
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentMD5: sum},
+	}); err != nil {
+		return PutResult{}, fmt.Errorf("failed to upload %v: %v", key, err)
+	}
+	log.Infof("Wrote %v to %s/%s", file, a.URL(), key)
+	return PutResult{Bytes: info.Size()}, nil
+}
+
+func (a *azblobStore) Get(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := a.client.DownloadStream(ctx, a.name, key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, "", ErrObjectNotExist
+	} else if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read blob body: %v", err)
+	}
+	return content, string(*resp.ETag), nil
+}
+
+// PutIfGeneration writes a blob using the ETag as its generation, with If-Match, or If-None-Match for new blobs.
+func (a *azblobStore) PutIfGeneration(ctx context.Context, key string, content []byte, opts ObjectOptions, generation string) error {
+	if planObject(a, key, PlannedObject{Content: string(content)}) {
+		return nil
+	}
+	conds := &blob.ModifiedAccessConditions{}
+	if generation == "" {
+		conds.IfNoneMatch = to.Ptr(azcore.ETagAny)
+	} else {
+		conds.IfMatch = to.Ptr(azcore.ETag(generation))
+	}
+	headers := &blob.HTTPHeaders{}
+	if opts.ContentType != "" {
+		headers.BlobContentType = to.Ptr(opts.ContentType)
+	}
+	if opts.CacheControl != "" {
+		headers.BlobCacheControl = to.Ptr(opts.CacheControl)
+	}
+	_, err := a.container.NewBlockBlobClient(key).Upload(ctx, streaming.NopCloser(bytes.NewReader(content)), &blockblob.UploadOptions{
+		HTTPHeaders:      headers,
+		AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: conds},
+	})
+	if err != nil {
+		// The ETag no longer matches, or the blob was created, since we read it.
+		if bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists) {
+			return ErrIndexOutOfDate
+		}
+		return fmt.Errorf("failed to write blob %s: %v", key, err)
+	}
+	return nil
+}
+
+func (a *azblobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	pager := a.client.NewListBlobsFlatPager(a.name, &azblob.ListBlobsFlatOptions{Prefix: to.Ptr(prefix)})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %v: %v", prefix, err)
+		}
+		for _, b := range page.Segment.BlobItems {
+			keys = append(keys, *b.Name)
+		}
+	}
+	return keys, nil
+}
+
+func (a *azblobStore) Delete(ctx context.Context, key string) error {
+	if planObject(a, key, PlannedObject{Delete: true}) {
+		return nil
+	}
+	if _, err := a.client.DeleteBlob(ctx, a.name, key, nil); err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("failed to delete %v: %v", key, err)
+	}
+	return nil
+}
@@ -15,9 +15,11 @@
 package publish
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -37,6 +39,17 @@ var (
 		s3bucket       string
 		helmbucket     string
 		s3helmbucket   string
+		helmurl        string
+		s3helmurl      string
+		filebucket     string
+		filealiases    []string
+		filehelm       string
+		filehelmurl    string
+		azblobbucket   string
+		azblobaliases  []string
+		azhelmbucket   string
+		azhelmurl      string
+		azBlobEndpoint string
 		helmhub        string
 		gcsaliases     []string
 		s3aliases      []string
@@ -45,7 +58,18 @@ var (
 		githubtoken    string
 		grafanatoken   string
 		cosignkey      string
-	}{}
+		cosignPubKey   string
+		rekorURL       string
+		rekorPubKey    string
+		plan           bool
+		planOutput     string
+
+		uploadConcurrency int
+		uploadRetries     int
+	}{
+		uploadConcurrency: 4,
+		uploadRetries:     3,
+	}
 	publishCmd = &cobra.Command{
 		Use:          "publish",
 		Short:        "Publish a release of Istio",
@@ -65,58 +89,180 @@ var (
 			manifest.Directory = path.Clean(flags.release)
 			util.YamlLog("Manifest", manifest)
 
+			if flags.plan || flags.planOutput != "" {
+				plan, err := NewPlan(manifest)
+				if err != nil {
+					return fmt.Errorf("failed to plan publish: %v", err)
+				}
+				plan.Print(os.Stdout)
+				if flags.planOutput != "" {
+					return plan.WriteJSON(flags.planOutput)
+				}
+				return nil
+			}
+
 			return Publish(manifest)
 		},
 	}
+
+	promoteFlags = struct {
+		from    string
+		fromhub string
+	}{}
+	promoteCmd = &cobra.Command{
+		Use:          "promote",
+		Short:        "Promote a published prerelease of Istio to its final locations, without rebuilding it",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if err := validatePromoteFlags(); err != nil {
+				return fmt.Errorf("invalid flags: %v", err)
+			}
+
+			log.Infof("Promoting Istio release from: %v", promoteFlags.from)
+			return Promote(promoteFlags.from, promoteFlags.fromhub)
+		},
+	}
 )
 
 func init() {
 	publishCmd.PersistentFlags().StringVar(&flags.release, "release", flags.release,
 		"The directory with the Istio release binary.")
-	publishCmd.PersistentFlags().StringVar(&flags.dockerhub, "dockerhub", flags.dockerhub,
+	publishCmd.PersistentFlags().StringVar(&flags.github, "github", flags.github,
+		"The Github org to trigger a release, and tag, for. Example: istio.")
+	publishCmd.PersistentFlags().StringVar(&flags.githubtoken, "githubtoken", flags.githubtoken,
+		"The file containing a github token.")
+	publishCmd.PersistentFlags().StringVar(&flags.grafanatoken, "grafanatoken", flags.grafanatoken,
+		"The file containing a grafana.com API token.")
+	publishCmd.PersistentFlags().BoolVar(&flags.plan, "plan", flags.plan,
+		"Print the actions the publish would take, such as every object, image, tag and release, without publishing anything. "+
+			"Destinations are read to skip what is already published, so the same credentials as publishing are needed.")
+	publishCmd.PersistentFlags().StringVar(&flags.planOutput, "plan-output", flags.planOutput,
+		"Write the publish plan as JSON to this file. Implies --plan.")
+	addDestinationFlags(publishCmd)
+
+	promoteCmd.PersistentFlags().StringVar(&promoteFlags.from, "from", promoteFlags.from,
+		"The bucket folder the prerelease was published to. Example: s3://istio-prerelease/prerelease/1.2.3.")
+	promoteCmd.PersistentFlags().StringVar(&promoteFlags.fromhub, "fromhub", promoteFlags.fromhub,
+		"The docker hub the prerelease images were pushed to. Example: ghcr.io/istio/prerelease-testing.")
+	addDestinationFlags(promoteCmd)
+}
+
+// addDestinationFlags adds the flags for where a release is published to, which are shared by publish and promote.
+func addDestinationFlags(c *cobra.Command) {
+	c.PersistentFlags().StringVar(&flags.dockerhub, "dockerhub", flags.dockerhub,
 		"The docker hub to push images to. Example: docker.io/istio.")
-	publishCmd.PersistentFlags().StringSliceVar(&flags.dockertags, "dockertags", flags.dockertags,
+	c.PersistentFlags().StringSliceVar(&flags.dockertags, "dockertags", flags.dockertags,
 		"The tags to apply to docker images. Example: latest")
-	publishCmd.PersistentFlags().StringVar(&flags.gcsbucket, "gcsbucket", flags.gcsbucket,
+	c.PersistentFlags().StringVar(&flags.gcsbucket, "gcsbucket", flags.gcsbucket,
 		"The gcs bucket to publish binaries to. Example: istio-release/releases.")
-	publishCmd.PersistentFlags().StringVar(&flags.s3bucket, "s3bucket", flags.s3bucket,
+	c.PersistentFlags().StringVar(&flags.s3bucket, "s3bucket", flags.s3bucket,
 		"The S3 bucket to publish binaries to. Example: istio-release/releases.")
-	publishCmd.PersistentFlags().StringVar(&flags.helmbucket, "helmbucket", flags.helmbucket,
+	c.PersistentFlags().StringVar(&flags.helmbucket, "helmbucket", flags.helmbucket,
 		"The gcs bucket to publish helm to. Example: istio-release/charts.")
-	publishCmd.PersistentFlags().StringVar(&flags.s3helmbucket, "s3helmbucket", flags.s3helmbucket,
+	c.PersistentFlags().StringVar(&flags.s3helmbucket, "s3helmbucket", flags.s3helmbucket,
 		"The S3 bucket to publish helm to. Example: istio-release/charts.")
-	publishCmd.PersistentFlags().StringVar(&flags.helmhub, "helmhub", flags.helmhub,
+	c.PersistentFlags().StringVar(&flags.helmurl, "helmurl", flags.helmurl,
+		"The public URL charts in --helmbucket are served from. Defaults to the bucket's storage.googleapis.com URL.")
+	c.PersistentFlags().StringVar(&flags.s3helmurl, "s3helmurl", flags.s3helmurl,
+		"The public URL charts in --s3helmbucket are served from. Required with --s3helmbucket.")
+	c.PersistentFlags().StringVar(&flags.filebucket, "filebucket", flags.filebucket,
+		"A local directory to publish binaries to, laid out like --gcsbucket. Example: /srv/istio/releases.")
+	c.PersistentFlags().StringSliceVar(&flags.filealiases, "filealiases", flags.filealiases,
+		"Alias to publish to --filebucket. Example: latest")
+	c.PersistentFlags().StringVar(&flags.filehelm, "filehelm", flags.filehelm,
+		"A local directory to publish helm to, laid out like --helmbucket. Example: /srv/istio/charts.")
+	c.PersistentFlags().StringVar(&flags.filehelmurl, "filehelmurl", flags.filehelmurl,
+		"The public URL charts in --filehelm are served from. Defaults to the file:// URL of the directory.")
+	c.PersistentFlags().StringVar(&flags.azblobbucket, "azblobbucket", flags.azblobbucket,
+		"The Azure Blob Storage container to publish binaries to. Example: istio-release/releases.")
+	c.PersistentFlags().StringSliceVar(&flags.azblobaliases, "azblobaliases", flags.azblobaliases,
+		"Alias to publish to --azblobbucket. Example: latest")
+	c.PersistentFlags().StringVar(&flags.azhelmbucket, "azhelmbucket", flags.azhelmbucket,
+		"The Azure Blob Storage container to publish helm to. Example: istio-release/charts.")
+	c.PersistentFlags().StringVar(&flags.azhelmurl, "azhelmurl", flags.azhelmurl,
+		"The public URL charts in --azhelmbucket are served from. Required with --azhelmbucket.")
+	c.PersistentFlags().StringVar(&flags.azBlobEndpoint, "azblob-endpoint", flags.azBlobEndpoint,
+		"Azure Blob Storage endpoint, when not using a connection string. Defaults to https://<account>.blob.core.windows.net/")
+	c.PersistentFlags().StringVar(&flags.helmhub, "helmhub", flags.helmhub,
 		"The oci registry to publish helm to. Example: gcr.io/istio-release/charts.")
-	publishCmd.PersistentFlags().StringSliceVar(&flags.gcsaliases, "gcsaliases", flags.gcsaliases,
+	c.PersistentFlags().StringSliceVar(&flags.gcsaliases, "gcsaliases", flags.gcsaliases,
 		"Alias to publish to gcs. Example: latest")
-	publishCmd.PersistentFlags().StringSliceVar(&flags.s3aliases, "s3aliases", flags.s3aliases,
+	c.PersistentFlags().StringSliceVar(&flags.s3aliases, "s3aliases", flags.s3aliases,
 		"Alias to publish to s3. Example: latest")
-	publishCmd.PersistentFlags().StringVar(&flags.github, "github", flags.github,
-		"The Github org to trigger a release, and tag, for. Example: istio.")
-	publishCmd.PersistentFlags().StringVar(&flags.githubtoken, "githubtoken", flags.githubtoken,
-		"The file containing a github token.")
-	publishCmd.PersistentFlags().StringVar(&flags.grafanatoken, "grafanatoken", flags.grafanatoken,
-		"The file containing a grafana.com API token.")
-	publishCmd.PersistentFlags().StringVar(&flags.cosignkey, "cosignkey", flags.cosignkey,
-		"A key for signing images, as passed to cosign using 'cosign sign --key <x>'")
-	publishCmd.PersistentFlags().StringVar(&flags.s3BaseEndpoint, "s3-base-endpoint", flags.s3BaseEndpoint,
+	c.PersistentFlags().StringVar(&flags.cosignkey, "cosignkey", flags.cosignkey,
+		"A key for signing images and attesting their SBOMs: a cosign private key file, decrypted with $COSIGN_PASSWORD, "+
+			"a k8s://<namespace>/<secret> reference, or a gcpkms://, awskms://, azurekms:// or hashivault:// key")
+	c.PersistentFlags().StringVar(&flags.cosignPubKey, "cosign-public-key", flags.cosignPubKey,
+		"The published public key of --cosignkey. Signatures are verified against it, and signing fails if the key differs.")
+	c.PersistentFlags().StringVar(&flags.rekorURL, "rekor-url", flags.rekorURL,
+		"A Rekor transparency log to record signatures and attestations in, such as "+publicRekorURL+
+			". Nothing is recorded if unset.")
+	c.PersistentFlags().StringVar(&flags.rekorPubKey, "rekor-public-key", flags.rekorPubKey,
+		"The public key of --rekor-url. Defaults to the key in the sigstore trusted root, fetched with TUF.")
+	c.PersistentFlags().StringVar(&flags.s3BaseEndpoint, "s3-base-endpoint", flags.s3BaseEndpoint,
 		"S3 base endpoint when publishing to S3 compatible storage. Example: https://<account_id>.r2.cloudflarestorage.com")
+	c.PersistentFlags().IntVar(&flags.uploadConcurrency, "upload-concurrency", flags.uploadConcurrency,
+		"The number of files to upload to GCS or S3 at a time.")
+	c.PersistentFlags().IntVar(&flags.uploadRetries, "upload-retries", flags.uploadRetries,
+		"The number of times to retry a failed upload to GCS or S3, with exponential backoff.")
 }
 
 func GetPublishCommand() *cobra.Command {
 	return publishCmd
 }
 
+func GetPromoteCommand() *cobra.Command {
+	return promoteCmd
+}
+
 func validateFlags() error {
 	if flags.release == "" {
 		return fmt.Errorf("--release required")
 	}
+	return validateDestinationFlags()
+}
+
+func validatePromoteFlags() error {
+	if promoteFlags.from == "" {
+		return fmt.Errorf("--from required")
+	}
+	if flags.dockerhub != "" && promoteFlags.fromhub == "" {
+		return fmt.Errorf("--fromhub required with --dockerhub")
+	}
+	return validateDestinationFlags()
+}
+
+func validateDestinationFlags() error {
+	if flags.s3helmbucket != "" && flags.s3helmurl == "" {
+		return fmt.Errorf("--s3helmurl required with --s3helmbucket")
+	}
+	if flags.azhelmbucket != "" && flags.azhelmurl == "" {
+		return fmt.Errorf("--azhelmurl required with --azhelmbucket")
+	}
+	if flags.cosignPubKey != "" && flags.cosignkey == "" {
+		return fmt.Errorf("--cosignkey required with --cosign-public-key")
+	}
+	if flags.rekorPubKey != "" && flags.rekorURL == "" {
+		return fmt.Errorf("--rekor-url required with --rekor-public-key")
+	}
 	return nil
 }
 
+// signingFlags returns the options for signing images set by flags.
+func signingFlags() signingOptions {
+	return signingOptions{
+		key:            flags.cosignkey,
+		publicKey:      flags.cosignPubKey,
+		rekorURL:       flags.rekorURL,
+		rekorPublicKey: flags.rekorPubKey,
+	}
+}
+
 func Publish(manifest model.Manifest) error {
+	defer reportSkipped()
 	if flags.dockerhub != "" {
-		if err := Docker(manifest, flags.dockerhub, flags.dockertags, flags.cosignkey); err != nil {
+		if err := Docker(manifest, flags.dockerhub, flags.dockertags, signingFlags()); err != nil {
 			return fmt.Errorf("failed to publish to docker: %v", err)
 		}
 	}
@@ -130,8 +276,22 @@ func Publish(manifest model.Manifest) error {
 			return fmt.Errorf("failed to publish to s3 : %v", err)
 		}
 	}
-	if flags.helmbucket != "" || flags.helmhub != "" || flags.s3helmbucket != "" {
-		if err := Helm(manifest, flags.helmbucket, flags.helmhub, flags.s3helmbucket); err != nil {
+	if flags.azblobbucket != "" {
+		if err := ArchiveAzureBlob(manifest, flags.azblobbucket, flags.azblobaliases); err != nil {
+			return fmt.Errorf("failed to publish to azure blob storage: %v", err)
+		}
+	}
+	if flags.filebucket != "" {
+		if err := FileArchive(manifest, flags.filebucket, flags.filealiases); err != nil {
+			return fmt.Errorf("failed to publish to %v: %v", flags.filebucket, err)
+		}
+	}
+	repos, err := helmRepositories()
+	if err != nil {
+		return err
+	}
+	if len(repos) > 0 || flags.helmhub != "" {
+		if err := Helm(manifest, repos, flags.helmhub); err != nil {
 			return fmt.Errorf("failed to publish to helm charts: %v", err)
 		}
 	}
@@ -162,6 +322,99 @@ func Publish(manifest model.Manifest) error {
 	return nil
 }
 
+// archiveTarget is a bucket the release archive is published to.
+type archiveTarget struct {
+	store   ObjectStore
+	prefix  string
+	aliases []string
+}
+
+// archiveTargets returns the buckets to publish the release archive to.
+func archiveTargets(ctx context.Context) ([]archiveTarget, error) {
+	targets := []archiveTarget{}
+	for _, t := range []struct {
+		url     string
+		bucket  string
+		aliases []string
+	}{
+		{"gs://", flags.gcsbucket, flags.gcsaliases},
+		{"s3://", flags.s3bucket, flags.s3aliases},
+		{"az://", flags.azblobbucket, flags.azblobaliases},
+		{"file://", flags.filebucket, flags.filealiases},
+	} {
+		if t.bucket == "" {
+			continue
+		}
+		store, prefix, err := NewObjectStore(ctx, t.url+t.bucket)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, archiveTarget{store: store, prefix: prefix, aliases: t.aliases})
+	}
+	return targets, nil
+}
+
+// helmBucketURL returns the URL charts in --helmbucket are served from.
+func helmBucketURL() string {
+	if flags.helmurl != "" {
+		return flags.helmurl
+	}
+	return gcsHelmURL(splitBucket(flags.helmbucket))
+}
+
+// helmRepositories returns the helm repositories to publish to.
+func helmRepositories() ([]HelmRepository, error) {
+	repos := []HelmRepository{}
+	if flags.helmbucket != "" {
+		bucketName, objectPrefix := splitBucket(flags.helmbucket)
+		store, err := NewGCSStore(context.Background(), bucketName)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, HelmRepository{Store: store, Prefix: objectPrefix, URL: helmBucketURL()})
+	}
+	if flags.s3helmbucket != "" {
+		bucketName, objectPrefix := splitBucket(flags.s3helmbucket)
+		store, err := NewS3Store(bucketName)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, HelmRepository{Store: store, Prefix: objectPrefix, URL: flags.s3helmurl})
+	}
+	if flags.azhelmbucket != "" {
+		containerName, objectPrefix := splitBucket(flags.azhelmbucket)
+		store, err := NewAzureBlobStore(containerName)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, HelmRepository{Store: store, Prefix: objectPrefix, URL: flags.azhelmurl})
+	}
+	if flags.filehelm != "" {
+		store, err := NewFileStore(flags.filehelm)
+		if err != nil {
+			return nil, err
+		}
+		url, err := fileHelmURL()
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, HelmRepository{Store: store, URL: url})
+	}
+	return repos, nil
+}
+
+// fileHelmURL returns the URL charts in --filehelm are served from.
+func fileHelmURL() (string, error) {
+	if flags.filehelmurl != "" {
+		return flags.filehelmurl, nil
+	}
+	dir, err := filepath.Abs(flags.filehelm)
+	if err != nil {
+		return "", err
+	}
+	return "file://" + dir, nil
+}
+
 func getGrafanaToken(file string) (string, error) {
 	if file != "" {
 		b, err := os.ReadFile(file)
@@ -15,19 +15,16 @@
 package publish
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/daemon"
-	"github.com/google/go-containerregistry/pkg/v1/empty"
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 
 	"istio.io/istio/pkg/log"
@@ -78,219 +75,362 @@ func toSuffix(s string) string {
 }
 
 // Docker publishes all images to the given hub
-func Docker(manifest model.Manifest, hub string, tags []string, cosignkey string) error {
+func Docker(manifest model.Manifest, hub string, tags []string, signing signingOptions) error {
 	if len(tags) == 0 {
 		tags = []string{manifest.Version}
 	}
-	dockerArchives, err := os.ReadDir(path.Join(manifest.Directory, "docker"))
+	signer, err := newImageSigner(context.Background(), signing)
 	if err != nil {
-		return fmt.Errorf("failed to read docker output of release: %v", err)
+		return err
 	}
+	sboms := newSbomAttacher(manifest, signer)
 
-	// Only attempt to sign images if a valid cosign key is provided and we are
-	// able to run 'cosign public-key <key>'.
-	cosignEnabled := false
-	if cosignkey != "" {
-		if err := util.VerboseCommand("cosign", "public-key", "--key", cosignkey).Run(); err != nil {
-			log.Errorf("Argument '--cosignkey' nonempty but unable to access key %v, disabling signing.", err)
-		} else {
-			cosignEnabled = true
+	lock := newImageLock(manifest.Version)
+
+	if manifest.DockerOutput == model.DockerOutputOCI {
+		if err := dockerFromLayouts(manifest, hub, tags, signer, sboms, lock); err != nil {
+			return err
 		}
+	} else if err := dockerFromArchives(manifest, hub, tags, signer, sboms, lock); err != nil {
+		return err
 	}
+	// Nothing is signed when planning, so there is nothing to verify.
+	if signer != nil && !planning() {
+		if err := signer.verify(); err != nil {
+			return err
+		}
+	}
+	return lock.write(path.Join(manifest.Directory, imageLockfile))
+}
 
+// dockerFromArchives publishes images from the docker archives written with the "tar" docker output.
+func dockerFromArchives(manifest model.Manifest, hub string, tags []string, signer *imageSigner,
+	sboms *sbomAttacher, lock *imageLock,
+) error {
 	// As inputs, we have a variety of tar.gz files emitted from `docker save`.
 	// Our goal is to take these, and potentially mangle the hub/tags, and push to the real registry.
 	// This becomes more complex because for multi-arch images, we want to push a single manifest but we have multiple tar files (one per arch).
+	// Images are read straight from the archives and pushed as is, so no docker daemon is needed, and the pushed
+	// images are exactly the ones that were built and scanned.
 
-	// first, we will load all our images into the local docker daemon, and setup an index of Image -> architectures.
-	// Each entry will result in one upstream tag created.
-	images := map[Image][]string{}
-	for _, f := range dockerArchives {
-		if !strings.HasSuffix(f.Name(), "tar.gz") {
-			return fmt.Errorf("invalid image found in docker folder: %v", f.Name())
+	// first, we will setup an index of Image -> architectures. Each entry will result in one upstream tag created.
+	images, err := archiveImages(manifest, hub, tags)
+	if err != nil {
+		return err
+	}
+	// Each archive is pushed once per tag, so only read it once.
+	archives := map[string]v1.Image{}
+	archiveImage := func(img Image, arch string) (v1.Image, error) {
+		archive := path.Join(manifest.Directory, "docker", util.ImageArchiveName(img.Image, img.Variant, arch)+".tar.gz")
+		if image, f := archives[archive]; f {
+			return image, nil
 		}
-		if err := util.VerboseCommand("docker", "load", "-i", path.Join(manifest.Directory, "docker", f.Name())).Run(); err != nil {
-			return fmt.Errorf("failed to load docker image %v: %v", f.Name(), err)
+		image, err := util.ImageFromArchive(archive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read docker image %v: %v", archive, err)
 		}
-		imageName, variant, arch := getImageNameVariant(f.Name())
-		variants := []string{variant}
-		for _, tag := range tags {
-			for _, variant := range variants {
-				img := Image{
-					OriginalTag: fmt.Sprintf("%s/%s:%s", manifest.Docker, imageName, manifest.Version),
-					NewTag:      fmt.Sprintf("%s/%s:%s", hub, imageName, tag),
-					Variant:     variant,
-					Image:       imageName,
-				}
-				images[img] = append(images[img], arch)
-			}
+		// The manifest list is built from the platform of each image, so make sure it matches the file name.
+		cfg, err := image.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config of docker image %v: %v", archive, err)
+		}
+		if want := cmp.Or(arch, "amd64"); cfg.Architecture != want {
+			return nil, fmt.Errorf("docker image %v is for architecture %v, expected %v", archive, cfg.Architecture, want)
 		}
+		archives[archive] = image
+		return image, nil
 	}
 
 	// Now that we have the desired outputs, start pushing
 	for img, archs := range images {
+		archImages := map[string]v1.Image{}
+		for _, arch := range archs {
+			image, err := archiveImage(img, arch)
+			if err != nil {
+				return err
+			}
+			archImages[arch] = image
+		}
 		// Split case for simple images (single arch) vs multi-arch manifests.
 		if len(archs) == 1 {
 			arch := archs[0]
-			// Single architecture. We just want to push directly
 			// Single arch, push directly
-			if err := util.VerboseCommand("docker", "tag", img.OriginalReference(arch), img.NewReference(arch)).Run(); err != nil {
-				return fmt.Errorf("failed to tag docker image %v->%v: %v", img.OriginalReference(arch), img.NewReference(arch), err)
-			}
-
-			if err := util.VerboseCommand("docker", "push", img.NewReference(arch)).Run(); err != nil {
-				return fmt.Errorf("failed to push docker image %v: %v", img.NewReference(arch), err)
+			digestRef, err := publishImage(img.NewReference(arch), archImages[arch])
+			if err != nil {
+				return err
 			}
-
-			// Sign images *after* push -- cosign only works against real
-			// repositories (not valid against tarballs)
-			if cosignEnabled {
-				imgRef, err := name.ParseReference(img.NewReference(arch))
-				if err != nil {
-					return fmt.Errorf("failed to parse image reference %v: %v", img.NewReference(arch), err)
-				}
-				newImg, err := remote.Image(imgRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
-				if err != nil {
-					return fmt.Errorf("failed to load %v: %v", imgRef, err)
-				}
-				digest, err := newImg.Digest()
-				if err != nil {
-					return fmt.Errorf("failed to get digest for %v: %v", imgRef, err)
-				}
-				// We need to return the digest of the manifest, not the image. This is because the manifest is what is signed.
-				// This should return something like `gcr.io/istio-testing/pilot@sha256:1234`
-				if err := util.VerboseCommand("cosign", "sign", "--key", cosignkey, imgRef.Context().String()+"@"+digest.String(), "-y", "--recursive").Run(); err != nil {
-					return fmt.Errorf("failed to sign image %v with key %v: %v", img.NewReference(arch), cosignkey, err)
+			// Sign images *after* push -- signatures are stored in the
+			// repository next to the image (not valid against tarballs)
+			if signer != nil {
+				if err := signer.sign(digestRef, nil); err != nil {
+					return err
 				}
 			}
+			if err := sboms.attach(img, arch, digestRef); err != nil {
+				return err
+			}
+			if err := lock.add(img, img.NewReference(arch), digestRef, false, map[string]string{arch: digestRef}); err != nil {
+				return err
+			}
 		} else {
-			digest, err := publishManifest(img, archs)
+			digest, archDigests, err := publishManifest(img, archs, archImages)
 			if err != nil {
 				return err
 			}
-			if cosignEnabled {
-				if err := util.VerboseCommand("cosign", "sign", "--key", cosignkey, digest, "-y", "--recursive").Run(); err != nil {
-					return fmt.Errorf("failed to sign image %v with key %v: %v", digest, cosignkey, err)
+			if signer != nil {
+				if err := signer.sign(digest, archDigests); err != nil {
+					return err
+				}
+			}
+			for arch, archDigest := range archDigests {
+				if err := sboms.attach(img, arch, archDigest); err != nil {
+					return err
 				}
 			}
+			if err := lock.add(img, img.NewReference(""), digest, true, archDigests); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// publishImage pushes a single image, unless a previous publish already pushed it.
+// This returns the digest reference of the image, such as `gcr.io/istio-testing/pilot@sha256:1234`.
+func publishImage(target string, image v1.Image) (string, error) {
+	ref, err := name.ParseReference(target)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %v: %v", target, err)
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return "", fmt.Errorf("failed to get digest for %v: %v", ref, err)
+	}
+	if pushed, err := remoteHasDigest(ref, digest); err != nil {
+		return "", err
+	} else if pushed {
+		skipUpToDate("push %v", ref)
+	} else {
+		if err := pushImage(ref, image, "image"); err != nil {
+			return "", fmt.Errorf("failed to push docker image %v: %v", ref, err)
+		}
+		log.Infof("pushed %v@%v", ref, digest)
+	}
+	return ref.Context().String() + "@" + digest.String(), nil
+}
+
+// archiveImages indexes the docker archives in the release by the images they will be pushed as, along with
+// the architectures of each image.
+func archiveImages(manifest model.Manifest, hub string, tags []string) (map[Image][]string, error) {
+	dockerArchives, err := os.ReadDir(path.Join(manifest.Directory, "docker"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker output of release: %v", err)
+	}
+	archives := []string{}
+	for _, f := range dockerArchives {
+		archives = append(archives, f.Name())
+	}
+	return indexArchives(manifest, archives, hub, tags)
+}
+
+// indexArchives indexes docker archive file names by the images they will be pushed as, along with the
+// architectures of each image.
+func indexArchives(manifest model.Manifest, archives []string, hub string, tags []string) (map[Image][]string, error) {
+	images := map[Image][]string{}
+	for _, archive := range archives {
+		if !strings.HasSuffix(archive, "tar.gz") {
+			return nil, fmt.Errorf("invalid image found in docker folder: %v", archive)
+		}
+		imageName, variant, arch, err := util.ImageNameVariant(manifest, archive)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			img := Image{
+				OriginalTag: fmt.Sprintf("%s/%s:%s", manifest.Docker, imageName, manifest.Version),
+				NewTag:      fmt.Sprintf("%s/%s:%s", hub, imageName, tag),
+				Variant:     variant,
+				Image:       imageName,
+			}
+			images[img] = append(images[img], arch)
+		}
+	}
+	return images, nil
+}
+
 // publishManifest packages a single manifest for a multi-architecture image.
-func publishManifest(img Image, architectures []string) (string, error) {
+// This returns the digest reference of the manifest, along with the digest reference of the image for each architecture.
+func publishManifest(img Image, architectures []string, images map[string]v1.Image) (string, map[string]string, error) {
 	log.Infof("creating manifest %v for architectures %v", img, architectures)
-	// Typically we could just use `docker manifest create manifest images...`. However, we need to actually
-	// push source images first. We want to push these without a tag, so users never use them. Docker cannot
-	// push directly by tag, so here we are...
+	// We need to push source images first. We want to push these without a tag, so users never use them.
 	craneImages := []v1.Image{}
+	digestRefs := []name.Digest{}
+	archDigests := map[string]string{}
 	for _, arch := range architectures {
-		origImage := img.OriginalReference(arch)
-		origTagRef, err := name.ParseReference(origImage)
-		if err != nil {
-			return "", fmt.Errorf("failed to parse %v: %v", origImage, err)
-		}
 		newImage := img.NewReference(arch)
 		newTagRef, err := name.ParseReference(newImage)
 		if err != nil {
-			return "", fmt.Errorf("failed to parse %v: %v", newImage, err)
-		}
-		log.Infof("starting push of %v for manifest (without tag)", origTagRef)
-		// We will load from OriginalReference, push to NewReference
-		img, err := daemon.Image(origTagRef)
-		if err != nil {
-			return "", fmt.Errorf("failed to load %v: %v", origImage, err)
+			return "", nil, fmt.Errorf("failed to parse %v: %v", newImage, err)
 		}
-		digest, err := img.Digest()
+		archImage := images[arch]
+		digest, err := archImage.Digest()
 		if err != nil {
-			return "", fmt.Errorf("failed to get digest for %v: %v", origImage, err)
+			return "", nil, fmt.Errorf("failed to get digest for %v: %v", newImage, err)
 		}
 
 		digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", newTagRef.Context(), digest.String()))
 		if err != nil {
-			return "", fmt.Errorf("failed to build digest reference for %v: %v", newImage, err)
+			return "", nil, fmt.Errorf("failed to build digest reference for %v: %v", newImage, err)
 		}
-		if err := remote.Write(digestRef, img, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
-			return "", fmt.Errorf("failed to push %v: %v", newImage, err)
-		}
-		craneImages = append(craneImages, img)
-		log.Infof("pushed %v for manifest", digestRef)
+		craneImages = append(craneImages, archImage)
+		digestRefs = append(digestRefs, digestRef)
+		archDigests[arch] = digestRef.String()
 	}
-	// Now all the images are in the registry, build the manifest. We can't just utilize `docker manifest create`,
-	// since that would be too easy - docker requires the images are in the local daemon, and loading them changes the digest.
-	// Instead, we do it ourselves again.
-	var index v1.ImageIndex = empty.Index
-	index = mutate.IndexMediaType(index, types.DockerManifestList)
-	for _, img := range craneImages {
-		mt, err := img.MediaType()
-		if err != nil {
-			return "", fmt.Errorf("failed to get mediatype: %w", err)
-		}
-
-		h, err := img.Digest()
-		if err != nil {
-			return "", fmt.Errorf("failed to compute digest: %w", err)
-		}
-
-		size, err := img.Size()
-		if err != nil {
-			return "", fmt.Errorf("failed to compute size: %w", err)
-		}
-		cfg, err := img.ConfigFile()
-		if err != nil {
-			return "", fmt.Errorf("failed to get config file: %w", err)
-		}
-		index = mutate.AppendManifests(index, mutate.IndexAddendum{
-			Add: img,
-			Descriptor: v1.Descriptor{
-				MediaType: mt,
-				Size:      size,
-				Digest:    h,
-				Platform: &v1.Platform{
-					Architecture: cfg.Architecture,
-					OS:           cfg.OS,
-					OSVersion:    cfg.OSVersion,
-					Variant:      cfg.Variant,
-					Features:     nil,
-				},
-			},
-		})
+	// Now build the manifest. We can't just utilize `docker manifest create`, since docker requires the images are in
+	// the local daemon, and loading them changes the digest. Instead, we do it ourselves.
+	index, err := util.PlatformIndex(craneImages, types.DockerManifestList)
+	if err != nil {
+		return "", nil, err
 	}
 	// Get target name without arch suffix
 	manifest := img.NewReference("")
 	manifestRef, err := name.ParseReference(manifest)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse %v: %v", manifestRef, err)
-	}
-	if err := remote.MultiWrite(map[name.Reference]remote.Taggable{manifestRef: index}, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
-		return "", fmt.Errorf("failed to push %v: %v", manifestRef, err)
+		return "", nil, fmt.Errorf("failed to parse %v: %v", manifestRef, err)
 	}
 	digest, err := index.Digest()
 	if err != nil {
-		return "", fmt.Errorf("failed to get digest for %v: %v", manifestRef, err)
+		return "", nil, fmt.Errorf("failed to get digest for %v: %v", manifestRef, err)
+	}
+	// A previous publish may have already pushed the manifest, in which case the images it refers to are pushed too.
+	if pushed, err := remoteHasDigest(manifestRef, digest); err != nil {
+		return "", nil, err
+	} else if pushed {
+		skipUpToDate("push %v", manifestRef)
+		return manifestRef.Context().String() + "@" + digest.String(), archDigests, nil
+	}
+	// Push source images first, without a tag, so users never use them.
+	for i, digestRef := range digestRefs {
+		log.Infof("starting push of %v for manifest (without tag)", digestRef)
+		if err := pushImage(digestRef, craneImages[i], "image"); err != nil {
+			return "", nil, fmt.Errorf("failed to push %v: %v", digestRef, err)
+		}
+		log.Infof("pushed %v for manifest", digestRef)
+	}
+	if err := pushImage(manifestRef, index, "manifest list"); err != nil {
+		return "", nil, fmt.Errorf("failed to push %v: %v", manifestRef, err)
 	}
 	// We need to return the digest of the manifest, not the image. This is because the manifest is what is signed.
 	// This should return something like `gcr.io/istio-testing/pilot@sha256:1234`
-	return manifestRef.Context().String() + "@" + digest.String(), nil
+	return manifestRef.Context().String() + "@" + digest.String(), archDigests, nil
+}
+
+// dockerFromLayouts publishes images from the OCI image layouts written with the "oci" docker output.
+// Each variant is pushed directly from the local content, without going through a docker daemon.
+func dockerFromLayouts(manifest model.Manifest, hub string, tags []string, signer *imageSigner,
+	sboms *sbomAttacher, lock *imageLock,
+) error {
+	layouts, err := os.ReadDir(path.Join(manifest.Directory, "docker"))
+	if err != nil {
+		return fmt.Errorf("failed to read docker output of release: %v", err)
+	}
+	for _, l := range layouts {
+		if !l.IsDir() {
+			return fmt.Errorf("invalid image layout found in docker folder: %v", l.Name())
+		}
+		imageName := l.Name()
+		index, err := layout.ImageIndexFromPath(path.Join(manifest.Directory, "docker", imageName))
+		if err != nil {
+			return fmt.Errorf("failed to read image layout %v: %v", imageName, err)
+		}
+		im, err := index.IndexManifest()
+		if err != nil {
+			return fmt.Errorf("failed to read index of %v: %v", imageName, err)
+		}
+		for _, desc := range im.Manifests {
+			variant := desc.Annotations[util.ImageVariantAnnotation]
+			variantIndex, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return fmt.Errorf("failed to read %v variant %q: %v", imageName, variant, err)
+			}
+			for _, tag := range tags {
+				img := Image{
+					NewTag:  fmt.Sprintf("%s/%s:%s", hub, imageName, tag),
+					Variant: variant,
+					Image:   imageName,
+				}
+				digest, archDigests, err := pushVariantIndex(img, variantIndex)
+				if err != nil {
+					return err
+				}
+				if signer != nil {
+					if err := signer.sign(digest, archDigests); err != nil {
+						return err
+					}
+				}
+				for arch, archDigest := range archDigests {
+					if err := sboms.attach(img, arch, archDigest); err != nil {
+						return err
+					}
+				}
+				if err := lock.add(img, img.NewReference(""), digest, len(archDigests) > 1, archDigests); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
 }
 
-// getImageNameVariant determines the name of the image (eg, pilot) and variant (eg, distroless).
-// This is derived from the file name.
-func getImageNameVariant(fname string) (name string, variant string, arch string) {
-	imageName := strings.Split(fname, ".")[0]
-	if match, _ := filepath.Match("*-arm64", imageName); match {
-		arch = "arm64"
-		imageName = strings.TrimSuffix(imageName, "-arm64")
+// pushVariantIndex pushes all architectures of a single image variant. Like images loaded from docker archives,
+// single architecture images are pushed as a plain image rather than a manifest list.
+// This returns the pushed digest reference, such as `gcr.io/istio-testing/pilot@sha256:1234`, along with the digest
+// reference of the image for each architecture.
+func pushVariantIndex(img Image, index v1.ImageIndex) (string, map[string]string, error) {
+	ref, err := name.ParseReference(img.NewReference(""))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse %v: %v", img.NewReference(""), err)
 	}
-	if match, _ := filepath.Match("*-distroless", imageName); match {
-		variant = "distroless"
-		imageName = strings.TrimSuffix(imageName, "-distroless")
+	im, err := index.IndexManifest()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read index for %v: %v", ref, err)
+	}
+	if len(im.Manifests) == 0 {
+		return "", nil, fmt.Errorf("index for %v has no images", ref)
+	}
+	archDigests := map[string]string{}
+	for _, m := range im.Manifests {
+		arch := ""
+		if m.Platform != nil {
+			arch = m.Platform.Architecture
+		}
+		archDigests[arch] = ref.Context().String() + "@" + m.Digest.String()
 	}
-	if match, _ := filepath.Match("*-debug", imageName); match {
-		variant = "debug"
-		imageName = strings.TrimSuffix(imageName, "-debug")
+	digest := im.Manifests[0].Digest
+	if len(im.Manifests) > 1 {
+		if digest, err = index.Digest(); err != nil {
+			return "", nil, fmt.Errorf("failed to get digest for %v: %v", ref, err)
+		}
+	}
+	if pushed, err := remoteHasDigest(ref, digest); err != nil {
+		return "", nil, err
+	} else if pushed {
+		skipUpToDate("push %v", ref)
+		return ref.Context().String() + "@" + digest.String(), archDigests, nil
+	}
+	if len(im.Manifests) == 1 {
+		single, err := index.Image(digest)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read image for %v: %v", ref, err)
+		}
+		if err := pushImage(ref, single, "image"); err != nil {
+			return "", nil, fmt.Errorf("failed to push %v: %v", ref, err)
+		}
+	} else {
+		if err := pushImage(ref, index, "manifest list"); err != nil {
+			return "", nil, fmt.Errorf("failed to push %v: %v", ref, err)
+		}
 	}
-	name = imageName
-	return name, variant, arch
+	log.Infof("pushed %v@%v", ref, digest)
+	return ref.Context().String() + "@" + digest.String(), archDigests, nil
 }
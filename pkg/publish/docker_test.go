@@ -0,0 +1,29 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+)
+
+func TestPushVariantIndexEmpty(t *testing.T) {
+	img := Image{NewTag: "gcr.io/istio-release/pilot:1.2.3", Image: "pilot"}
+	if _, _, err := pushVariantIndex(img, empty.Index); err == nil || !strings.Contains(err.Error(), "has no images") {
+		t.Fatalf("expected an empty index to be rejected, got %v", err)
+	}
+}
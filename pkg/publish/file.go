@@ -0,0 +1,273 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+)
+
+// fileStore is an ObjectStore backed by a local directory, laid out exactly like a bucket. Objects are written
+// atomically, so the directory can be served by a static web server while publishing.
+type fileStore struct {
+	root string
+}
+
+var (
+	_ ObjectStore  = &fileStore{}
+	_ objectCopier = &fileStore{}
+)
+
+// fileLockTimeout is how long a conditional write waits for another writer of the same object.
+const fileLockTimeout = time.Minute
+
+// NewFileStore returns a store for the given directory, creating it if needed.
+func NewFileStore(root string) (ObjectStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %v: %v", root, err)
+	}
+	return &fileStore{root: root}, nil
+}
+
+// FileArchive publishes the final release archive to the given directory
+func FileArchive(manifest model.Manifest, dir string, aliases []string) error {
+	store, err := NewFileStore(dir)
+	if err != nil {
+		return err
+	}
+	return PublishArchive(manifest, store, "", aliases)
+}
+
+func (s *fileStore) URL() string {
+	return "file://" + s.root
+}
+
+// path returns the file of an object. Keys must stay under the root, so an absolute key or one escaping it with ".."
+// cannot write elsewhere on the machine.
+func (s *fileStore) path(key string) (string, error) {
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("invalid key %q: must be a relative path within %v", key, s.root)
+	}
+	return filepath.Join(s.root, rel), nil
+}
+
+// Put copies a file to an object, unless the object already has the same contents.
+func (s *fileStore) Put(ctx context.Context, key string, file string) (PutResult, error) {
+	sum, err := fileMD5(file)
+	if err != nil {
+		return PutResult{}, err
+	}
+	dst, err := s.path(key)
+	if err != nil {
+		return PutResult{}, err
+	}
+	if existing, err := fileMD5(dst); err == nil && bytes.Equal(existing, sum) {
+		skipUpToDate("write %s/%s", s.URL(), key)
+		return PutResult{UpToDate: true}, nil
+	}
+	if planObject(s, key, PlannedObject{Source: file}) {
+		return PutResult{}, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to open %v: %v", file, err)
+	}
+	defer f.Close()
+	n, err := writeFileAtomic(dst, f)
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to write %v: %v", dst, err)
+	}
+	log.Infof("Wrote %v to %s/%s", file, s.URL(), key)
+	return PutResult{Bytes: n}, nil
+}
+
+// CopyFrom copies an object from another directory.
+func (s *fileStore) CopyFrom(ctx context.Context, src ObjectStore, srcKey string, key string) (PutResult, error) {
+	from, ok := src.(*fileStore)
+	if !ok {
+		return PutResult{}, errCopyUnsupported
+	}
+	file, err := from.path(srcKey)
+	if err != nil {
+		return PutResult{}, err
+	}
+	return s.Put(ctx, key, file)
+}
+
+// Get returns the contents of an object. The generation is the MD5 of the contents.
+func (s *fileStore) Get(ctx context.Context, key string) ([]byte, string, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", ErrObjectNotExist
+	} else if err != nil {
+		return nil, "", err
+	}
+	return content, contentGeneration(content), nil
+}
+
+func contentGeneration(content []byte) string {
+	return hex.EncodeToString(md5Sum(content))
+}
+
+// PutIfGeneration writes an object if it is unchanged. Writers hold a lock file next to the object while comparing
+// and writing, so concurrent publishes to the same directory do not lose updates. Headers in opts are left to the
+// web server serving the directory.
+func (s *fileStore) PutIfGeneration(ctx context.Context, key string, content []byte, _ ObjectOptions, generation string) error {
+	dst, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if planObject(s, key, PlannedObject{Content: string(content)}) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	unlock, err := lockFile(ctx, dst)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current := ""
+	if existing, err := os.ReadFile(dst); err == nil {
+		current = contentGeneration(existing)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if current != generation {
+		return ErrIndexOutOfDate
+	}
+	if _, err := writeFileAtomic(dst, bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("failed to write %v: %v", dst, err)
+	}
+	return nil
+}
+
+// List returns the keys of all objects starting with prefix. Temporary and lock files are not objects.
+func (s *fileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isFileStoreInternal(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v: %v", prefix, err)
+	}
+	return keys, nil
+}
+
+func (s *fileStore) Delete(ctx context.Context, key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if planObject(s, key, PlannedObject{Delete: true}) {
+		return nil
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %v: %v", key, err)
+	}
+	return nil
+}
+
+// isFileStoreInternal returns true for the temporary and lock files of a file store.
+func isFileStoreInternal(name string) bool {
+	return strings.HasPrefix(name, ".") && (strings.HasSuffix(name, ".lock") || strings.Contains(name, ".tmp-"))
+}
+
+// writeFileAtomic writes to a temporary file next to dst, then renames it over dst, so readers never see a
+// partially written file.
+func writeFileAtomic(dst string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	// CreateTemp makes the file private, but it is served publicly.
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), dst)
+}
+
+// lockFile creates a lock file for path, waiting for any other holder to release it. Returns a function to release
+// the lock.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	lock := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+	deadline := time.Now().Add(fileLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { _ = os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to lock %v: %v", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %v; remove it if no publish is running", lock)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+func TestFileArchive(t *testing.T) {
+	release := t.TempDir()
+	for file, content := range map[string]string{
+		"istio-1.2.3-linux-amd64.tar.gz": "archive",
+		"helm/base-1.2.3.tgz":            "chart",
+		"logs/build/001-make.log":        "log",
+	} {
+		if err := os.MkdirAll(filepath.Join(release, filepath.Dir(file)), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(release, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := model.Manifest{Directory: release, Version: "1.2.3"}
+	dir := t.TempDir()
+
+	// Publishing again is a no-op, as everything is up to date.
+	for i := 0; i < 2; i++ {
+		if err := FileArchive(manifest, dir, []string{"latest"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	keys, err := store.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Command logs are never published.
+	want := []string{"1.2.3/helm/base-1.2.3.tgz", "1.2.3/istio-1.2.3-linux-amd64.tar.gz", "latest"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected objects %v, got %v", want, keys)
+	}
+	latest, generation, err := store.Get(ctx, "latest")
+	if err != nil || string(latest) != "1.2.3" {
+		t.Fatalf("expected latest to be 1.2.3, got %q: %v", latest, err)
+	}
+
+	// An empty file is written, rather than reported as up to date, the first time.
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, upToDate := range []bool{false, true} {
+		res, err := store.Put(ctx, "empty", empty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.UpToDate != upToDate || res.Bytes != 0 {
+			t.Fatalf("expected up to date %v, got %+v", upToDate, res)
+		}
+	}
+
+	// A write based on an outdated read conflicts.
+	if err := store.PutIfGeneration(ctx, "latest", []byte("1.2.4"), ObjectOptions{}, generation); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutIfGeneration(ctx, "latest", []byte("1.2.5"), ObjectOptions{}, generation); !errors.Is(err, ErrIndexOutOfDate) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if err := store.PutIfGeneration(ctx, "new", []byte("1.2.5"), ObjectOptions{}, generation); !errors.Is(err, ErrIndexOutOfDate) {
+		t.Fatalf("expected conflict for a missing object, got %v", err)
+	}
+	if _, _, err := store.Get(ctx, "new"); !errors.Is(err, ErrObjectNotExist) {
+		t.Fatalf("expected missing object, got %v", err)
+	}
+}
+
+func TestFileStoreKeysStayUnderRoot(t *testing.T) {
+	parent := t.TempDir()
+	store, err := NewFileStore(filepath.Join(parent, "releases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(src, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"../escape", "1.2.3/../../escape", "/tmp/escape", ""} {
+		if _, err := store.Put(ctx, key, src); err == nil {
+			t.Fatalf("expected key %q to be rejected", key)
+		}
+		if err := store.PutIfGeneration(ctx, key, []byte("content"), ObjectOptions{}, ""); err == nil {
+			t.Fatalf("expected key %q to be rejected", key)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Fatalf("expected key %q to be rejected", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escape")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected nothing to be written outside the store, got %v", err)
+	}
+	// Keys that clean to a path within the root are fine.
+	if _, err := store.Put(ctx, "1.2.3/../latest", src); err != nil {
+		t.Fatal(err)
+	}
+}
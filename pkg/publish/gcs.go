@@ -20,13 +20,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
-	"path"
-	"path/filepath"
-	"strings"
+	"strconv"
 
 	"cloud.google.com/go/storage"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
 	"istio.io/istio/pkg/log"
@@ -43,171 +43,188 @@ func NewGCSClient(ctx context.Context) (*storage.Client, error) {
 	return storage.NewClient(ctx, opts...)
 }
 
+// gcsStore is an ObjectStore backed by a GCS bucket.
+type gcsStore struct {
+	bkt *storage.BucketHandle
+}
+
+var (
+	_ ObjectStore  = &gcsStore{}
+	_ objectCopier = &gcsStore{}
+)
+
+// NewGCSStore returns a store for the given GCS bucket.
+func NewGCSStore(ctx context.Context, bucketName string) (ObjectStore, error) {
+	client, err := NewGCSClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsStore{bkt: client.Bucket(bucketName)}, nil
+}
+
 // GcsArchive publishes the final release archive to the given GCS bucket
 func GcsArchive(manifest model.Manifest, bucket string, aliases []string) error {
-	ctx := context.Background()
-	client, err := NewGCSClient(ctx)
+	bucketName, objectPrefix := splitBucket(bucket)
+	store, err := NewGCSStore(context.Background(), bucketName)
 	if err != nil {
-		// TODO: Handle error.
 		return err
 	}
+	return PublishArchive(manifest, store, objectPrefix, aliases)
+}
+
+func (g *gcsStore) URL() string {
+	return "gs://" + g.bkt.BucketName()
+}
+
+// gcsChunkSize is the size of each request of a resumable upload. Smaller files are uploaded in a single request.
+const gcsChunkSize = 16 << 20
 
-	// Allow the caller to pass a reference like bucket/folder/subfolder, but split this to
-	// bucket, and folder/subfolder prefix
-	splitbucket := strings.SplitN(bucket, "/", 2)
-	bucketName := splitbucket[0]
-	objectPrefix := ""
-	if len(splitbucket) > 1 {
-		objectPrefix = splitbucket[1]
+func (g *gcsStore) Put(ctx context.Context, key string, file string) (PutResult, error) {
+	obj := g.bkt.Object(key)
+	sum, err := fileMD5(file)
+	if err != nil {
+		return PutResult{}, err
 	}
-	bkt := client.Bucket(bucketName)
-	if err := filepath.Walk(manifest.Directory, func(p string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		objName := path.Join(objectPrefix, manifest.Version, strings.TrimPrefix(p, manifest.Directory))
-		obj := bkt.Object(objName)
-		w := obj.NewWriter(ctx)
-		f, err := os.Open(p)
-		if err != nil {
-			return fmt.Errorf("failed to open %v: %v", p, err)
-		}
-		r := bufio.NewReader(f)
-		if _, err := io.Copy(w, r); err != nil {
-			return fmt.Errorf("failed writing %v: %v", p, err)
-		}
-		if err := w.Close(); err != nil {
-			return fmt.Errorf("failed to close bucket: %v", err)
-		}
-		log.Infof("Wrote %v to gs://%s/%s", p, bucketName, objName)
-		return nil
-	}); err != nil {
-		return fmt.Errorf("failed to walk directory: %v", err)
+	if same, err := gcsObjectMatches(ctx, obj, sum); err != nil {
+		return PutResult{}, err
+	} else if same {
+		skipUpToDate("write %s/%s", g.URL(), key)
+		return PutResult{UpToDate: true}, nil
 	}
-
-	// Add alias objects. These are basically symlinks/tags for GCS, pointing to the latest version
-	for _, alias := range aliases {
-		w := bkt.Object(path.Join(objectPrefix, alias)).NewWriter(ctx)
-		if _, err := w.Write([]byte(manifest.Version)); err != nil {
-			return fmt.Errorf("failed to write alias %v: %v", alias, err)
-		}
-		if err := w.Close(); err != nil {
-			return fmt.Errorf("failed to close bucket: %v", err)
-		}
-		log.Infof("Wrote %v to gs://%s/%s", alias, bucketName, path.Join(objectPrefix, alias))
+	if planObject(g, key, PlannedObject{Source: file}) {
+		return PutResult{}, nil
 	}
-
-	return nil
-}
-
-func FetchObject(bkt *storage.BucketHandle, objectPrefix string, filename string) ([]byte, error) {
-	objName := filepath.Join(objectPrefix, filename)
-	obj := bkt.Object(objName)
-	r, err := obj.NewReader(context.Background())
+	f, err := os.Open(file)
 	if err != nil {
-		return nil, err
+		return PutResult{}, fmt.Errorf("failed to open %v: %v", file, err)
 	}
-	c, err := io.ReadAll(r)
+	defer f.Close()
+	info, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return PutResult{}, err
+	}
+	// Cancelling the context aborts the upload, rather than leaving a partial object.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := obj.NewWriter(ctx)
+	if info.Size() < gcsChunkSize {
+		w.ChunkSize = 0
+	} else {
+		w.ChunkSize = gcsChunkSize
 	}
-	return c, nil
-}
-
-// MutateObject allows pulling a file from GCS, mutating it, then pushing it back up. This adds checks
-// to ensure that if the file is mutated in the meantime, the process is repeated.
-func MutateObject(outDir string, bkt *storage.BucketHandle, objectPrefix string, filename string, f func() error) error {
-	for i := 0; i < 10; i++ {
-		err := mutateObjectInner(outDir, bkt, objectPrefix, filename, f)
-		if err == ErrIndexOutOfDate {
-			log.Warnf("Write conflict, trying again")
-			continue
-		}
-		return err
+	// The MD5 is verified by GCS, so a corrupted upload fails rather than being published.
+	w.MD5 = sum
+	n, err := io.Copy(w, bufio.NewReader(f))
+	if err != nil {
+		// Close after cancelling, so the upload is aborted and its resources released.
+		cancel()
+		_ = w.Close()
+		return PutResult{}, fmt.Errorf("failed writing %v: %v", file, err)
 	}
-	return fmt.Errorf("max conflicts attempted")
+	if err := w.Close(); err != nil {
+		return PutResult{}, fmt.Errorf("failed to close bucket: %v", err)
+	}
+	log.Infof("Wrote %v to %s/%s", file, g.URL(), key)
+	return PutResult{Bytes: n}, nil
 }
 
-func mutateObjectInner(outDir string, bkt *storage.BucketHandle, objectPrefix string, filename string, f func() error) error {
-	objName := filepath.Join(objectPrefix, filename)
-	outFile := filepath.Join(outDir, filename)
-	obj := bkt.Object(objName)
-	attr, err := obj.Attrs(context.Background())
+// CopyFrom copies an object from another bucket, without downloading it.
+func (g *gcsStore) CopyFrom(ctx context.Context, src ObjectStore, srcKey string, key string) (PutResult, error) {
+	from, ok := src.(*gcsStore)
+	if !ok {
+		return PutResult{}, errCopyUnsupported
+	}
+	srcObj := from.bkt.Object(srcKey)
+	attrs, err := srcObj.Attrs(ctx)
 	if err != nil {
-		if errors.Is(err, storage.ErrObjectNotExist) {
-			// Missing is fine
-			log.Warnf("existing file %v does not exist", filename)
-		} else {
-			return fmt.Errorf("failed to fetch attributes for object %s: %v", objName, err)
-		}
+		return PutResult{}, fmt.Errorf("failed to fetch attributes for object %s: %v", srcKey, err)
 	}
-	generation := int64(0)
-	if attr != nil {
-		generation = attr.Generation
-		log.Infof("Object %v currently has generation %d", objName, generation)
-
-		r, err := obj.NewReader(context.Background())
-		if err != nil {
-			if err == storage.ErrObjectNotExist {
-				// This may be fine if we are publishing for the first time. Helm will allow us to `--merge non-existing-file.yaml`.
-				log.Warnf("existing file %v does not exist", filename)
-				return nil
-			}
-			return err
-		}
-		idx, err := io.ReadAll(r)
-		if err != nil {
-			return err
-		}
-		if err := os.WriteFile(outFile, idx, 0o644); err != nil {
-			return err
-		}
-		r.Close()
-		log.Infof("Wrote %v", outFile)
+	obj := g.bkt.Object(key)
+	if same, err := gcsObjectMatches(ctx, obj, attrs.MD5); err != nil {
+		return PutResult{}, err
+	} else if same {
+		skipUpToDate("copy %s/%s to %s/%s", from.URL(), srcKey, g.URL(), key)
+		return PutResult{UpToDate: true}, nil
 	}
-
-	// Run our action
-	if err := f(); err != nil {
-		return fmt.Errorf("action failed: %v", err)
+	if planObject(g, key, PlannedObject{Source: from.URL() + "/" + srcKey}) {
+		return PutResult{}, nil
 	}
-
-	// Now we want to (try to) write it
-	o := obj
-	if generation > 0 {
-		o = o.If(storage.Conditions{GenerationMatch: generation})
+	if _, err := obj.CopierFrom(srcObj).Run(ctx); err != nil {
+		return PutResult{}, fmt.Errorf("failed to copy %s/%s to %v: %v", from.URL(), srcKey, key, err)
 	}
-	w := o.NewWriter(context.Background())
-
-	// Ensure we do not cache. This would be fine for normal users reading, but it ends up making the release process
-	// break if we have multiple releases too quickly (default cache is 1hr).
-	w.CacheControl = "no-cache, max-age=0, no-transform"
-
-	// https://helm.sh/docs/topics/chart_repository/#ordinary-web-servers
-	w.ContentType = "text/yaml"
+	log.Infof("Copied %s/%s to %s/%s", from.URL(), srcKey, g.URL(), key)
+	return PutResult{Bytes: attrs.Size}, nil
+}
 
-	res, err := os.Open(outFile)
+func (g *gcsStore) Get(ctx context.Context, key string) ([]byte, string, error) {
+	r, err := g.bkt.Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, "", ErrObjectNotExist
+	} else if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("failed to open %v: %v", res.Name(), err)
+		return nil, "", err
 	}
-	if _, err := io.Copy(w, bufio.NewReader(res)); err != nil {
-		return fmt.Errorf("failed writing %v: %v", res.Name(), err)
+	return content, strconv.FormatInt(r.Attrs.Generation, 10), nil
+}
+
+func (g *gcsStore) PutIfGeneration(ctx context.Context, key string, content []byte, opts ObjectOptions, generation string) error {
+	if planObject(g, key, PlannedObject{Content: string(content)}) {
+		return nil
+	}
+	conds := storage.Conditions{DoesNotExist: true}
+	if generation != "" {
+		gen, err := strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid generation %q: %v", generation, err)
+		}
+		conds = storage.Conditions{GenerationMatch: gen}
+	}
+	// Cancelling the context aborts the upload, rather than leaving a partial object.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := g.bkt.Object(key).If(conds).NewWriter(ctx)
+	w.ContentType = opts.ContentType
+	w.CacheControl = opts.CacheControl
+	if _, err := w.Write(content); err != nil {
+		cancel()
+		_ = w.Close()
+		return fmt.Errorf("failed writing %v: %v", key, err)
 	}
 	if err := w.Close(); err != nil {
-		gerr, ok := err.(*googleapi.Error)
-		if ok && gerr.Code == 412 {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
 			return ErrIndexOutOfDate
 		}
 		return fmt.Errorf("failed to close bucket: %v", err)
 	}
-	if attr, err := obj.Attrs(context.Background()); err != nil {
-		log.Errorf("failed to get attributes: %v", err)
-	} else {
-		log.Infof("Object %v now has generation %d", objName, attr.Generation)
-	}
 	return nil
 }
 
-var ErrIndexOutOfDate = errors.New("index is out-of-date")
+func (g *gcsStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	it := g.bkt.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %v: %v", prefix, err)
+		}
+		keys = append(keys, attrs.Name)
+	}
+}
+
+func (g *gcsStore) Delete(ctx context.Context, key string) error {
+	if planObject(g, key, PlannedObject{Delete: true}) {
+		return nil
+	}
+	if err := g.bkt.Object(key).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete %v: %v", key, err)
+	}
+	return nil
+}
@@ -17,6 +17,7 @@ package publish
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path"
 	"regexp"
@@ -74,17 +75,38 @@ func GithubRelease(manifest model.Manifest, client *github.Client, githuborg str
 
 	relName := fmt.Sprintf("Istio %s", manifest.Version)
 
-	rel, _, err := client.Repositories.CreateRelease(ctx, githuborg, "istio", &github.RepositoryRelease{
+	// A previous publish may have already created the release, in which case only missing assets are uploaded.
+	rel, err := findGithubRelease(ctx, client, githuborg, manifest.Version)
+	if err != nil {
+		return err
+	}
+	release := &github.RepositoryRelease{
 		TagName:    &manifest.Version,
 		Body:       &body,
 		Draft:      &ptrue,
 		Prerelease: &ptrue,
 		Name:       &relName,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to publish github release: %v", err)
 	}
-	util.YamlLog("Release", rel)
+	if rel != nil {
+		skipUpToDate("create github release %v", manifest.Version)
+	} else if recordPlan(func(p *Plan) {
+		p.GithubRelease = &PlannedRelease{
+			Repository: githuborg + "/istio",
+			Tag:        release.GetTagName(),
+			Name:       release.GetName(),
+			Draft:      release.GetDraft(),
+			Prerelease: release.GetPrerelease(),
+		}
+	}) {
+		// The planned release does not exist yet, so has no ID or assets.
+		rel = release
+	} else {
+		rel, _, err = client.Repositories.CreateRelease(ctx, githuborg, "istio", release)
+		if err != nil {
+			return fmt.Errorf("failed to publish github release: %v", err)
+		}
+		util.YamlLog("Release", rel)
+	}
 
 	if err := GithubUploadReleaseAssets(ctx, manifest, client, githuborg, rel); err != nil {
 		return fmt.Errorf("failed to publish github release assets: %v", err)
@@ -92,26 +114,90 @@ func GithubRelease(manifest model.Manifest, client *github.Client, githuborg str
 	return nil
 }
 
+// findGithubRelease returns the release for a tag, or nil if there is none. Draft releases are not returned by
+// GetReleaseByTag, so the releases are listed instead.
+func findGithubRelease(ctx context.Context, client *github.Client, githuborg string, tag string) (*github.RepositoryRelease, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		releases, resp, err := client.Repositories.ListReleases(ctx, githuborg, "istio", opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list github releases: %v", err)
+		}
+		for _, rel := range releases {
+			if rel.GetTagName() == tag {
+				return rel, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
 func GithubUploadReleaseAssets(ctx context.Context, manifest model.Manifest, client *github.Client, githuborg string, rel *github.RepositoryRelease) error {
 	files, err := os.ReadDir(path.Join(manifest.Directory))
 	if err != nil {
 		return err
 	}
+	existing := map[string]*github.ReleaseAsset{}
+	opts := &github.ListOptions{PerPage: 100}
+	for rel.ID != nil {
+		assets, resp, err := client.Repositories.ListReleaseAssets(ctx, githuborg, "istio", *rel.ID, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list release assets: %v", err)
+		}
+		for _, asset := range assets {
+			existing[asset.GetName()] = asset
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
 	for _, file := range files {
 		fname := file.Name()
 		if githubArtifiactsPattern.MatchString(fname) {
+			asset, replace := existing[fname]
+			if replace {
+				info, err := file.Info()
+				if err != nil {
+					return err
+				}
+				if asset.GetState() == "uploaded" && int64(asset.GetSize()) == info.Size() {
+					skipUpToDate("upload github asset %v", fname)
+					continue
+				}
+			}
+			if recordPlan(func(p *Plan) {
+				p.GithubAssets = append(p.GithubAssets, PlannedAsset{
+					Repository: githuborg + "/istio",
+					Tag:        manifest.Version,
+					Name:       fname,
+					Replace:    replace,
+				})
+			}) {
+				continue
+			}
+			if replace {
+				// A partially uploaded or different asset blocks uploading under the same name.
+				log.Infof("github: replacing asset %v", fname)
+				if _, err := client.Repositories.DeleteReleaseAsset(ctx, githuborg, "istio", asset.GetID()); err != nil {
+					return fmt.Errorf("failed to delete asset %v: %v", fname, err)
+				}
+			}
 			log.Infof("github: uploading file %v", fname)
 			f, err := os.Open(path.Join(manifest.Directory, fname))
 			if err != nil {
 				return fmt.Errorf("failed to read file %v: %v", fname, err)
 			}
-			asset, _, err := client.Repositories.UploadReleaseAsset(ctx, githuborg, "istio", *rel.ID, &github.UploadOptions{
+			uploaded, _, err := client.Repositories.UploadReleaseAsset(ctx, githuborg, "istio", *rel.ID, &github.UploadOptions{
 				Name: fname,
 			}, f)
 			if err != nil {
 				return fmt.Errorf("failed to upload asset %v: %v", fname, err)
 			}
-			util.YamlLog("Release asset", asset)
+			util.YamlLog("Release asset", uploaded)
 		} else {
 			log.Infof("github: skipping upload of file %v", fname)
 		}
@@ -135,6 +221,24 @@ func GithubTag(client *github.Client, org string, repo string, version string, g
 	}
 
 	for _, version := range versions {
+		// A previous publish may have already created the tag. This is fine, as long as it is for the same commit.
+		existing, err := githubTagSha(ctx, client, org, repo, version)
+		if err != nil {
+			return err
+		}
+		if existing == sha {
+			skipUpToDate("tag %v/%v %v", org, repo, version)
+			continue
+		} else if existing != "" {
+			return fmt.Errorf("tag %v already exists in %v/%v at %v, expected %v", version, org, repo, existing, sha)
+		}
+
+		if recordPlan(func(p *Plan) {
+			p.GitTags = append(p.GitTags, PlannedGitTag{Repository: org + "/" + repo, Tag: version, Sha: sha})
+		}) {
+			continue
+		}
+
 		// First, create a tag
 		msg := fmt.Sprintf("Istio release %s", version)
 		tagType := "commit"
@@ -168,3 +272,24 @@ func GithubTag(client *github.Client, org string, repo string, version string, g
 
 	return nil
 }
+
+// githubTagSha returns the commit a tag points to, or "" if the tag does not exist.
+func githubTagSha(ctx context.Context, client *github.Client, org string, repo string, version string) (string, error) {
+	ref, resp, err := client.Git.GetRef(ctx, org, repo, "tags/"+version)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get tag reference %v: %v", version, err)
+	}
+	obj := ref.GetObject()
+	if obj.GetType() != "tag" {
+		return obj.GetSHA(), nil
+	}
+	// Annotated tags point to a tag object, which points to the commit.
+	tag, _, err := client.Git.GetTag(ctx, org, repo, obj.GetSHA())
+	if err != nil {
+		return "", fmt.Errorf("failed to get tag %v: %v", version, err)
+	}
+	return tag.GetObject().GetSHA(), nil
+}
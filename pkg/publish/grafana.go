@@ -16,12 +16,14 @@ package publish
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 
 	"istio.io/istio/pkg/log"
 	"istio.io/release-builder/pkg/model"
@@ -30,8 +32,21 @@ import (
 // Grafana publishes the grafana dashboards to grafana.com
 func Grafana(manifest model.Manifest, token string) error {
 	for db, id := range manifest.GrafanaDashboards {
+		if id <= 0 {
+			log.Warnf("Dashboard %v has no grafana.com ID, skipping", db)
+			continue
+		}
 		url := fmt.Sprintf("https://grafana.com/api/dashboards/%d/revisions", id)
 		dashboard := filepath.Join(manifest.Directory, "grafana", db+".json")
+		if grafanaDashboardPublished(id, dashboard, token) {
+			skipUpToDate("upload grafana dashboard %v", db)
+			continue
+		}
+		if recordPlan(func(p *Plan) {
+			p.GrafanaUpdates = append(p.GrafanaUpdates, PlannedDashboard{Dashboard: db, ID: id})
+		}) {
+			continue
+		}
 		req, err := fileUploadRequest(url, "json", dashboard)
 		if err != nil {
 			return fmt.Errorf("failed to create request for %v: %v", db, err)
@@ -48,6 +63,38 @@ func Grafana(manifest model.Manifest, token string) error {
 	return nil
 }
 
+// grafanaDashboardPublished returns true if the latest revision of the dashboard on grafana.com is the same as the
+// local dashboard. Any failure to fetch the latest revision is treated as not published, so the dashboard is uploaded.
+func grafanaDashboardPublished(id int, dashboard string, token string) bool {
+	local, err := os.ReadFile(dashboard)
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://grafana.com/api/dashboards/%d/revisions/latest/download", id), nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	published, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false
+	}
+	// Compare the parsed dashboards, so formatting differences are ignored.
+	var l, p any
+	if json.Unmarshal(local, &l) != nil || json.Unmarshal(published, &p) != nil {
+		return false
+	}
+	return reflect.DeepEqual(l, p)
+}
+
 // Creates a new file upload http request
 func fileUploadRequest(uri string, paramName, path string) (*http.Request, error) {
 	file, err := os.Open(path)
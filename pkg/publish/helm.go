@@ -15,18 +15,20 @@
 package publish
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
-	"cloud.google.com/go/storage"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/smithy-go/ptr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"helm.sh/helm/v4/pkg/chart/v2/loader"
 	"sigs.k8s.io/yaml"
 
 	"istio.io/istio/pkg/log"
@@ -39,16 +41,19 @@ var chartSubtypeDir = []string{
 	"samples",
 }
 
-// Helm publishes charts to the given GCS bucket
-func Helm(manifest model.Manifest, bucket string, hub string, r2bucket string) error {
-	if bucket != "" {
-		if err := publishHelmIndex(manifest, bucket); err != nil {
-			return err
-		}
-	}
+// HelmRepository is a helm chart repository in an object store.
+type HelmRepository struct {
+	Store ObjectStore
+	// Prefix is the folder in the store holding the repository.
+	Prefix string
+	// URL is the public URL the repository is served from.
+	URL string
+}
 
-	if r2bucket != "" {
-		if err := publishHelmIndexS3(manifest, r2bucket); err != nil {
+// Helm publishes charts to the given helm repositories, and OCI hub
+func Helm(manifest model.Manifest, repos []HelmRepository, hub string) error {
+	for _, repo := range repos {
+		if err := publishHelmIndex(manifest, repo); err != nil {
 			return err
 		}
 	}
@@ -62,26 +67,18 @@ func Helm(manifest model.Manifest, bucket string, hub string, r2bucket string) e
 	return nil
 }
 
-func publishHelmIndex(manifest model.Manifest, bucket string) error {
-	ctx := context.Background()
-	client, err := NewGCSClient(ctx)
-	if err != nil {
-		return err
-	}
+// gcsHelmURL is the URL charts in a GCS helm repository are served from, by default.
+func gcsHelmURL(bucketName, objectPrefix string) string {
+	return fmt.Sprintf("https://%s.storage.googleapis.com/%s", bucketName, objectPrefix)
+}
 
-	// Allow the caller to pass a reference like bucket/folder/subfolder, but split this to
-	// bucket, and folder/subfolder prefix
-	splitbucket := strings.SplitN(bucket, "/", 2)
-	bucketName := splitbucket[0]
-	objectPrefix := ""
-	if len(splitbucket) > 1 {
-		objectPrefix = splitbucket[1]
-	}
-	log.Infof("Using bucket %s and prefix %s", bucketName, objectPrefix)
-	bkt := client.Bucket(bucketName)
-	log.Infof("Using bucket %v", bkt)
+func publishHelmIndex(manifest model.Manifest, repo HelmRepository) error {
+	ctx := context.Background()
+	log.Infof("Using bucket %s and prefix %s", repo.Store.URL(), repo.Prefix)
 
 	helmPublishRoot := filepath.Join(manifest.Directory, "helm")
+	indexFile := filepath.Join(helmPublishRoot, "index.yaml")
+	indexKey := path.Join(repo.Prefix, "index.yaml")
 
 	// Pull down the index, update it, and push it back up.
 	// MutateObject ensures there are no races.
@@ -89,160 +86,76 @@ func publishHelmIndex(manifest model.Manifest, bucket string) error {
 	// Note that `helm repo index` will index charts in subdirectories as well, which
 	// is desired behavior here - we will have to push them separately however,
 	// so the index matches the bucket contents.
-	err = MutateObject(helmPublishRoot, bkt, objectPrefix, "index.yaml", func() error {
-		dumpIndexFile(filepath.Join(helmPublishRoot, "index.yaml"), "before")
+	opts := ObjectOptions{
+		// https://helm.sh/docs/topics/chart_repository/#ordinary-web-servers
+		ContentType: "text/yaml",
+		// Ensure we do not cache. This would be fine for normal users reading, but it ends up making the release process
+		// break if we have multiple releases too quickly (default cache is 1hr).
+		CacheControl: "no-cache, max-age=0, no-transform",
+	}
+	err := MutateObject(ctx, repo.Store, indexKey, opts, func(current []byte) ([]byte, error) {
+		// This may be the first publish to the repository. Helm will allow us to `--merge non-existing-file.yaml`.
+		if current != nil {
+			if err := os.WriteFile(indexFile, current, 0o644); err != nil {
+				return nil, err
+			}
+		} else if err := os.Remove(indexFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		dumpIndexFile(indexFile, "before")
 		idxCmd := util.VerboseCommand("helm", "repo", "index", ".",
-			"--url", fmt.Sprintf("https://%s.storage.googleapis.com/%s", bucketName, objectPrefix),
+			"--url", repo.URL,
 			"--merge", "index.yaml")
 		idxCmd.Dir = helmPublishRoot
 		log.Infof("Running helm repo index with dir %v", idxCmd.Dir)
 		if err := idxCmd.Run(); err != nil {
-			return fmt.Errorf("index repo: %v", err)
+			return nil, fmt.Errorf("index repo: %v", err)
 		}
-		dumpIndexFile(filepath.Join(helmPublishRoot, "index.yaml"), "after")
-		return nil
+		dumpIndexFile(indexFile, "after")
+		return os.ReadFile(indexFile)
 	})
 	if err != nil {
 		return fmt.Errorf("helm publish: %v", err)
 	}
 
-	// Add extra logging for the actual object in GCS to ensure its written correctly
-	liveObject, err := FetchObject(bkt, objectPrefix, "index.yaml")
-	if err != nil {
+	// Add extra logging for the actual object to ensure its written correctly
+	if liveObject, _, err := repo.Store.Get(ctx, indexKey); err != nil {
 		log.Warnf("failed to get live index.yaml: %v", err)
 	} else {
 		dumpIndex(liveObject, "live")
 	}
 
-	// Now push all the packaged charts in the helm root directory up
-	if err := publishHelmBucket(ctx, helmPublishRoot, objectPrefix, bucketName, bkt); err != nil {
-		return err
-	}
-
-	// For any packaged charts in "chart subtype" subdirectories ("samples" etc), push those up
-	for _, chartType := range chartSubtypeDir {
-		if err := publishHelmBucket(ctx, filepath.Join(helmPublishRoot, chartType), path.Join(objectPrefix, chartType), bucketName, bkt); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func publishHelmBucket(ctx context.Context, packagedChartOutputDir, publishPrefix, bName string, bkt *storage.BucketHandle) error {
-	dirInfo, err := os.ReadDir(packagedChartOutputDir)
+	// Now push all the packaged charts in the helm root directory, and any "chart subtype" subdirectories
+	// ("samples" etc), up
+	charts, err := helmCharts(manifest)
 	if err != nil {
 		return err
 	}
-	for _, f := range dirInfo {
-		if filepath.Ext(f.Name()) != ".tgz" {
-			log.Infof("skipping %v", f.Name())
-			continue
-		}
-		objName := path.Join(publishPrefix, f.Name())
-		obj := bkt.Object(objName)
-		w := obj.NewWriter(ctx)
-		f, err := os.Open(filepath.Join(packagedChartOutputDir, f.Name()))
-		if err != nil {
-			return fmt.Errorf("failed to open %v: %v", f.Name(), err)
-		}
-		r := bufio.NewReader(f)
-		if _, err := io.Copy(w, r); err != nil {
-			return fmt.Errorf("failed writing %v: %v", f.Name(), err)
-		}
-		if err := w.Close(); err != nil {
-			return fmt.Errorf("failed to close bucket: %v", err)
-		}
-		log.Infof("Wrote %v to gs://%s/%s", f.Name(), bName, objName)
+	objects := []releaseObject{}
+	for _, c := range charts {
+		objects = append(objects, releaseObject{file: filepath.Join(helmPublishRoot, c), key: path.Join(repo.Prefix, c)})
 	}
-
-	return nil
-}
-
-func publishHelmIndexS3(manifest model.Manifest, bucket string) error {
-	ctx := context.Background()
-	client, err := NewS3Client()
-	if err != nil {
-		return err
-	}
-
-	// Allow the caller to pass a reference like bucket/folder/subfolder, but split this to
-	// bucket, and folder/subfolder prefix
-	splitbucket := strings.SplitN(bucket, "/", 2)
-	bucketName := splitbucket[0]
-	objectPrefix := ""
-	if len(splitbucket) > 1 {
-		objectPrefix = splitbucket[1]
-	}
-	log.Infof("Using bucket %s and prefix %s", bucketName, objectPrefix)
-
-	helmPublishRoot := filepath.Join(manifest.Directory, "helm")
-
-	// Pull down the index, update it, and push it back up.
-	// MutateObject ensures there are no races.
-	//
-	// Note that `helm repo index` will index charts in subdirectories as well, which
-	// is desired behavior here - we will have to push them separately however,
-	// so the index matches the bucket contents.
-	err = MutateObjectS3(helmPublishRoot, client, &bucketName, objectPrefix, "index.yaml", func() error {
-		dumpIndexFile(filepath.Join(helmPublishRoot, "index.yaml"), "before")
-		idxCmd := util.VerboseCommand("helm", "repo", "index", ".",
-			"--url", fmt.Sprintf("https://pub-cce2c73c70cd4021836b91b9aa11e85d.r2.dev/%s", objectPrefix),
-			"--merge", "index.yaml")
-		idxCmd.Dir = helmPublishRoot
-		log.Infof("Running helm repo index with dir %v", idxCmd.Dir)
-		if err := idxCmd.Run(); err != nil {
-			return fmt.Errorf("index repo: %v", err)
-		}
-		dumpIndexFile(filepath.Join(helmPublishRoot, "index.yaml"), "after")
-		return nil
+	return uploadObjects(ctx, repo.Store.URL(), objects, func(ctx context.Context, o releaseObject) (PutResult, error) {
+		return repo.Store.Put(ctx, o.key, o.file)
 	})
-	if err != nil {
-		return fmt.Errorf("helm publish: %v", err)
-	}
-
-	// Now push all the packaged charts in the helm root directory up
-	if err := publishHelmBucketS3(ctx, helmPublishRoot, objectPrefix, bucketName, client); err != nil {
-		return err
-	}
-
-	// For any packaged charts in "chart subtype" subdirectories ("samples" etc), push those up
-	for _, chartType := range chartSubtypeDir {
-		if err := publishHelmBucketS3(ctx, filepath.Join(helmPublishRoot, chartType), path.Join(objectPrefix, chartType), bucketName, client); err != nil {
-			return err
-		}
-	}
-
-	return nil
 }
 
-func publishHelmBucketS3(ctx context.Context, packagedChartOutputDir, publishPrefix, bName string, client *s3.Client) error {
-	dirInfo, err := os.ReadDir(packagedChartOutputDir)
-	if err != nil {
-		return err
-	}
-	for _, f := range dirInfo {
-		if filepath.Ext(f.Name()) != ".tgz" {
-			log.Infof("skipping %v", f.Name())
-			continue
-		}
-		objName := path.Join(publishPrefix, f.Name())
-		f, err := os.Open(filepath.Join(packagedChartOutputDir, f.Name()))
+// helmCharts returns the packaged charts in the release, relative to the helm directory.
+func helmCharts(manifest model.Manifest) ([]string, error) {
+	root := filepath.Join(manifest.Directory, "helm")
+	charts := []string{}
+	for _, dir := range append([]string{""}, chartSubtypeDir...) {
+		entries, err := os.ReadDir(filepath.Join(root, dir))
 		if err != nil {
-			return fmt.Errorf("failed to open %v: %v", f.Name(), err)
+			return nil, err
 		}
-		_, err = client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: ptr.String(bName),
-			Key:    ptr.String(objName),
-			Body:   f,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to put object %v: %v", objName, err)
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) == ".tgz" {
+				charts = append(charts, path.Join(dir, e.Name()))
+			}
 		}
-		log.Infof("Wrote %v to r2://%s/%s", f.Name(), bName, objName)
 	}
-
-	return nil
+	return charts, nil
 }
 
 type helmChart struct {
@@ -304,7 +217,8 @@ func pushChartsInDirOCI(packagedChartOutputDir, hub string) error {
 	// Use --plain-http for localhost registries (e.g. dry-run tests).
 	// Helm v4 no longer treats localhost as insecure by default.
 	args := []string{"push"}
-	if strings.HasPrefix(hub, "localhost") || strings.HasPrefix(hub, "127.0.0.1") {
+	plainHTTP := strings.HasPrefix(hub, "localhost") || strings.HasPrefix(hub, "127.0.0.1")
+	if plainHTTP {
 		args = append(args, "--plain-http")
 	}
 	// Publish as OCI artifacts
@@ -313,6 +227,19 @@ func pushChartsInDirOCI(packagedChartOutputDir, hub string) error {
 			continue
 		}
 		name := filepath.Join(packagedChartOutputDir, f.Name())
+		pushed, err := ociChartPushed(name, hub, plainHTTP)
+		if err != nil {
+			return err
+		}
+		if pushed {
+			skipUpToDate("push %v to oci://%v", f.Name(), hub)
+			continue
+		}
+		if recordPlan(func(p *Plan) {
+			p.HelmCharts = append(p.HelmCharts, PlannedHelmChart{Chart: p.releaseFile(name), Repository: "oci://" + hub})
+		}) {
+			continue
+		}
 		cmdArgs := append(args, name, "oci://"+hub)
 		if err := util.VerboseCommand("helm", cmdArgs...).Run(); err != nil {
 			return fmt.Errorf("failed to load docker image %v: %v", f.Name(), err)
@@ -320,3 +247,43 @@ func pushChartsInDirOCI(packagedChartOutputDir, hub string) error {
 	}
 	return nil
 }
+
+// ociChartPushed returns true if the chart version is already in the OCI repository, with the same chart content.
+func ociChartPushed(chartFile, hub string, plainHTTP bool) (bool, error) {
+	c, err := loader.LoadFile(chartFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load chart %v: %v", chartFile, err)
+	}
+	by, err := os.ReadFile(chartFile)
+	if err != nil {
+		return false, err
+	}
+	var opts []name.Option
+	if plainHTTP {
+		opts = append(opts, name.Insecure)
+	}
+	// OCI tags cannot contain '+', so helm replaces it with '_'.
+	tag := strings.ReplaceAll(c.Metadata.Version, "+", "_")
+	ref, err := name.ParseReference(path.Join(hub, c.Metadata.Name)+":"+tag, opts...)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse chart reference: %v", err)
+	}
+	if desc, err := remoteDescriptor(ref); err != nil || desc == nil {
+		return false, err
+	}
+	desc, err := remote.Get(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return false, fmt.Errorf("failed to get manifest for %v: %v", ref, err)
+	}
+	m, err := v1.ParseManifest(bytes.NewReader(desc.Manifest))
+	if err != nil {
+		return false, fmt.Errorf("failed to parse manifest for %v: %v", ref, err)
+	}
+	want := v1.Hash{Algorithm: "sha256", Hex: fmt.Sprintf("%x", sha256.Sum256(by))}
+	for _, l := range m.Layers {
+		if l.Digest == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,97 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+)
+
+// imageLockfile is the file in the release that publish pins every pushed image in, by digest. It is written before
+// the release is copied to buckets, so it is published along with the release.
+const imageLockfile = "images.yaml"
+
+// imageLock records the digest of every pushed image, to write the image lockfile.
+type imageLock struct {
+	lockfile model.ImageLockfile
+}
+
+func newImageLock(version string) *imageLock {
+	return &imageLock{lockfile: model.ImageLockfile{Version: version, Images: []model.ImageLock{}}}
+}
+
+// add records img, pushed to ref. digestRef is the digest reference ref points to, and archDigests the digest
+// reference of the image for each architecture, all in the form `gcr.io/istio-testing/pilot@sha256:1234`.
+func (l *imageLock) add(img Image, ref string, digestRef string, manifestList bool, archDigests map[string]string) error {
+	tag, err := name.NewTag(img.NewTag)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", img.NewTag, err)
+	}
+	archs := map[string]string{}
+	for arch, archDigest := range archDigests {
+		// Archives of the default architecture have no architecture in their name.
+		archs[cmp.Or(arch, "amd64")] = digestOf(archDigest)
+	}
+	l.lockfile.Images = append(l.lockfile.Images, model.ImageLock{
+		Image:         img.Image,
+		Variant:       img.Variant,
+		Tag:           tag.TagStr(),
+		Reference:     ref,
+		Digest:        digestOf(digestRef),
+		ManifestList:  manifestList,
+		Architectures: archs,
+	})
+	return nil
+}
+
+// write writes the lockfile, with images sorted by reference so it is stable between publishes.
+func (l *imageLock) write(file string) error {
+	slices.SortFunc(l.lockfile.Images, func(a, b model.ImageLock) int {
+		return strings.Compare(a.Reference, b.Reference)
+	})
+	by, err := yaml.Marshal(l.lockfile)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, by, 0o644); err != nil {
+		return fmt.Errorf("failed to write image lockfile: %v", err)
+	}
+	log.Infof("Wrote digests of %d images to %v", len(l.lockfile.Images), file)
+	return nil
+}
+
+// readImageLock reads an image lockfile.
+func readImageLock(content []byte) (model.ImageLockfile, error) {
+	lockfile := model.ImageLockfile{}
+	if err := yaml.Unmarshal(content, &lockfile); err != nil {
+		return lockfile, fmt.Errorf("failed to parse image lockfile: %v", err)
+	}
+	return lockfile, nil
+}
+
+// digestOf returns the digest of a digest reference, such as sha256:1234 for `gcr.io/istio-testing/pilot@sha256:1234`.
+func digestOf(digestRef string) string {
+	_, digest, _ := strings.Cut(digestRef, "@")
+	return digest
+}
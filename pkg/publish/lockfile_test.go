@@ -0,0 +1,77 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+func TestImageLock(t *testing.T) {
+	lock := newImageLock("1.2.3")
+	pilot := Image{NewTag: "localhost:5000/istio/pilot:1.2.3", Variant: "distroless", Image: "pilot"}
+	if err := lock.add(pilot, pilot.NewReference(""), "localhost:5000/istio/pilot@sha256:index", true, map[string]string{
+		"":      "localhost:5000/istio/pilot@sha256:amd",
+		"arm64": "localhost:5000/istio/pilot@sha256:arm",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cni := Image{NewTag: "localhost:5000/istio/install-cni:latest", Image: "install-cni"}
+	if err := lock.add(cni, cni.NewReference(""), "localhost:5000/istio/install-cni@sha256:amd", false, map[string]string{
+		"": "localhost:5000/istio/install-cni@sha256:amd",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), imageLockfile)
+	if err := lock.write(file); err != nil {
+		t.Fatal(err)
+	}
+	by, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readImageLock(by)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.ImageLockfile{
+		Version: "1.2.3",
+		Images: []model.ImageLock{
+			{
+				Image:         "install-cni",
+				Tag:           "latest",
+				Reference:     "localhost:5000/istio/install-cni:latest",
+				Digest:        "sha256:amd",
+				Architectures: map[string]string{"amd64": "sha256:amd"},
+			},
+			{
+				Image:         "pilot",
+				Variant:       "distroless",
+				Tag:           "1.2.3",
+				Reference:     "localhost:5000/istio/pilot:1.2.3-distroless",
+				Digest:        "sha256:index",
+				ManifestList:  true,
+				Architectures: map[string]string{"amd64": "sha256:amd", "arm64": "sha256:arm"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+)
+
+var (
+	// ErrObjectNotExist is returned when reading an object that does not exist.
+	ErrObjectNotExist = errors.New("object does not exist")
+	// ErrIndexOutOfDate is returned by a conditional write when the object was modified since it was read.
+	ErrIndexOutOfDate = errors.New("index is out-of-date")
+)
+
+// ObjectOptions are the headers an object is served with.
+type ObjectOptions struct {
+	ContentType  string
+	CacheControl string
+}
+
+// PutResult describes the write of an object.
+type PutResult struct {
+	// Bytes is the number of bytes written.
+	Bytes int64
+	// UpToDate is set if the object already had the same contents, so nothing was written.
+	UpToDate bool
+}
+
+// ObjectStore is a bucket that release files and helm charts are published to, such as a GCS or S3 bucket.
+type ObjectStore interface {
+	// URL identifies the bucket, such as gs://istio-release.
+	URL() string
+	// Put writes a file to an object, unless the object already has the same contents.
+	Put(ctx context.Context, key string, file string) (PutResult, error)
+	// Get returns the contents of an object, and its generation, which changes whenever the object is written.
+	// Returns ErrObjectNotExist if there is no such object.
+	Get(ctx context.Context, key string) ([]byte, string, error)
+	// PutIfGeneration writes an object, only if its generation is unchanged. An empty generation requires that the
+	// object does not exist. Returns ErrIndexOutOfDate if the object was modified.
+	PutIfGeneration(ctx context.Context, key string, content []byte, opts ObjectOptions, generation string) error
+	// List returns the keys of all objects starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete deletes an object. Deleting an object that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// splitBucket splits a bucket reference like bucket/folder/subfolder into the bucket, and the folder/subfolder
+// prefix of objects.
+func splitBucket(bucket string) (string, string) {
+	bucketName, objectPrefix, _ := strings.Cut(bucket, "/")
+	return bucketName, objectPrefix
+}
+
+// commandLogsDir holds the build command logs in the release directory. The logs include full command lines and
+// tool output, so they are kept for investigating the build, but never published.
+const commandLogsDir = "logs"
+
+// releaseObject is a file in the release, along with the object key it is published as.
+type releaseObject struct {
+	file string
+	key  string
+}
+
+// releaseObjects returns every file in the release, other than the command logs, keyed as
+// <objectPrefix>/<version>/<path in release>.
+func releaseObjects(manifest model.Manifest, objectPrefix string) ([]releaseObject, error) {
+	res := []releaseObject{}
+	if err := filepath.Walk(manifest.Directory, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p == filepath.Join(manifest.Directory, commandLogsDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		res = append(res, releaseObject{
+			file: p,
+			key:  path.Join(objectPrefix, manifest.Version, strings.TrimPrefix(p, manifest.Directory)),
+		})
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %v", err)
+	}
+	return res, nil
+}
+
+// PublishArchive publishes the release to the store under objectPrefix, and points each alias to the version.
+func PublishArchive(manifest model.Manifest, store ObjectStore, objectPrefix string, aliases []string) error {
+	ctx := context.Background()
+	objects, err := releaseObjects(manifest, objectPrefix)
+	if err != nil {
+		return err
+	}
+	if err := uploadObjects(ctx, store.URL(), objects, func(ctx context.Context, o releaseObject) (PutResult, error) {
+		return store.Put(ctx, o.key, o.file)
+	}); err != nil {
+		return err
+	}
+
+	return publishAliases(ctx, store, objectPrefix, manifest.Version, aliases)
+}
+
+// publishAliases adds alias objects. These are basically symlinks/tags, containing the version they point to.
+func publishAliases(ctx context.Context, store ObjectStore, objectPrefix string, version string, aliases []string) error {
+	for _, alias := range aliases {
+		key := path.Join(objectPrefix, alias)
+		err := MutateObject(ctx, store, key, ObjectOptions{ContentType: "text/plain"}, func([]byte) ([]byte, error) {
+			return []byte(version), nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write alias %v: %v", alias, err)
+		}
+	}
+	return nil
+}
+
+// NewObjectStore returns the store for a bucket URL, such as s3://istio-prerelease/prerelease, along with the
+// prefix of objects in the URL. Supported schemes are gs://, s3://, az:// and file://.
+func NewObjectStore(ctx context.Context, url string) (ObjectStore, string, error) {
+	scheme, bucket, f := strings.Cut(url, "://")
+	if !f {
+		return nil, "", fmt.Errorf("invalid bucket URL %v: expected <scheme>://<bucket>", url)
+	}
+	bucketName, objectPrefix := splitBucket(bucket)
+	objectPrefix = strings.Trim(objectPrefix, "/")
+	var store ObjectStore
+	var err error
+	switch scheme {
+	case "gs":
+		store, err = NewGCSStore(ctx, bucketName)
+	case "s3":
+		store, err = NewS3Store(bucketName)
+	case "az":
+		store, err = NewAzureBlobStore(bucketName)
+	case "file":
+		store, err = NewFileStore(bucket)
+		objectPrefix = ""
+	default:
+		return nil, "", fmt.Errorf("invalid bucket URL %v: unknown scheme %v", url, scheme)
+	}
+	return store, objectPrefix, err
+}
+
+// errCopyUnsupported is returned by CopyFrom when the source is not a bucket of the same kind of store.
+var errCopyUnsupported = errors.New("server-side copy is not supported")
+
+// objectCopier is implemented by stores that can copy objects from another bucket of the same kind, without
+// downloading them.
+type objectCopier interface {
+	// CopyFrom copies srcKey in src to key, unless key already has the same contents. Returns errCopyUnsupported
+	// if src cannot be copied from.
+	CopyFrom(ctx context.Context, src ObjectStore, srcKey string, key string) (PutResult, error)
+}
+
+// CopyObject copies an object between stores, server-side when the destination supports copying from the source.
+// Otherwise, the object is downloaded and uploaded again.
+func CopyObject(ctx context.Context, src ObjectStore, srcKey string, dst ObjectStore, key string) (PutResult, error) {
+	if c, ok := dst.(objectCopier); ok {
+		res, err := c.CopyFrom(ctx, src, srcKey, key)
+		if !errors.Is(err, errCopyUnsupported) {
+			return res, err
+		}
+	}
+	content, _, err := src.Get(ctx, srcKey)
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to read %s/%s: %v", src.URL(), srcKey, err)
+	}
+	f, err := os.CreateTemp("", "object-")
+	if err != nil {
+		return PutResult{}, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to write %v: %v", f.Name(), err)
+	}
+	return dst.Put(ctx, key, f.Name())
+}
+
+// MutateObject reads an object, mutates it with f, then writes it back. The write is conditional on the object
+// being unchanged, and the process is repeated on conflicts. f is passed nil if the object does not exist.
+// If f returns the current contents, nothing is written.
+func MutateObject(ctx context.Context, store ObjectStore, key string, opts ObjectOptions, f func(current []byte) ([]byte, error)) error {
+	for i := 0; i < 10; i++ {
+		err := mutateObjectInner(ctx, store, key, opts, f)
+		if err == ErrIndexOutOfDate {
+			log.Warnf("Write conflict, trying again")
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("max conflicts attempted")
+}
+
+func mutateObjectInner(ctx context.Context, store ObjectStore, key string, opts ObjectOptions, f func(current []byte) ([]byte, error)) error {
+	current, generation, err := store.Get(ctx, key)
+	if errors.Is(err, ErrObjectNotExist) {
+		// Missing is fine
+		log.Warnf("existing object %v does not exist", key)
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch object %s: %v", key, err)
+	} else {
+		log.Infof("Object %v currently has generation %v", key, generation)
+	}
+
+	// Run our action
+	updated, err := f(current)
+	if err != nil {
+		return fmt.Errorf("action failed: %v", err)
+	}
+	if current != nil && bytes.Equal(current, updated) {
+		skipUpToDate("write %s/%s", store.URL(), key)
+		return nil
+	}
+
+	// Now we want to (try to) write it
+	if err := store.PutIfGeneration(ctx, key, updated, opts, generation); err != nil {
+		return err
+	}
+	log.Infof("Wrote %s/%s", store.URL(), key)
+	return nil
+}
@@ -0,0 +1,350 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+// Plan is the set of actions a publish will take. It is computed by running the publish with every write recorded
+// in the plan instead of made, so work that is already published is skipped exactly like a publish would.
+type Plan struct {
+	Version        string             `json:"version"`
+	Objects        []PlannedObject    `json:"objects,omitempty"`
+	Images         []PlannedImage     `json:"images,omitempty"`
+	HelmCharts     []PlannedHelmChart `json:"helmCharts,omitempty"`
+	GitTags        []PlannedGitTag    `json:"gitTags,omitempty"`
+	GithubRelease  *PlannedRelease    `json:"githubRelease,omitempty"`
+	GithubAssets   []PlannedAsset     `json:"githubAssets,omitempty"`
+	GrafanaUpdates []PlannedDashboard `json:"grafanaUpdates,omitempty"`
+	// UpToDate lists the actions that are skipped, as they are already published.
+	UpToDate []string `json:"upToDate,omitempty"`
+
+	// release is the release directory, which sources are reported relative to.
+	release string
+	// pushed holds the descriptor of every planned push by reference, so later steps find the pushed images.
+	pushed map[string]v1.Descriptor
+}
+
+// PlannedObject is an object written to a bucket, such as gs://istio-release, s3://istio-release or az://istio-release.
+type PlannedObject struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// Source is the file written to the object, relative to the release for release files, or the object it is
+	// copied from.
+	Source string `json:"source,omitempty"`
+	// Content is the literal content of the object, for aliases and helm indexes.
+	Content string `json:"content,omitempty"`
+	// Delete is set if the object is deleted.
+	Delete bool `json:"delete,omitempty"`
+}
+
+// PlannedImage is a push to a registry.
+type PlannedImage struct {
+	Reference string `json:"reference"`
+	// Kind is what is pushed: an image, manifest list, sbom, signature or attestation.
+	Kind string `json:"kind"`
+	// Digest is the digest pushed. Signatures and attestations are only made when publishing, so have none.
+	Digest string `json:"digest,omitempty"`
+}
+
+// PlannedHelmChart is a chart pushed to an OCI registry.
+type PlannedHelmChart struct {
+	Chart      string `json:"chart"`
+	Repository string `json:"repository"`
+}
+
+// PlannedGitTag is a tag created in a GitHub repository.
+type PlannedGitTag struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Sha        string `json:"sha"`
+}
+
+// PlannedRelease is the GitHub release created for the release.
+type PlannedRelease struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Name       string `json:"name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// PlannedAsset is a file uploaded to the GitHub release.
+type PlannedAsset struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Name       string `json:"name"`
+	// Replace is set if a different asset with the same name is deleted first.
+	Replace bool `json:"replace,omitempty"`
+}
+
+// PlannedDashboard is a dashboard revision uploaded to grafana.com.
+type PlannedDashboard struct {
+	Dashboard string `json:"dashboard"`
+	ID        int    `json:"id"`
+}
+
+// activePlan is the plan being computed, if any. Every write of a publish is made through recordPlan, after the
+// remote state is compared, so the write is recorded in the plan instead when planning.
+var activePlan = struct {
+	sync.Mutex
+	plan *Plan
+}{}
+
+// recordPlan records a write in the active plan with add. Returns true if there is one, in which case the write
+// must not be made.
+func recordPlan(add func(p *Plan)) bool {
+	activePlan.Lock()
+	defer activePlan.Unlock()
+	if activePlan.plan == nil {
+		return false
+	}
+	add(activePlan.plan)
+	return true
+}
+
+// planning returns true if a plan is being computed, rather than publishing.
+func planning() bool {
+	return recordPlan(func(*Plan) {})
+}
+
+// planObject records a write of an object when planning.
+func planObject(store ObjectStore, key string, o PlannedObject) bool {
+	return recordPlan(func(p *Plan) {
+		o.Bucket, o.Key, o.Source = store.URL(), key, p.releaseFile(o.Source)
+		p.Objects = append(p.Objects, o)
+	})
+}
+
+// releaseFile returns file relative to the release directory, if it is in the release.
+func (p *Plan) releaseFile(file string) string {
+	if rel, f := strings.CutPrefix(file, p.release+"/"); f {
+		return rel
+	}
+	return file
+}
+
+// pushImage pushes an image or index to ref. When planning, the push is recorded instead, and the pushed
+// descriptors are returned by remoteDescriptor, as they would be from the registry.
+func pushImage(ref name.Reference, t remote.Taggable, kind string) error {
+	if planning() {
+		descs, err := pushedDescriptors(ref, t)
+		if err != nil {
+			return err
+		}
+		recordPlan(func(p *Plan) {
+			p.addImage(PlannedImage{Reference: ref.Name(), Kind: kind, Digest: descs[ref.Name()].Digest.String()})
+			maps.Copy(p.pushed, descs)
+		})
+		return nil
+	}
+	switch t := t.(type) {
+	case v1.ImageIndex:
+		return remote.WriteIndex(ref, t, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	case v1.Image:
+		return remote.Write(ref, t, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	default:
+		return fmt.Errorf("cannot push %T to %v", t, ref)
+	}
+}
+
+// addImage records a push. Images are pushed once per tag, but the same push is only listed once, as a publish
+// finds the earlier push, such as a signature of the same digest, already done.
+func (p *Plan) addImage(i PlannedImage) {
+	if !slices.Contains(p.Images, i) {
+		p.Images = append(p.Images, i)
+	}
+}
+
+// pushedDescriptors returns the descriptors a push of t to ref makes available: ref, t by digest, and the manifests
+// of an index by digest.
+func pushedDescriptors(ref name.Reference, t remote.Taggable) (map[string]v1.Descriptor, error) {
+	d, ok := t.(partial.Describable)
+	if !ok {
+		return nil, fmt.Errorf("cannot push %T to %v", t, ref)
+	}
+	desc, err := partial.Descriptor(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get descriptor for %v: %v", ref, err)
+	}
+	descs := map[string]v1.Descriptor{
+		ref.Name(): *desc,
+		ref.Context().Digest(desc.Digest.String()).Name(): *desc,
+	}
+	if index, ok := t.(v1.ImageIndex); ok {
+		im, err := index.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index for %v: %v", ref, err)
+		}
+		for _, m := range im.Manifests {
+			descs[ref.Context().Digest(m.Digest.String()).Name()] = m
+		}
+	}
+	return descs, nil
+}
+
+// plannedDescriptor returns the descriptor of a planned push to ref, if any.
+func plannedDescriptor(ref name.Reference) (*v1.Descriptor, bool) {
+	var desc *v1.Descriptor
+	recordPlan(func(p *Plan) {
+		if d, f := p.pushed[ref.Name()]; f {
+			desc = &d
+		}
+	})
+	return desc, desc != nil
+}
+
+// NewPlan computes the actions Publish will take with the current flags, by running it with every write recorded in
+// the plan instead. The destinations are still read to skip work that is already published, so this needs the same
+// credentials as publishing. Files publish derives within the release, such as the image lockfile, are still written.
+func NewPlan(manifest model.Manifest) (*Plan, error) {
+	p := &Plan{Version: manifest.Version, release: manifest.Directory, pushed: map[string]v1.Descriptor{}}
+	skippedActions.Lock()
+	skipped := len(skippedActions.actions)
+	skippedActions.Unlock()
+
+	activePlan.Lock()
+	activePlan.plan = p
+	activePlan.Unlock()
+	defer func() {
+		activePlan.Lock()
+		activePlan.plan = nil
+		activePlan.Unlock()
+	}()
+	if err := Publish(manifest); err != nil {
+		return nil, err
+	}
+
+	skippedActions.Lock()
+	p.UpToDate = slices.Clone(skippedActions.actions[skipped:])
+	skippedActions.Unlock()
+	// Uploads run concurrently, and some steps iterate maps, so sort everything to keep the plan stable.
+	slices.SortFunc(p.Objects, func(a, b PlannedObject) int {
+		return cmp.Or(strings.Compare(a.Bucket, b.Bucket), strings.Compare(a.Key, b.Key))
+	})
+	slices.SortFunc(p.Images, func(a, b PlannedImage) int {
+		return cmp.Or(strings.Compare(a.Reference, b.Reference), strings.Compare(a.Kind, b.Kind))
+	})
+	slices.SortFunc(p.HelmCharts, func(a, b PlannedHelmChart) int {
+		return strings.Compare(a.Chart, b.Chart)
+	})
+	slices.SortFunc(p.GitTags, func(a, b PlannedGitTag) int {
+		return cmp.Or(strings.Compare(a.Repository, b.Repository), strings.Compare(a.Tag, b.Tag))
+	})
+	slices.SortFunc(p.GithubAssets, func(a, b PlannedAsset) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.SortFunc(p.GrafanaUpdates, func(a, b PlannedDashboard) int {
+		return strings.Compare(a.Dashboard, b.Dashboard)
+	})
+	slices.Sort(p.UpToDate)
+	return p, nil
+}
+
+// Print writes a human readable summary of the plan.
+func (p *Plan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Publish plan for Istio %s\n", p.Version)
+	if len(p.Images) > 0 {
+		fmt.Fprintf(w, "\nImages:\n")
+		for _, i := range p.Images {
+			if i.Digest != "" {
+				fmt.Fprintf(w, "  push %s %s@%s\n", i.Kind, i.Reference, i.Digest)
+			} else {
+				fmt.Fprintf(w, "  push %s %s\n", i.Kind, i.Reference)
+			}
+		}
+	}
+	if len(p.Objects) > 0 {
+		fmt.Fprintf(w, "\nObjects:\n")
+		for _, o := range p.Objects {
+			switch {
+			case o.Delete:
+				fmt.Fprintf(w, "  delete %s/%s\n", o.Bucket, o.Key)
+			case o.Source != "":
+				fmt.Fprintf(w, "  write %s/%s from %s\n", o.Bucket, o.Key, o.Source)
+			case strings.Contains(o.Content, "\n"):
+				fmt.Fprintf(w, "  write %s/%s (%d bytes)\n", o.Bucket, o.Key, len(o.Content))
+			default:
+				fmt.Fprintf(w, "  write %s/%s with %q\n", o.Bucket, o.Key, o.Content)
+			}
+		}
+	}
+	if len(p.HelmCharts) > 0 {
+		fmt.Fprintf(w, "\nHelm charts:\n")
+		for _, c := range p.HelmCharts {
+			fmt.Fprintf(w, "  push %s to %s\n", c.Chart, c.Repository)
+		}
+	}
+	if len(p.GitTags) > 0 {
+		fmt.Fprintf(w, "\nGit tags:\n")
+		for _, t := range p.GitTags {
+			fmt.Fprintf(w, "  tag %s %s at %s\n", t.Repository, t.Tag, t.Sha)
+		}
+	}
+	if r := p.GithubRelease; r != nil || len(p.GithubAssets) > 0 {
+		fmt.Fprintf(w, "\nGitHub release:\n")
+		if r != nil {
+			fmt.Fprintf(w, "  create %q in %s for tag %s (draft: %v, prerelease: %v)\n", r.Name, r.Repository, r.Tag, r.Draft, r.Prerelease)
+		}
+		for _, a := range p.GithubAssets {
+			if a.Replace {
+				fmt.Fprintf(w, "  replace asset %s of %s %s\n", a.Name, a.Repository, a.Tag)
+			} else {
+				fmt.Fprintf(w, "  upload asset %s to %s %s\n", a.Name, a.Repository, a.Tag)
+			}
+		}
+	}
+	if len(p.GrafanaUpdates) > 0 {
+		fmt.Fprintf(w, "\nGrafana dashboards:\n")
+		for _, d := range p.GrafanaUpdates {
+			fmt.Fprintf(w, "  upload %s as a revision of dashboard %d\n", d.Dashboard, d.ID)
+		}
+	}
+	if len(p.UpToDate) > 0 {
+		fmt.Fprintf(w, "\nAlready up to date:\n")
+		for _, a := range p.UpToDate {
+			fmt.Fprintf(w, "  %s\n", a)
+		}
+	}
+}
+
+// WriteJSON writes the plan as JSON, for approval workflows.
+func (p *Plan) WriteJSON(file string) error {
+	by, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %v", err)
+	}
+	if err := os.WriteFile(file, by, 0o644); err != nil {
+		return fmt.Errorf("failed to write plan: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-github/v35/github"
+
+	"istio.io/release-builder/pkg/model"
+	"istio.io/release-builder/pkg/util"
+)
+
+func TestPlan(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	hub := strings.TrimPrefix(server.URL, "http://")
+	t.Setenv("COSIGN_PASSWORD", "secret")
+
+	release := t.TempDir()
+	for file, content := range map[string]string{
+		"istio-1.2.3-linux-amd64.tar.gz": "archive",
+		"sbom/pilot.spdx.json":           `{"spdxVersion":"SPDX-2.3"}`,
+		"logs/build/001-make.log":        "log",
+	} {
+		if err := os.MkdirAll(filepath.Join(release, filepath.Dir(file)), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(release, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(release, "docker"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	digests := map[string]string{}
+	for archive, arch := range map[string]string{"pilot.tar.gz": "amd64", "pilot-arm64.tar.gz": "arm64"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.OS, cfg.Architecture = "linux", arch
+		if img, err = mutate.ConfigFile(img, cfg); err != nil {
+			t.Fatal(err)
+		}
+		ref, err := name.ParseReference("localhost/pilot:1.2.3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tarball.WriteToFile(filepath.Join(release, "docker", archive), ref, img); err != nil {
+			t.Fatal(err)
+		}
+		// Images are pushed as read from the archive, which has its own digest.
+		if img, err = util.ImageFromArchive(filepath.Join(release, "docker", archive)); err != nil {
+			t.Fatal(err)
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[arch] = digest.String()
+	}
+	manifest := model.Manifest{
+		Directory:     release,
+		Version:       "1.2.3",
+		Architectures: []string{"linux/amd64", "linux/arm64"},
+	}
+	dir := t.TempDir()
+
+	old := flags
+	t.Cleanup(func() { flags = old })
+	flags.dockerhub, flags.cosignkey = hub, writeSigningKey(t, "secret")
+	flags.rekorURL, flags.rekorPubKey = newFakeRekor(t)
+	flags.filebucket, flags.filealiases = dir, []string{"latest"}
+
+	plan, err := NewPlan(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[string][]string{}
+	for _, i := range plan.Images {
+		images[i.Kind] = append(images[i.Kind], i.Reference)
+	}
+	if sboms := images["sbom"]; len(sboms) != 1 || !strings.HasPrefix(sboms[0], hub+"/pilot@sha256:") {
+		t.Fatalf("expected an sbom push for the amd64 image, got %v", sboms)
+	}
+	delete(images, "sbom")
+	cosignTag := func(digest, suffix string) string {
+		return hub + "/pilot:" + strings.Replace(digest, ":", "-", 1) + suffix
+	}
+	index := ""
+	for _, i := range plan.Images {
+		if i.Kind == "manifest list" {
+			index = i.Digest
+		}
+	}
+	wantImages := map[string][]string{
+		"manifest list": {hub + "/pilot:1.2.3"},
+		"image":         {hub + "/pilot@" + digests["amd64"], hub + "/pilot@" + digests["arm64"]},
+		// The manifest list and each architecture are signed, and only the image with an SBOM is attested.
+		"signature":   {cosignTag(index, ".sig"), cosignTag(digests["amd64"], ".sig"), cosignTag(digests["arm64"], ".sig")},
+		"attestation": {cosignTag(digests["amd64"], ".att")},
+	}
+	for _, refs := range wantImages {
+		slices.Sort(refs)
+	}
+	for _, refs := range images {
+		slices.Sort(refs)
+	}
+	if !reflect.DeepEqual(images, wantImages) {
+		t.Fatalf("unexpected image pushes:\ngot:  %v\nwant: %v", images, wantImages)
+	}
+
+	// Every release file other than the command logs, along with the image lockfile written by the docker step.
+	bucket := "file://" + dir
+	wantObjects := []PlannedObject{
+		{Bucket: bucket, Key: "1.2.3/docker/pilot-arm64.tar.gz", Source: "docker/pilot-arm64.tar.gz"},
+		{Bucket: bucket, Key: "1.2.3/docker/pilot.tar.gz", Source: "docker/pilot.tar.gz"},
+		{Bucket: bucket, Key: "1.2.3/images.yaml", Source: "images.yaml"},
+		{Bucket: bucket, Key: "1.2.3/istio-1.2.3-linux-amd64.tar.gz", Source: "istio-1.2.3-linux-amd64.tar.gz"},
+		{Bucket: bucket, Key: "1.2.3/sbom/pilot.spdx.json", Source: "sbom/pilot.spdx.json"},
+		{Bucket: bucket, Key: "latest", Content: "1.2.3"},
+	}
+	if !reflect.DeepEqual(plan.Objects, wantObjects) {
+		t.Fatalf("unexpected objects:\ngot:  %+v\nwant: %+v", plan.Objects, wantObjects)
+	}
+
+	// Nothing is published by planning.
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("expected nothing to be written to the bucket, got %v: %v", entries, err)
+	}
+	ref, err := name.ParseReference(hub + "/pilot:1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remote.Head(ref); err == nil {
+		t.Fatal("expected nothing to be pushed")
+	}
+
+	// Once published, there is nothing left to do.
+	if err := Publish(manifest); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = NewPlan(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Objects) != 0 || len(plan.Images) != 0 {
+		t.Fatalf("expected an empty plan after publishing, got %+v", plan)
+	}
+	for _, action := range []string{"push " + hub + "/pilot:1.2.3", "write " + bucket + "/latest", "sign " + hub + "/pilot@" + index} {
+		if !slices.Contains(plan.UpToDate, action) {
+			t.Fatalf("expected %q to be up to date, got %v", action, plan.UpToDate)
+		}
+	}
+}
+
+func TestPlanGithubRelease(t *testing.T) {
+	release := t.TempDir()
+	for _, file := range []string{"istio-1.2.3-linux-amd64.tar.gz", "manifest.yaml"} {
+		if err := os.WriteFile(filepath.Join(release, file), []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected write %v %v", r.Method, r.URL)
+		}
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	plan := &Plan{release: release}
+	activePlan.Lock()
+	activePlan.plan = plan
+	activePlan.Unlock()
+	t.Cleanup(func() {
+		activePlan.Lock()
+		activePlan.plan = nil
+		activePlan.Unlock()
+	})
+	if err := GithubRelease(model.Manifest{Directory: release, Version: "1.2.3"}, client, "istio"); err != nil {
+		t.Fatal(err)
+	}
+	// The release is planned with the same settings it is created with.
+	want := &PlannedRelease{Repository: "istio/istio", Tag: "1.2.3", Name: "Istio 1.2.3", Draft: true, Prerelease: true}
+	if !reflect.DeepEqual(plan.GithubRelease, want) {
+		t.Fatalf("expected release %+v, got %+v", want, plan.GithubRelease)
+	}
+	wantAssets := []PlannedAsset{{Repository: "istio/istio", Tag: "1.2.3", Name: "istio-1.2.3-linux-amd64.tar.gz"}}
+	if !reflect.DeepEqual(plan.GithubAssets, wantAssets) {
+		t.Fatalf("expected assets %+v, got %+v", wantAssets, plan.GithubAssets)
+	}
+}
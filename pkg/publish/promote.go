@@ -0,0 +1,478 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"helm.sh/helm/v4/pkg/chart/v2/loader"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg"
+	"istio.io/release-builder/pkg/model"
+	"istio.io/release-builder/pkg/util"
+)
+
+// promotedImage is an image of a prerelease, along with the digest it was tested with.
+type promotedImage struct {
+	// img is the image in the prerelease hub.
+	img Image
+	// tagArch is the architecture suffix of the tag. This is only set for single architecture images pushed from
+	// docker archives.
+	tagArch string
+	digest  v1.Hash
+	// index is set if the image is a multi-architecture manifest list.
+	index bool
+	// archDigests holds the digest of the image for each architecture.
+	archDigests map[string]v1.Hash
+}
+
+// Promote publishes a prerelease to the destinations of the publish flags, without rebuilding it.
+// from is the folder a release was published to with --gcsbucket, --s3bucket, --azblobbucket or --filebucket, such as
+// s3://istio-prerelease/prerelease/1.2.3, and fromHub is the hub its images were pushed to with --dockerhub.
+// Everything is verified against the manifest and checksums of the release before anything is published. Images are
+// then copied by digest, and objects are copied server-side when possible, so the bits that were tested are the bits
+// that ship.
+func Promote(from string, fromHub string) error {
+	defer reportSkipped()
+	ctx := context.Background()
+	src, prefix, err := NewObjectStore(ctx, from)
+	if err != nil {
+		return err
+	}
+	objects, err := prereleaseObjects(ctx, src, prefix)
+	if err != nil {
+		return err
+	}
+
+	// Only small files are downloaded, as they are needed locally: the manifest, image lockfile, helm charts, and SBOMs.
+	dir, err := os.MkdirTemp("", "promote-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	for rel, key := range objects {
+		if rel == "manifest.yaml" || rel == imageLockfile || strings.HasPrefix(rel, "helm/") || strings.HasPrefix(rel, "sbom/") {
+			if err := downloadObject(ctx, src, key, filepath.Join(dir, rel)); err != nil {
+				return err
+			}
+		}
+	}
+	manifest, err := pkg.ReadManifest(filepath.Join(dir, "manifest.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest from prerelease: %v", err)
+	}
+	manifest.Directory = dir
+	util.YamlLog("Manifest", manifest)
+
+	// Verify everything up front, so a bad prerelease publishes nothing.
+	if err := verifyChecksums(ctx, src, objects); err != nil {
+		return err
+	}
+	if err := verifyCharts(manifest); err != nil {
+		return err
+	}
+	var images []promotedImage
+	if fromHub != "" {
+		if images, err = prereleaseImages(ctx, manifest, src, objects, fromHub); err != nil {
+			return err
+		}
+	}
+	log.Infof("Verified prerelease %v of %v", from, manifest.Version)
+
+	// The prerelease image lockfile pins the prerelease hub. When images are promoted, it is replaced with one for
+	// the promoted images.
+	lockfile := ""
+	if flags.dockerhub != "" {
+		lockfile = filepath.Join(dir, imageLockfile)
+		if err := promoteImages(manifest, images, flags.dockerhub, flags.dockertags, signingFlags(), lockfile); err != nil {
+			return fmt.Errorf("failed to promote images: %v", err)
+		}
+	}
+	targets, err := archiveTargets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if err := promoteObjects(ctx, manifest, src, objects, t, lockfile); err != nil {
+			return fmt.Errorf("failed to promote to %v: %v", t.store.URL(), err)
+		}
+	}
+	repos, err := helmRepositories()
+	if err != nil {
+		return err
+	}
+	if len(repos) > 0 || flags.helmhub != "" {
+		if err := Helm(manifest, repos, flags.helmhub); err != nil {
+			return fmt.Errorf("failed to publish to helm charts: %v", err)
+		}
+	}
+	return nil
+}
+
+// prereleaseObjects returns the key of every object of the release under prefix, by its path in the release.
+// Command logs, which older publishes uploaded along with the release, are not part of it.
+func prereleaseObjects(ctx context.Context, src ObjectStore, prefix string) (map[string]string, error) {
+	if prefix != "" {
+		prefix += "/"
+	}
+	keys, err := src.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	objects := map[string]string{}
+	for _, key := range keys {
+		rel := strings.TrimPrefix(key, prefix)
+		if strings.HasPrefix(rel, commandLogsDir+"/") {
+			continue
+		}
+		objects[rel] = key
+	}
+	if _, f := objects["manifest.yaml"]; !f {
+		return nil, fmt.Errorf("no release found at %s/%s: missing manifest.yaml", src.URL(), prefix)
+	}
+	return objects, nil
+}
+
+func downloadObject(ctx context.Context, src ObjectStore, key string, file string) error {
+	content, _, err := src.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read %s/%s: %v", src.URL(), key, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0o644)
+}
+
+// verifyChecksums checks every file with a .sha256 checksum in the release matches it.
+func verifyChecksums(ctx context.Context, src ObjectStore, objects map[string]string) error {
+	for rel, key := range objects {
+		file, f := strings.CutSuffix(rel, ".sha256")
+		if !f {
+			continue
+		}
+		fileKey, f := objects[file]
+		if !f {
+			return fmt.Errorf("checksum %v has no matching file", rel)
+		}
+		checksum, _, err := src.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %v", key, err)
+		}
+		want, _, _ := strings.Cut(strings.TrimSpace(string(checksum)), " ")
+		content, _, err := src.Get(ctx, fileKey)
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %v", fileKey, err)
+		}
+		if got := fmt.Sprintf("%x", sha256.Sum256(content)); got != want {
+			return fmt.Errorf("checksum mismatch for %v: expected %v, got %v", file, want, got)
+		}
+		log.Infof("Verified checksum of %v", file)
+	}
+	return nil
+}
+
+// verifyCharts checks every helm chart is packaged for the version of the release.
+func verifyCharts(manifest model.Manifest) error {
+	if !util.FileExists(filepath.Join(manifest.Directory, "helm")) {
+		return nil
+	}
+	charts, err := helmCharts(manifest)
+	if err != nil {
+		return err
+	}
+	for _, c := range charts {
+		chart, err := loader.LoadFile(filepath.Join(manifest.Directory, "helm", c))
+		if err != nil {
+			return fmt.Errorf("failed to load chart %v: %v", c, err)
+		}
+		if chart.Metadata.Version != manifest.Version {
+			return fmt.Errorf("chart %v has version %v, expected %v", c, chart.Metadata.Version, manifest.Version)
+		}
+	}
+	return nil
+}
+
+// prereleaseImages resolves every image of the release in the prerelease hub, checking it has all architectures of
+// the release.
+func prereleaseImages(ctx context.Context, manifest model.Manifest, src ObjectStore, objects map[string]string, hub string,
+) ([]promotedImage, error) {
+	images := []promotedImage{}
+	if manifest.DockerOutput == model.DockerOutputOCI {
+		for rel, key := range objects {
+			dir, f := strings.CutSuffix(rel, "/index.json")
+			if !f || path.Dir(dir) != "docker" {
+				continue
+			}
+			imageName := path.Base(dir)
+			content, _, err := src.Get(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read index of %v: %v", imageName, err)
+			}
+			im, err := v1.ParseIndexManifest(bytes.NewReader(content))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse index of %v: %v", imageName, err)
+			}
+			for _, desc := range im.Manifests {
+				images = append(images, promotedImage{img: Image{
+					NewTag:  fmt.Sprintf("%s/%s:%s", hub, imageName, manifest.Version),
+					Variant: desc.Annotations[util.ImageVariantAnnotation],
+					Image:   imageName,
+				}})
+			}
+		}
+	} else {
+		archives := []string{}
+		for rel := range objects {
+			if path.Dir(rel) == "docker" {
+				archives = append(archives, path.Base(rel))
+			}
+		}
+		index, err := indexArchives(manifest, archives, hub, []string{manifest.Version})
+		if err != nil {
+			return nil, err
+		}
+		for img, archs := range index {
+			p := promotedImage{img: img}
+			if len(archs) == 1 {
+				p.tagArch = archs[0]
+			}
+			images = append(images, p)
+		}
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images found in the release")
+	}
+	slices.SortFunc(images, func(a, b promotedImage) int {
+		return strings.Compare(a.img.NewReference(a.tagArch), b.img.NewReference(b.tagArch))
+	})
+	// Images published with an image lockfile must still be at the digests it pins.
+	pinned := map[string]string{}
+	if key, f := objects[imageLockfile]; f {
+		content, _, err := src.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image lockfile: %v", err)
+		}
+		lockfile, err := readImageLock(content)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lockfile.Images {
+			pinned[l.Reference] = l.Digest
+		}
+	}
+	for i := range images {
+		p := &images[i]
+		if err := resolvePrereleaseImage(manifest, p); err != nil {
+			return nil, err
+		}
+		ref := p.img.NewReference(p.tagArch)
+		if digest, f := pinned[ref]; f && digest != p.digest.String() {
+			return nil, fmt.Errorf("image %v is at %v, but the image lockfile of the prerelease pins %v", ref, p.digest, digest)
+		}
+	}
+	return images, nil
+}
+
+// resolvePrereleaseImage records the digest the image currently has in the prerelease hub, so later changes to the
+// tag are not promoted.
+func resolvePrereleaseImage(manifest model.Manifest, p *promotedImage) error {
+	ref, err := name.ParseReference(p.img.NewReference(p.tagArch))
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", p.img.NewReference(p.tagArch), err)
+	}
+	desc, err := remoteDescriptor(ref)
+	if err != nil {
+		return err
+	}
+	if desc == nil {
+		return fmt.Errorf("image %v was not found in the prerelease", ref)
+	}
+	digestRef := ref.Context().Digest(desc.Digest.String())
+	p.digest = desc.Digest
+	p.archDigests = map[string]v1.Hash{}
+	if desc.MediaType.IsIndex() {
+		p.index = true
+		idx, err := remote.Index(digestRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			return fmt.Errorf("failed to get index %v: %v", digestRef, err)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return fmt.Errorf("failed to read index %v: %v", digestRef, err)
+		}
+		for _, m := range im.Manifests {
+			if m.Platform != nil {
+				p.archDigests[m.Platform.Architecture] = m.Digest
+			}
+		}
+	} else {
+		img, err := remote.Image(digestRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			return fmt.Errorf("failed to get image %v: %v", digestRef, err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return fmt.Errorf("failed to read config of %v: %v", digestRef, err)
+		}
+		p.archDigests[cfg.Architecture] = desc.Digest
+	}
+	for _, plat := range manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		if _, f := p.archDigests[arch]; !f {
+			return fmt.Errorf("image %v is missing architecture %v", digestRef, arch)
+		}
+	}
+	log.Infof("Verified image %v", digestRef)
+	return nil
+}
+
+// promoteImages copies the prerelease images, by digest, to the given hub and tags, then writes their image lockfile.
+func promoteImages(manifest model.Manifest, images []promotedImage, hub string, tags []string, signing signingOptions, lockfile string) error {
+	if len(tags) == 0 {
+		tags = []string{manifest.Version}
+	}
+	signer, err := newImageSigner(context.Background(), signing)
+	if err != nil {
+		return err
+	}
+	sboms := newSbomAttacher(manifest, signer)
+	lock := newImageLock(manifest.Version)
+	for _, p := range images {
+		srcRef, err := name.ParseReference(p.img.NewReference(p.tagArch))
+		if err != nil {
+			return err
+		}
+		srcDigest := srcRef.Context().Digest(p.digest.String())
+		for _, tag := range tags {
+			img := Image{
+				NewTag:  fmt.Sprintf("%s/%s:%s", hub, p.img.Image, tag),
+				Variant: p.img.Variant,
+				Image:   p.img.Image,
+			}
+			ref, err := name.ParseReference(img.NewReference(p.tagArch))
+			if err != nil {
+				return fmt.Errorf("failed to parse %v: %v", img.NewReference(p.tagArch), err)
+			}
+			if err := copyImage(srcDigest, ref, p.index); err != nil {
+				return err
+			}
+			digestRef := ref.Context().String() + "@" + p.digest.String()
+			archDigests := map[string]string{}
+			for arch, archDigest := range p.archDigests {
+				archDigests[arch] = ref.Context().String() + "@" + archDigest.String()
+			}
+			if signer != nil {
+				if err := signer.sign(digestRef, archDigests); err != nil {
+					return err
+				}
+			}
+			for arch, archDigest := range archDigests {
+				if err := sboms.attach(img, arch, archDigest); err != nil {
+					return err
+				}
+			}
+			if err := lock.add(img, img.NewReference(p.tagArch), digestRef, p.index, archDigests); err != nil {
+				return err
+			}
+		}
+	}
+	if signer != nil {
+		if err := signer.verify(); err != nil {
+			return err
+		}
+	}
+	return lock.write(lockfile)
+}
+
+// copyImage copies an image or index, by digest, to ref. Blobs are mounted rather than copied within a registry.
+func copyImage(src name.Digest, ref name.Reference, index bool) error {
+	digest, err := v1.NewHash(src.DigestStr())
+	if err != nil {
+		return err
+	}
+	if pushed, err := remoteHasDigest(ref, digest); err != nil {
+		return err
+	} else if pushed {
+		skipUpToDate("copy %v to %v", src, ref)
+		return nil
+	}
+	if index {
+		idx, err := remote.Index(src, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			return fmt.Errorf("failed to get index %v: %v", src, err)
+		}
+		err = remote.WriteIndex(ref, idx, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			return fmt.Errorf("failed to copy %v to %v: %v", src, ref, err)
+		}
+	} else {
+		img, err := remote.Image(src, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			return fmt.Errorf("failed to get image %v: %v", src, err)
+		}
+		if err := remote.Write(ref, img, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+			return fmt.Errorf("failed to copy %v to %v: %v", src, ref, err)
+		}
+	}
+	log.Infof("copied %v to %v", src, ref)
+	return nil
+}
+
+// promoteObjects copies every object of the prerelease to the target bucket, then points the aliases to the version.
+// If set, lockfile replaces the image lockfile of the prerelease.
+func promoteObjects(ctx context.Context, manifest model.Manifest, src ObjectStore, objects map[string]string, t archiveTarget,
+	lockfile string,
+) error {
+	copies := []releaseObject{}
+	for rel, key := range objects {
+		if rel == imageLockfile && lockfile != "" {
+			continue
+		}
+		// The file of each object is its key in the prerelease.
+		copies = append(copies, releaseObject{file: key, key: path.Join(t.prefix, manifest.Version, rel)})
+	}
+	slices.SortFunc(copies, func(a, b releaseObject) int {
+		return strings.Compare(a.key, b.key)
+	})
+	if err := uploadObjects(ctx, t.store.URL(), copies, func(ctx context.Context, o releaseObject) (PutResult, error) {
+		return CopyObject(ctx, src, o.file, t.store, o.key)
+	}); err != nil {
+		return err
+	}
+	if lockfile != "" {
+		key := path.Join(t.prefix, manifest.Version, imageLockfile)
+		if err := withRetry(ctx, key, func() error {
+			_, err := t.store.Put(ctx, key, lockfile)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return publishAliases(ctx, t.store, t.prefix, manifest.Version, t.aliases)
+}
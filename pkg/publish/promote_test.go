@@ -0,0 +1,81 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"istio.io/release-builder/pkg/util"
+)
+
+func TestPromote(t *testing.T) {
+	prerelease := t.TempDir()
+	archive := filepath.Join(prerelease, "istio-1.2.3-linux-amd64.tar.gz")
+	if err := os.WriteFile(filepath.Join(prerelease, "manifest.yaml"), []byte("version: 1.2.3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive, []byte("archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.CreateSha(archive); err != nil {
+		t.Fatal(err)
+	}
+	// Prereleases published before logs were excluded may include them.
+	if err := os.MkdirAll(filepath.Join(prerelease, "logs", "build"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(prerelease, "logs", "build", "001-make.log"), []byte("log"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	oldBucket, oldAliases := flags.filebucket, flags.filealiases
+	t.Cleanup(func() { flags.filebucket, flags.filealiases = oldBucket, oldAliases })
+	flags.filebucket, flags.filealiases = dir, []string{"latest"}
+
+	if err := Promote("file://"+prerelease, ""); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{
+		"1.2.3/istio-1.2.3-linux-amd64.tar.gz": "archive",
+		"1.2.3/manifest.yaml":                  "version: 1.2.3\n",
+		"latest":                               "1.2.3",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("expected %v to be %q, got %q", file, want, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.2.3", "logs")); !os.IsNotExist(err) {
+		t.Fatalf("expected logs not to be promoted: %v", err)
+	}
+
+	// Nothing is promoted if the prerelease does not match its checksums.
+	if err := os.WriteFile(archive, []byte("modified"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Promote("file://"+prerelease, ""); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "1.2.3/istio-1.2.3-linux-amd64.tar.gz")); string(got) != "archive" {
+		t.Fatalf("expected archive to be unchanged, got %q", got)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sigstore/cosign/v2/pkg/cosign/bundle"
+	rekorclient "github.com/sigstore/rekor/pkg/client"
+	"github.com/sigstore/rekor/pkg/generated/client"
+	"github.com/sigstore/rekor/pkg/generated/client/entries"
+	"github.com/sigstore/rekor/pkg/generated/models"
+	"github.com/sigstore/rekor/pkg/types"
+	// Register the entry kinds cosign records signatures and attestations as.
+	_ "github.com/sigstore/rekor/pkg/types/hashedrekord/v0.0.1"
+	_ "github.com/sigstore/rekor/pkg/types/intoto/v0.0.1"
+	rekorverify "github.com/sigstore/rekor/pkg/verify"
+	"github.com/sigstore/sigstore-go/pkg/root"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// publicRekorURL is the public Rekor instance, which `cosign verify` checks by default.
+const publicRekorURL = "https://rekor.sigstore.dev"
+
+// cosignBundleAnnotation holds the Rekor bundle of a signature or attestation layer.
+const cosignBundleAnnotation = "dev.sigstore.cosign/bundle"
+
+// rekorClient records signatures in a Rekor transparency log, like `cosign sign` does, and checks their entries.
+type rekorClient struct {
+	url    string
+	client *client.Rekor
+	// verifier checks the signed entry timestamps of the log.
+	verifier signature.Verifier
+	// logID is the ID of the log, the hex SHA256 of its public key.
+	logID string
+}
+
+// newRekorClient returns a client for the log at url. The public key of the log is read from publicKeyFile if set,
+// and otherwise taken from the sigstore trusted root, which is distributed with TUF. The key is never fetched from
+// the log itself, as that would trust whichever server answers at url.
+func newRekorClient(url string, publicKeyFile string) (*rekorClient, error) {
+	url = strings.TrimSuffix(url, "/")
+	var pub crypto.PublicKey
+	if publicKeyFile != "" {
+		pemKey, err := os.ReadFile(publicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key of rekor %v: %v", url, err)
+		}
+		if pub, err = cryptoutils.UnmarshalPEMToPublicKey(pemKey); err != nil {
+			return nil, fmt.Errorf("invalid public key of rekor %v: %v", url, err)
+		}
+	} else {
+		var err error
+		if pub, err = trustedRekorKey(url); err != nil {
+			return nil, err
+		}
+	}
+	der, err := cryptoutils.MarshalPublicKeyToDER(pub)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := signature.LoadVerifier(pub, crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key of rekor %v: %v", url, err)
+	}
+	c, err := rekorclient.GetRekorClient(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rekor client for %v: %v", url, err)
+	}
+	sum := sha256.Sum256(der)
+	return &rekorClient{url: url, client: c, verifier: verifier, logID: hex.EncodeToString(sum[:])}, nil
+}
+
+// trustedRekorKey returns the current public key of the log at url from the sigstore trusted root. Only the public
+// sigstore logs are in the trusted root; other logs need their key pinned with --rekor-public-key.
+func trustedRekorKey(url string) (crypto.PublicKey, error) {
+	trustedRoot, err := root.FetchTrustedRoot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the sigstore trusted root: %v", err)
+	}
+	now := time.Now()
+	for _, l := range trustedRoot.RekorLogs() {
+		if strings.TrimSuffix(l.BaseURL, "/") != url || now.Before(l.ValidityPeriodStart) {
+			continue
+		}
+		if l.ValidityPeriodEnd.IsZero() || now.Before(l.ValidityPeriodEnd) {
+			return l.PublicKey, nil
+		}
+	}
+	return nil, fmt.Errorf("rekor %v is not in the sigstore trusted root, its key must be set with --rekor-public-key", url)
+}
+
+// hashedRekordEntry is the log entry of a signature over a payload, as uploaded by `cosign sign`.
+func hashedRekordEntry(ctx context.Context, payload, sig, publicKey []byte) (models.ProposedEntry, error) {
+	sum := sha256.Sum256(payload)
+	return types.NewProposedEntry(ctx, "hashedrekord", "0.0.1", types.ArtifactProperties{
+		ArtifactHash:   "sha256:" + hex.EncodeToString(sum[:]),
+		SignatureBytes: sig,
+		PublicKeyBytes: [][]byte{publicKey},
+		PKIFormat:      "x509",
+	})
+}
+
+// intotoEntry is the log entry of a DSSE envelope, as uploaded by `cosign attest`.
+func intotoEntry(ctx context.Context, envelope, publicKey []byte) (models.ProposedEntry, error) {
+	return types.NewProposedEntry(ctx, "intoto", "0.0.1", types.ArtifactProperties{
+		ArtifactBytes:  envelope,
+		PublicKeyBytes: [][]byte{publicKey},
+	})
+}
+
+// upload adds an entry to the log, returning its bundle. An entry that is already in the log is returned as is.
+func (r *rekorClient) upload(ctx context.Context, entry models.ProposedEntry) (*bundle.RekorBundle, error) {
+	var logEntry models.LogEntry
+	resp, err := r.client.Entries.CreateLogEntry(entries.NewCreateLogEntryParamsWithContext(ctx).WithProposedEntry(entry))
+	if err == nil {
+		logEntry = resp.Payload
+	} else {
+		var conflict *entries.CreateLogEntryConflict
+		if !errors.As(err, &conflict) {
+			return nil, fmt.Errorf("failed to upload to rekor %v: %v", r.url, err)
+		}
+		// The location of the existing entry is returned on conflicts.
+		uuid := path.Base(conflict.Location.String())
+		existing, err := r.client.Entries.GetLogEntryByUUID(entries.NewGetLogEntryByUUIDParamsWithContext(ctx).WithEntryUUID(uuid))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get existing rekor entry %v: %v", uuid, err)
+		}
+		logEntry = existing.Payload
+	}
+	for _, e := range logEntry {
+		b := bundle.EntryToBundle(&e)
+		if b == nil {
+			return nil, fmt.Errorf("rekor entry has no signed entry timestamp")
+		}
+		if err := r.verify(ctx, b, entry); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("invalid rekor response: no entry")
+}
+
+// verify checks that a bundle is the log entry of entry, with a valid signed entry timestamp from this log.
+func (r *rekorClient) verify(ctx context.Context, b *bundle.RekorBundle, entry models.ProposedEntry) error {
+	if b.Payload.LogID != r.logID {
+		return fmt.Errorf("rekor entry is from log %v, expected %v", b.Payload.LogID, r.logID)
+	}
+	if err := rekorverify.VerifySignedEntryTimestamp(ctx, &models.LogEntryAnon{
+		Body:           b.Payload.Body,
+		IntegratedTime: &b.Payload.IntegratedTime,
+		LogIndex:       &b.Payload.LogIndex,
+		LogID:          &b.Payload.LogID,
+		Verification:   &models.LogEntryAnonVerification{SignedEntryTimestamp: b.SignedEntryTimestamp},
+	}, r.verifier); err != nil {
+		return fmt.Errorf("invalid signed entry timestamp of rekor entry %v: %v", b.Payload.LogIndex, err)
+	}
+	// The log stores the canonical form of each entry, so compare against the canonical form of the expected one.
+	impl, err := types.UnmarshalEntry(entry)
+	if err != nil {
+		return fmt.Errorf("invalid rekor entry: %v", err)
+	}
+	want, err := types.CanonicalizeEntry(ctx, impl)
+	if err != nil {
+		return fmt.Errorf("invalid rekor entry: %v", err)
+	}
+	body, ok := b.Payload.Body.(string)
+	if !ok {
+		return fmt.Errorf("invalid rekor entry body")
+	}
+	got, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return fmt.Errorf("invalid rekor entry body: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("rekor entry %v is for another signature", b.Payload.LogIndex)
+	}
+	return nil
+}
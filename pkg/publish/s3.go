@@ -15,15 +15,21 @@
 package publish
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net/url"
 	"os"
-	"path"
-	"path/filepath"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go/ptr"
 
 	"istio.io/istio/pkg/log"
@@ -53,145 +59,282 @@ func NewS3Client() (*s3.Client, error) {
 	return s3.NewFromConfig(cfg), nil
 }
 
-func ArchiveS3(manifest model.Manifest, bucket string, aliases []string) error {
-	ctx := context.Background()
+// s3Store is an ObjectStore backed by an S3 compatible bucket.
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+var (
+	_ ObjectStore  = &s3Store{}
+	_ objectCopier = &s3Store{}
+)
+
+// NewS3Store returns a store for the given S3 bucket.
+func NewS3Store(bucketName string) (ObjectStore, error) {
 	client, err := NewS3Client()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &s3Store{client: client, bucket: bucketName}, nil
+}
 
-	splitbucket := strings.SplitN(bucket, "/", 2)
-	bucketName := splitbucket[0]
-	objectPrefix := ""
-	if len(splitbucket) > 1 {
-		objectPrefix = splitbucket[1]
+// ArchiveS3 publishes the final release archive to the given S3 bucket
+func ArchiveS3(manifest model.Manifest, bucket string, aliases []string) error {
+	bucketName, objectPrefix := splitBucket(bucket)
+	store, err := NewS3Store(bucketName)
+	if err != nil {
+		return err
 	}
+	return PublishArchive(manifest, store, objectPrefix, aliases)
+}
 
-	if err := filepath.Walk(manifest.Directory, func(p string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		objName := path.Join(objectPrefix, manifest.Version, strings.TrimPrefix(p, manifest.Directory))
-		f, err := os.Open(p)
-		if err != nil {
-			return fmt.Errorf("failed to open %v: %v", p, err)
+func (s *s3Store) URL() string {
+	return "s3://" + s.bucket
+}
+
+// s3PartSize is the size of each part of a multipart upload. Files up to this size are uploaded in a single request.
+const s3PartSize = 64 << 20
+
+// s3MD5Metadata is the object metadata holding the MD5 of the contents. The ETag of a multipart upload is not the
+// MD5 of the contents, so this is used to compare objects instead.
+const s3MD5Metadata = "md5"
+
+// Put writes a file to an object, unless the object already has the same contents. Large files are uploaded in parts.
+func (s *s3Store) Put(ctx context.Context, key string, file string) (PutResult, error) {
+	sum, err := fileMD5(file)
+	if err != nil {
+		return PutResult{}, err
+	}
+	if same, err := s3ObjectMatches(ctx, s.client, s.bucket, key, sum); err != nil {
+		return PutResult{}, err
+	} else if same {
+		skipUpToDate("write %s/%s", s.URL(), key)
+		return PutResult{UpToDate: true}, nil
+	}
+	if planObject(s, key, PlannedObject{Source: file}) {
+		return PutResult{}, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to open %v: %v", file, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return PutResult{}, err
+	}
+	metadata := map[string]string{s3MD5Metadata: hex.EncodeToString(sum)}
+	if info.Size() > s3PartSize {
+		if err := s.putMultipart(ctx, key, f, info.Size(), metadata); err != nil {
+			return PutResult{}, err
 		}
-		defer f.Close()
-		_, err = client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: ptr.String(bucketName),
-			Key:    ptr.String(objName),
-			Body:   f,
+	} else {
+		_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:     ptr.String(s.bucket),
+			Key:        ptr.String(key),
+			Body:       f,
+			ContentMD5: ptr.String(base64.StdEncoding.EncodeToString(sum)),
+			Metadata:   metadata,
 		})
 		if err != nil {
-			return fmt.Errorf("failed to put object %v: %v", objName, err)
+			return PutResult{}, fmt.Errorf("failed to put object %v: %v", key, err)
 		}
-		log.Infof("Wrote %v to r2://%s/%s", p, bucketName, objName)
-		return nil
-	}); err != nil {
-		return fmt.Errorf("failed to walk directory: %v", err)
 	}
+	log.Infof("Wrote %v to %s/%s", file, s.URL(), key)
+	return PutResult{Bytes: info.Size()}, nil
+}
 
-	// Add alias objects that contain the version string, pointing to the latest version
-	for _, alias := range aliases {
-		aliasKey := path.Join(objectPrefix, alias)
-		_, err := client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket:      ptr.String(bucketName),
-			Key:         ptr.String(aliasKey),
-			Body:        strings.NewReader(manifest.Version),
-			ContentType: ptr.String("text/plain"),
-		})
-		if err != nil {
-			return fmt.Errorf("failed to write alias %v: %v", alias, err)
+// putMultipart uploads a file in parts of s3PartSize, retrying each part on failure. A failed upload is aborted,
+// so the parts do not linger in the bucket.
+func (s *s3Store) putMultipart(ctx context.Context, key string, f *os.File, size int64, metadata map[string]string) (err error) {
+	upload, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   ptr.String(s.bucket),
+		Key:      ptr.String(key),
+		Metadata: metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload for %v: %v", key, err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Use a new context, as the upload context may be cancelled.
+		if _, aerr := s.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   ptr.String(s.bucket),
+			Key:      ptr.String(key),
+			UploadId: upload.UploadId,
+		}); aerr != nil {
+			log.Warnf("failed to abort multipart upload for %v: %v", key, aerr)
 		}
-		log.Infof("Wrote alias %v to r2://%s/%s", alias, bucketName, aliasKey)
+	}()
+
+	parts := []types.CompletedPart{}
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+s3PartSize, number+1 {
+		partSize := min(s3PartSize, size-offset)
+		partName := fmt.Sprintf("%v part %d", key, number)
+		var part *s3.UploadPartOutput
+		if err := withRetry(ctx, partName, func() error {
+			var err error
+			part, err = s.client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:        ptr.String(s.bucket),
+				Key:           ptr.String(key),
+				UploadId:      upload.UploadId,
+				PartNumber:    ptr.Int32(number),
+				Body:          io.NewSectionReader(f, offset, partSize),
+				ContentLength: ptr.Int64(partSize),
+			})
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to upload %v: %v", partName, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: part.ETag, PartNumber: ptr.Int32(number)})
 	}
 
+	if _, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          ptr.String(s.bucket),
+		Key:             ptr.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return fmt.Errorf("failed to complete multipart upload for %v: %v", key, err)
+	}
 	return nil
 }
 
-// MutateObjectS3 pulls a file from S3, mutates it, then pushes it back up.
-// It uses ETag-based conditional writes to retry if the object was modified concurrently.
-func MutateObjectS3(outDir string, client *s3.Client, bkt *string, objectPrefix string, filename string, f func() error) error {
-	for i := 0; i < 10; i++ {
-		err := mutateObjectS3Inner(outDir, client, bkt, objectPrefix, filename, f)
-		if err == ErrIndexOutOfDate {
-			log.Warnf("Write conflict, trying again")
-			continue
-		}
-		return err
+// CopyFrom copies an object from another bucket, without downloading it. Both buckets must be reachable with the
+// same endpoint and credentials. Objects over 5GiB cannot be copied in a single request, which releases do not have.
+func (s *s3Store) CopyFrom(ctx context.Context, src ObjectStore, srcKey string, key string) (PutResult, error) {
+	from, ok := src.(*s3Store)
+	if !ok {
+		return PutResult{}, errCopyUnsupported
 	}
-	return fmt.Errorf("max conflicts attempted")
-}
-
-func mutateObjectS3Inner(outDir string, client *s3.Client, bkt *string, objectPrefix string, filename string, f func() error) error {
-	objName := filepath.Join(objectPrefix, filename)
-	outFile := filepath.Join(outDir, filename)
-	ctx := context.Background()
-
-	// Track the ETag for conditional writes
-	var etag *string
-
-	obj, err := client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: bkt,
-		Key:    ptr.String(objName),
+	head, err := from.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: ptr.String(from.bucket),
+		Key:    ptr.String(srcKey),
 	})
 	if err != nil {
-		if !strings.Contains(err.Error(), "NoSuchKey") {
-			return fmt.Errorf("failed to fetch object %s: %v", objName, err)
-		}
-		log.Warnf("existing file %v does not exist", filename)
-	} else {
-		etag = obj.ETag
-		log.Infof("Object %v currently has ETag %s", objName, *etag)
-
-		idx, err := os.Create(outFile)
-		if err != nil {
-			return fmt.Errorf("failed to create %v: %v", outFile, err)
+		return PutResult{}, fmt.Errorf("failed to head object %v: %v", srcKey, err)
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:     ptr.String(s.bucket),
+		Key:        ptr.String(key),
+		CopySource: ptr.String((&url.URL{Path: from.bucket + "/" + srcKey}).EscapedPath()),
+	}
+	md5, f := head.Metadata[s3MD5Metadata]
+	if !f {
+		md5 = strings.Trim(ptr.ToString(head.ETag), `"`)
+	}
+	// The ETag of a multipart upload is not an MD5, in which case the object is always copied.
+	if sum, err := hex.DecodeString(md5); err == nil && len(sum) == 16 {
+		if same, err := s3ObjectMatches(ctx, s.client, s.bucket, key, sum); err != nil {
+			return PutResult{}, err
+		} else if same {
+			skipUpToDate("copy %s/%s to %s/%s", from.URL(), srcKey, s.URL(), key)
+			return PutResult{UpToDate: true}, nil
 		}
-		if _, err := idx.ReadFrom(obj.Body); err != nil {
-			idx.Close()
-			obj.Body.Close()
-			return fmt.Errorf("failed to read object body: %v", err)
+		// Replacing the metadata drops everything not set on the copy, so carry over the source headers as well.
+		input.MetadataDirective = types.MetadataDirectiveReplace
+		input.Metadata = maps.Clone(head.Metadata)
+		if input.Metadata == nil {
+			input.Metadata = map[string]string{}
 		}
-		idx.Close()
-		obj.Body.Close()
-		log.Infof("Wrote %v", outFile)
+		input.Metadata[s3MD5Metadata] = md5
+		input.ContentType = head.ContentType
+		input.CacheControl = head.CacheControl
+		input.ContentEncoding = head.ContentEncoding
+		input.ContentDisposition = head.ContentDisposition
+		input.ContentLanguage = head.ContentLanguage
 	}
-
-	// Run our action
-	if err := f(); err != nil {
-		return fmt.Errorf("action failed: %v", err)
+	if planObject(s, key, PlannedObject{Source: from.URL() + "/" + srcKey}) {
+		return PutResult{}, nil
 	}
+	if _, err := s.client.CopyObject(ctx, input); err != nil {
+		return PutResult{}, fmt.Errorf("failed to copy %s/%s to %v: %v", from.URL(), srcKey, key, err)
+	}
+	log.Infof("Copied %s/%s to %s/%s", from.URL(), srcKey, s.URL(), key)
+	return PutResult{Bytes: ptr.ToInt64(head.ContentLength)}, nil
+}
 
-	// Now write it back with a conditional put
-	idx, err := os.Open(outFile)
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, string, error) {
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: ptr.String(s.bucket),
+		Key:    ptr.String(key),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open %v: %v", outFile, err)
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) || strings.Contains(err.Error(), "NoSuchKey") {
+			return nil, "", ErrObjectNotExist
+		}
+		return nil, "", err
 	}
-	defer idx.Close()
+	defer obj.Body.Close()
+	content, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read object body: %v", err)
+	}
+	return content, ptr.ToString(obj.ETag), nil
+}
 
-	putInput := &s3.PutObjectInput{
-		Bucket:       bkt,
-		Key:          ptr.String(objName),
-		Body:         idx,
-		ContentType:  ptr.String("text/yaml"),
-		CacheControl: ptr.String("no-cache, max-age=0, no-transform"),
+// PutIfGeneration writes an object using the ETag as its generation, with If-Match, or If-None-Match for new objects.
+func (s *s3Store) PutIfGeneration(ctx context.Context, key string, content []byte, opts ObjectOptions, generation string) error {
+	if planObject(s, key, PlannedObject{Content: string(content)}) {
+		return nil
 	}
-	// If the object existed, use If-Match to ensure it hasn't been modified since we read it.
-	if etag != nil {
-		putInput.IfMatch = etag
+	input := &s3.PutObjectInput{
+		Bucket: ptr.String(s.bucket),
+		Key:    ptr.String(key),
+		Body:   bytes.NewReader(content),
 	}
-
-	_, err = client.PutObject(ctx, putInput)
-	if err != nil {
+	if opts.ContentType != "" {
+		input.ContentType = ptr.String(opts.ContentType)
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = ptr.String(opts.CacheControl)
+	}
+	if generation == "" {
+		input.IfNoneMatch = ptr.String("*")
+	} else {
+		input.IfMatch = ptr.String(generation)
+	}
+	if _, err := s.client.PutObject(ctx, input); err != nil {
 		// A 412 Precondition Failed means someone else wrote to the object since we read it.
 		if strings.Contains(err.Error(), "PreconditionFailed") || strings.Contains(err.Error(), "412") {
 			return ErrIndexOutOfDate
 		}
-		return fmt.Errorf("failed to write object %s: %v", objName, err)
+		return fmt.Errorf("failed to write object %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: ptr.String(s.bucket),
+		Prefix: ptr.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %v: %v", prefix, err)
+		}
+		for _, o := range page.Contents {
+			keys = append(keys, ptr.ToString(o.Key))
+		}
+	}
+	return keys, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	if planObject(s, key, PlannedObject{Delete: true}) {
+		return nil
+	}
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: ptr.String(s.bucket),
+		Key:    ptr.String(key),
+	}); err != nil {
+		return fmt.Errorf("failed to delete %v: %v", key, err)
 	}
 	return nil
 }
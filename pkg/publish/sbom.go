@@ -0,0 +1,119 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/release-builder/pkg/model"
+	"istio.io/release-builder/pkg/util"
+)
+
+const (
+	// spdxMediaType is the artifact type of SBOMs attached to images.
+	spdxMediaType types.MediaType = "application/spdx+json"
+	// spdxPredicateType is the in-toto predicate type of SBOM attestations, as used by `cosign attest --type spdxjson`.
+	spdxPredicateType = "https://spdx.dev/Document"
+)
+
+// sbomAttacher attaches the per-image SBOMs generated by the build to pushed images. Each SBOM is pushed as an OCI
+// referrer of the image digest, and additionally as a cosign attestation, signed with signer, when signing is enabled.
+type sbomAttacher struct {
+	manifest model.Manifest
+	signer   *imageSigner
+	// done holds the digests that already have an SBOM attached, as the same image is pushed once per tag.
+	done map[string]struct{}
+}
+
+func newSbomAttacher(manifest model.Manifest, signer *imageSigner) *sbomAttacher {
+	return &sbomAttacher{
+		manifest: manifest,
+		signer:   signer,
+		done:     map[string]struct{}{},
+	}
+}
+
+// attach attaches the SBOM of a single architecture of img to digestRef, such as `gcr.io/istio-testing/pilot@sha256:1234`.
+// Releases built without an SBOM for the image are skipped.
+func (s *sbomAttacher) attach(img Image, arch string, digestRef string) error {
+	if _, f := s.done[digestRef]; f {
+		return nil
+	}
+	sbomFile := path.Join(s.manifest.Directory, "sbom", util.ImageArchiveName(img.Image, img.Variant, arch)+".spdx.json")
+	sbom, err := os.ReadFile(sbomFile)
+	if os.IsNotExist(err) {
+		log.Warnf("No SBOM found for %v, skipping attachment", digestRef)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read sbom %v: %v", sbomFile, err)
+	}
+
+	ref, err := name.NewDigest(digestRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", digestRef, err)
+	}
+	subject, err := remoteDescriptor(ref)
+	if err != nil {
+		return err
+	} else if subject == nil {
+		return fmt.Errorf("image %v does not exist", ref)
+	}
+	artifact, err := mutate.Append(empty.Image, mutate.Addendum{Layer: static.NewLayer(sbom, spdxMediaType)})
+	if err != nil {
+		return fmt.Errorf("failed to build sbom artifact for %v: %v", ref, err)
+	}
+	artifact = mutate.MediaType(artifact, types.OCIManifestSchema1)
+	artifact = mutate.ConfigMediaType(artifact, spdxMediaType)
+	artifact = mutate.Subject(artifact, *subject).(v1.Image)
+	digest, err := artifact.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to get digest of sbom artifact for %v: %v", ref, err)
+	}
+	// The artifact is built deterministically, so an earlier publish of the same SBOM has the same digest.
+	artifactRef := ref.Context().Digest(digest.String())
+	if existing, err := remoteDescriptor(artifactRef); err != nil {
+		return err
+	} else if existing != nil {
+		skipUpToDate("attach sbom %v to %v", path.Base(sbomFile), ref)
+	} else {
+		// Push by digest; registries without the referrers API get the fallback tag from remote.Write.
+		if err := pushImage(artifactRef, artifact, "sbom"); err != nil {
+			return fmt.Errorf("failed to push sbom for %v: %v", ref, err)
+		}
+		log.Infof("attached sbom %v to %v", path.Base(sbomFile), ref)
+	}
+
+	if s.signer != nil {
+		if !json.Valid(sbom) {
+			return fmt.Errorf("invalid sbom %v: not JSON", sbomFile)
+		}
+		if err := s.signer.attest(digestRef, spdxPredicateType, sbom); err != nil {
+			return err
+		}
+	}
+	s.done[digestRef] = struct{}{}
+	return nil
+}
@@ -0,0 +1,617 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/secure-systems-lab/go-securesystemslib/encrypted"
+	"github.com/sigstore/cosign/v2/pkg/cosign/bundle"
+	"github.com/sigstore/rekor/pkg/generated/models"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/kms"
+	// Register the KMS key providers supported by cosign.
+	_ "github.com/sigstore/sigstore/pkg/signature/kms/aws"
+	_ "github.com/sigstore/sigstore/pkg/signature/kms/azure"
+	_ "github.com/sigstore/sigstore/pkg/signature/kms/gcp"
+	_ "github.com/sigstore/sigstore/pkg/signature/kms/hashivault"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// cosignSignatureMediaType is the media type of the signed payload layers of a cosign signature image.
+	cosignSignatureMediaType types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// cosignSignatureAnnotation holds the base64 encoded signature of a payload layer.
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// dsseMediaType is the media type of the DSSE envelope layers of a cosign attestation image.
+	dsseMediaType types.MediaType = "application/vnd.dsse.envelope.v1+json"
+	// predicateTypeAnnotation holds the in-toto predicate type of an attestation layer.
+	predicateTypeAnnotation = "predicateType"
+	// intotoPayloadType is the DSSE payload type of in-toto statements.
+	intotoPayloadType = "application/vnd.in-toto+json"
+)
+
+// signingOptions configures how images are signed.
+type signingOptions struct {
+	// key is the signing key. Images are not signed if it is empty.
+	key string
+	// publicKey is a PEM file with the published public key of key, which signatures are verified against.
+	publicKey string
+	// rekorURL is the transparency log signatures are recorded in. Nothing is recorded if it is empty.
+	rekorURL string
+	// rekorPublicKey is a PEM file with the public key of the log. The sigstore trusted root is used if it is empty.
+	rekorPublicKey string
+}
+
+// imageSigner signs images with a key, storing signatures and attestations the same way as `cosign sign` and
+// `cosign attest`, so they can be verified with `cosign verify --key` and `cosign verify-attestation --key`. When a
+// Rekor log is configured, they are recorded in it as well. Every signature and attestation is recorded, so they can
+// be verified after publishing.
+type imageSigner struct {
+	signer   signature.Signer
+	verifier signature.Verifier
+	// publicKey is the PEM encoded public key, as recorded in the transparency log.
+	publicKey []byte
+	// rekor is nil when signatures are not recorded in a transparency log.
+	rekor  *rekorClient
+	signed map[string]struct{}
+	// attested holds the statements attested for each digest, by predicate type.
+	attested map[string]map[string][]byte
+}
+
+// newImageSigner loads the key used for signing images, and connects to the Rekor log, if any. The key is either a
+// cosign private key file, decrypted with $COSIGN_PASSWORD, a k8s://namespace/secret reference to a secret created
+// by `cosign generate-key-pair k8s://...`, or a KMS reference supported by cosign, such as gcpkms://, awskms://,
+// azurekms:// or hashivault://. Returns nil if no key is given, so images are not signed.
+// A key that cannot be loaded is an error, rather than publishing unsigned images.
+func newImageSigner(ctx context.Context, opts signingOptions) (*imageSigner, error) {
+	if opts.key == "" {
+		return nil, nil
+	}
+	signer, err := loadSigner(ctx, opts.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key %v: %v", opts.key, err)
+	}
+	pub, err := signer.PublicKey(options.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key of %v: %v", opts.key, err)
+	}
+	pemKey, err := cryptoutils.MarshalPublicKeyToPEM(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key of %v: %v", opts.key, err)
+	}
+	if opts.publicKey != "" {
+		published, err := os.ReadFile(opts.publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %v", err)
+		}
+		publishedKey, err := cryptoutils.UnmarshalPEMToPublicKey(published)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %v: %v", opts.publicKey, err)
+		}
+		if err := cryptoutils.EqualKeys(pub, publishedKey); err != nil {
+			return nil, fmt.Errorf("signing key %v does not match public key %v", opts.key, opts.publicKey)
+		}
+		pemKey = published
+	}
+	// Signatures are verified against the exported public key alone, like any consumer of the images would.
+	verifier, err := loadVerifier(pemKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load public key of %v: %v", opts.key, err)
+	}
+	var rekor *rekorClient
+	if opts.rekorURL != "" {
+		if rekor, err = newRekorClient(opts.rekorURL, opts.rekorPublicKey); err != nil {
+			return nil, err
+		}
+	}
+	return &imageSigner{
+		signer:    signer,
+		verifier:  verifier,
+		publicKey: pemKey,
+		rekor:     rekor,
+		signed:    map[string]struct{}{},
+		attested:  map[string]map[string][]byte{},
+	}, nil
+}
+
+// loadVerifier parses a PEM public key, as exported by `cosign public-key`.
+func loadVerifier(pemKey []byte) (signature.Verifier, error) {
+	pub, err := cryptoutils.UnmarshalPEMToPublicKey(pemKey)
+	if err != nil {
+		return nil, err
+	}
+	return signature.LoadVerifier(pub, crypto.SHA256)
+}
+
+// loadSigner loads a key by reference, accepting the same references as `cosign sign --key`.
+func loadSigner(ctx context.Context, key string) (signature.SignerVerifier, error) {
+	switch {
+	case strings.HasPrefix(key, "k8s://"):
+		return loadKubernetesKey(ctx, strings.TrimPrefix(key, "k8s://"))
+	case strings.Contains(key, "://"):
+		// Any other scheme is a KMS key, handled by one of the registered providers.
+		sv, err := kms.Get(ctx, key, crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("%v (supported KMS providers: %v)", err, strings.Join(kms.SupportedProviders(), ", "))
+		}
+		return sv, nil
+	default:
+		content, err := os.ReadFile(key)
+		if err != nil {
+			return nil, err
+		}
+		return loadPrivateKey(content, []byte(os.Getenv("COSIGN_PASSWORD")))
+	}
+}
+
+// loadKubernetesKey reads the key from a secret named "namespace/name", created by `cosign generate-key-pair k8s://`,
+// using the current kubeconfig. The secret holds the encrypted key and its password.
+func loadKubernetesKey(ctx context.Context, secret string) (signature.SignerVerifier, error) {
+	ns, secretName, f := strings.Cut(secret, "/")
+	if !f || ns == "" || secretName == "" {
+		return nil, fmt.Errorf("expected k8s://<namespace>/<secret>")
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	s, err := client.CoreV1().Secrets(ns).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %v", err)
+	}
+	return loadPrivateKey(s.Data["cosign.key"], s.Data["cosign.password"])
+}
+
+// loadPrivateKey parses a PEM private key, as generated by `cosign generate-key-pair`. Encrypted keys are decrypted
+// with password.
+func loadPrivateKey(content []byte, password []byte) (signature.SignerVerifier, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	der := block.Bytes
+	switch block.Type {
+	case "ENCRYPTED SIGSTORE PRIVATE KEY", "ENCRYPTED COSIGN PRIVATE KEY":
+		var err error
+		if der, err = encrypted.Decrypt(block.Bytes, password); err != nil {
+			return nil, fmt.Errorf("failed to decrypt key, check COSIGN_PASSWORD: %v", err)
+		}
+	case "PRIVATE KEY":
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	return signature.LoadSignerVerifier(priv, crypto.SHA256)
+}
+
+// sign signs an image by digest, along with the image of each architecture of a manifest list, like
+// `cosign sign --recursive`. Signing only works against real repositories, so this must happen after the push.
+func (s *imageSigner) sign(digestRef string, archDigests map[string]string) error {
+	refs := []string{digestRef}
+	for _, archDigest := range archDigests {
+		if archDigest != digestRef {
+			refs = append(refs, archDigest)
+		}
+	}
+	for _, r := range refs {
+		if err := s.signDigest(r); err != nil {
+			return err
+		}
+		s.signed[r] = struct{}{}
+	}
+	return nil
+}
+
+// signDigest adds a signature of digestRef to its signature image, unless it already has one from our key.
+func (s *imageSigner) signDigest(digestRef string) error {
+	ref, err := name.NewDigest(digestRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", digestRef, err)
+	}
+	sigTag := cosignTag(ref, ".sig")
+	sigs, err := fetchSignatures(sigTag)
+	if err != nil {
+		return err
+	}
+	if sigs != nil {
+		if err := s.verifyIn(sigs, ref); err == nil {
+			skipUpToDate("sign %v", digestRef)
+			return nil
+		}
+	}
+
+	if recordPlan(func(p *Plan) {
+		p.addImage(PlannedImage{Reference: sigTag.Name(), Kind: "signature"})
+	}) {
+		return nil
+	}
+	body, err := payload.Cosign{Image: ref}.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	sig, err := s.signer.SignMessage(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to sign %v: %v", digestRef, err)
+	}
+	annotations := map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+	entry, err := s.record(annotations, func(ctx context.Context) (models.ProposedEntry, error) {
+		return hashedRekordEntry(ctx, body, sig, s.publicKey)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record signature of %v: %v", digestRef, err)
+	}
+	if err := appendLayer(sigTag, sigs, static.NewLayer(body, cosignSignatureMediaType), annotations); err != nil {
+		return fmt.Errorf("failed to push signature for %v: %v", digestRef, err)
+	}
+	log.Infof("signed %v%v", digestRef, entry)
+	return nil
+}
+
+// attest adds an in-toto attestation of predicate to the attestation image of digestRef, unless it already has the
+// same one from our key. The statement is signed in a DSSE envelope and recorded in Rekor, like `cosign attest`.
+func (s *imageSigner) attest(digestRef string, predicateType string, predicate json.RawMessage) error {
+	ref, err := name.NewDigest(digestRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", digestRef, err)
+	}
+	statement, err := json.Marshal(map[string]any{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"predicateType": predicateType,
+		"subject": []map[string]any{{
+			"name":   ref.Context().String(),
+			"digest": map[string]string{"sha256": strings.TrimPrefix(ref.DigestStr(), "sha256:")},
+		}},
+		"predicate": predicate,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build attestation for %v: %v", digestRef, err)
+	}
+	attTag := cosignTag(ref, ".att")
+	atts, err := fetchSignatures(attTag)
+	if err != nil {
+		return err
+	}
+	if atts != nil {
+		if err := s.attestedIn(atts, predicateType, statement); err == nil {
+			s.recordAttestation(digestRef, predicateType, statement)
+			skipUpToDate("attest %v for %v", predicateType, digestRef)
+			return nil
+		}
+	}
+
+	if recordPlan(func(p *Plan) {
+		p.addImage(PlannedImage{Reference: attTag.Name(), Kind: "attestation"})
+	}) {
+		return nil
+	}
+	sig, err := s.signer.SignMessage(bytes.NewReader(dsse.PAE(intotoPayloadType, statement)))
+	if err != nil {
+		return fmt.Errorf("failed to sign attestation for %v: %v", digestRef, err)
+	}
+	envelope, err := json.Marshal(dsse.Envelope{
+		PayloadType: intotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  []dsse.Signature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	if err != nil {
+		return err
+	}
+	annotations := map[string]string{cosignSignatureAnnotation: "", predicateTypeAnnotation: predicateType}
+	entry, err := s.record(annotations, func(ctx context.Context) (models.ProposedEntry, error) {
+		return intotoEntry(ctx, envelope, s.publicKey)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record attestation of %v: %v", digestRef, err)
+	}
+	if err := appendLayer(attTag, atts, static.NewLayer(envelope, dsseMediaType), annotations); err != nil {
+		return fmt.Errorf("failed to push attestation for %v: %v", digestRef, err)
+	}
+	s.recordAttestation(digestRef, predicateType, statement)
+	log.Infof("attested %v for %v%v", predicateType, digestRef, entry)
+	return nil
+}
+
+func (s *imageSigner) recordAttestation(digestRef string, predicateType string, statement []byte) {
+	if s.attested[digestRef] == nil {
+		s.attested[digestRef] = map[string][]byte{}
+	}
+	s.attested[digestRef][predicateType] = statement
+}
+
+// record adds the log entry of a signature to the transparency log, if one is used, storing its bundle in
+// annotations. Returns a description of the entry for logging.
+func (s *imageSigner) record(annotations map[string]string, entry func(ctx context.Context) (models.ProposedEntry, error)) (string, error) {
+	if s.rekor == nil {
+		return "", nil
+	}
+	ctx := context.Background()
+	e, err := entry(ctx)
+	if err != nil {
+		return "", err
+	}
+	b, err := s.rekor.upload(ctx, e)
+	if err != nil {
+		return "", err
+	}
+	bundleJSON, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	annotations[cosignBundleAnnotation] = string(bundleJSON)
+	return fmt.Sprintf(" (rekor entry %v)", b.Payload.LogIndex), nil
+}
+
+// verifyRecorded checks that the bundle in annotations is the log entry of a signature, if a transparency log is
+// used. `cosign verify` rejects signatures without their log entry, unless told to ignore the log.
+func (s *imageSigner) verifyRecorded(annotations map[string]string, entry func(ctx context.Context) (models.ProposedEntry, error)) error {
+	if s.rekor == nil {
+		return nil
+	}
+	var b bundle.RekorBundle
+	if err := json.Unmarshal([]byte(annotations[cosignBundleAnnotation]), &b); err != nil {
+		return fmt.Errorf("signature has no valid rekor bundle: %v", err)
+	}
+	ctx := context.Background()
+	e, err := entry(ctx)
+	if err != nil {
+		return err
+	}
+	return s.rekor.verify(ctx, &b, e)
+}
+
+// appendLayer pushes img, or a new image if it is nil, with layer added, to tag.
+func appendLayer(tag name.Tag, img v1.Image, layer v1.Layer, annotations map[string]string) error {
+	if img == nil {
+		img = mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+		img = mutate.ConfigMediaType(img, types.OCIConfigJSON)
+	}
+	img, err := mutate.Append(img, mutate.Addendum{Layer: layer, Annotations: annotations})
+	if err != nil {
+		return err
+	}
+	return remote.Write(tag, img, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+}
+
+// verify checks the signature of every image signed so far, and every attestation, as pushed to the registry.
+func (s *imageSigner) verify() error {
+	refs := make([]string, 0, len(s.signed))
+	for r := range s.signed {
+		refs = append(refs, r)
+	}
+	sort.Strings(refs)
+	var errs []error
+	for _, r := range refs {
+		if err := s.verifyDigest(r); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Infof("Verified signature of %v", r)
+	}
+	refs = refs[:0]
+	for r := range s.attested {
+		refs = append(refs, r)
+	}
+	sort.Strings(refs)
+	for _, r := range refs {
+		if err := s.verifyAttestations(r); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Infof("Verified attestations of %v", r)
+	}
+	return errors.Join(errs...)
+}
+
+// verifyAttestations checks that the attestation image of digestRef holds every statement attested for it.
+func (s *imageSigner) verifyAttestations(digestRef string) error {
+	ref, err := name.NewDigest(digestRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", digestRef, err)
+	}
+	atts, err := fetchSignatures(cosignTag(ref, ".att"))
+	if err != nil {
+		return err
+	}
+	if atts == nil {
+		return fmt.Errorf("image %v has no attestations", digestRef)
+	}
+	predicateTypes := make([]string, 0, len(s.attested[digestRef]))
+	for predicateType := range s.attested[digestRef] {
+		predicateTypes = append(predicateTypes, predicateType)
+	}
+	sort.Strings(predicateTypes)
+	for _, predicateType := range predicateTypes {
+		if err := s.attestedIn(atts, predicateType, s.attested[digestRef][predicateType]); err != nil {
+			return fmt.Errorf("failed to verify %v attestation of %v: %v", predicateType, digestRef, err)
+		}
+	}
+	return nil
+}
+
+// verifyDigest checks that the signature image of digestRef holds a valid signature from our key.
+func (s *imageSigner) verifyDigest(digestRef string) error {
+	ref, err := name.NewDigest(digestRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", digestRef, err)
+	}
+	sigs, err := fetchSignatures(cosignTag(ref, ".sig"))
+	if err != nil {
+		return err
+	}
+	if sigs == nil {
+		return fmt.Errorf("image %v is not signed", digestRef)
+	}
+	if err := s.verifyIn(sigs, ref); err != nil {
+		return fmt.Errorf("failed to verify signature of %v: %v", digestRef, err)
+	}
+	return nil
+}
+
+// verifyIn returns nil if one of the layers of a signature image is a signature of ref made with our key, recorded
+// in the transparency log if one is used.
+func (s *imageSigner) verifyIn(sigs v1.Image, ref name.Digest) error {
+	m, err := sigs.Manifest()
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, desc := range m.Layers {
+		if desc.MediaType != cosignSignatureMediaType {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(desc.Annotations[cosignSignatureAnnotation])
+		if err != nil {
+			continue
+		}
+		body, err := layerContent(sigs, desc.Digest)
+		if err != nil {
+			return err
+		}
+		if s.verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(body)) != nil {
+			continue
+		}
+		// The signature only counts for the image named in the signed payload.
+		var p payload.Cosign
+		if err := p.UnmarshalJSON(body); err != nil || p.Image.DigestStr() != ref.DigestStr() {
+			continue
+		}
+		if lastErr = s.verifyRecorded(desc.Annotations, func(ctx context.Context) (models.ProposedEntry, error) {
+			return hashedRekordEntry(ctx, body, sig, s.publicKey)
+		}); lastErr == nil {
+			return nil
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("no valid signature found in %v layers", len(m.Layers))
+}
+
+// attestedIn returns nil if one of the layers of an attestation image holds statement, signed with our key and
+// recorded in the transparency log if one is used.
+func (s *imageSigner) attestedIn(atts v1.Image, predicateType string, statement []byte) error {
+	m, err := atts.Manifest()
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, desc := range m.Layers {
+		if desc.MediaType != dsseMediaType || desc.Annotations[predicateTypeAnnotation] != predicateType {
+			continue
+		}
+		content, err := layerContent(atts, desc.Digest)
+		if err != nil {
+			return err
+		}
+		var envelope dsse.Envelope
+		if err := json.Unmarshal(content, &envelope); err != nil {
+			continue
+		}
+		existing, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil || envelope.PayloadType != intotoPayloadType || !bytes.Equal(existing, statement) {
+			continue
+		}
+		for _, sig := range envelope.Signatures {
+			raw, err := base64.StdEncoding.DecodeString(sig.Sig)
+			if err != nil {
+				continue
+			}
+			if s.verifier.VerifySignature(bytes.NewReader(raw), bytes.NewReader(dsse.PAE(intotoPayloadType, statement))) != nil {
+				continue
+			}
+			if lastErr = s.verifyRecorded(desc.Annotations, func(ctx context.Context) (models.ProposedEntry, error) {
+				return intotoEntry(ctx, content, s.publicKey)
+			}); lastErr == nil {
+				return nil
+			}
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("no valid attestation found in %v layers", len(m.Layers))
+}
+
+// layerContent reads a layer of img, such as a signature payload.
+func layerContent(img v1.Image, digest v1.Hash) ([]byte, error) {
+	layer, err := img.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// cosignTag returns the tag cosign stores artifacts of an image under, `sha256-<hex>.<suffix>`, such as signatures
+// (".sig") or attestations (".att").
+func cosignTag(ref name.Digest, suffix string) name.Tag {
+	return ref.Context().Tag(strings.Replace(ref.DigestStr(), ":", "-", 1) + suffix)
+}
+
+// fetchSignatures returns the signature or attestation image at tag, or nil if there are none yet.
+func fetchSignatures(tag name.Tag) (v1.Image, error) {
+	img, err := remote.Image(tag, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get signatures %v: %v", tag, err)
+	}
+	return img, nil
+}
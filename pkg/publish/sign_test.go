@@ -0,0 +1,358 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/secure-systems-lab/go-securesystemslib/encrypted"
+	"github.com/sigstore/rekor/pkg/generated/models"
+	rekortypes "github.com/sigstore/rekor/pkg/types"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// writeSigningKey writes a new key, encrypted with password like `cosign generate-key-pair`.
+func writeSigningKey(t *testing.T, password string) string {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := encrypted.Encrypt(der, []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "cosign.key")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: enc}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// newFakeRekor starts a Rekor log that accepts any entry, signing entry timestamps with its own key. Returns its URL,
+// and a file with its public key.
+func newFakeRekor(t *testing.T) (string, string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signature.LoadECDSASignerVerifier(priv, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writePublicKey(t, priv.Public())
+	der, err := cryptoutils.MarshalPublicKeyToDER(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	logID := hex.EncodeToString(sum[:])
+
+	var mu sync.Mutex
+	entries := map[string]models.LogEntryAnon{}
+	writeJSON := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/log/entries/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		e, f := entries[r.PathValue("uuid")]
+		if !f {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, models.LogEntry{r.PathValue("uuid"): e})
+	})
+	mux.HandleFunc("POST /api/v1/log/entries", func(w http.ResponseWriter, r *http.Request) {
+		// Like Rekor, store the canonical form of the entry.
+		pe, err := models.UnmarshalProposedEntry(r.Body, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, err := rekortypes.UnmarshalEntry(pe)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := rekortypes.CanonicalizeEntry(r.Context(), impl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodySum := sha256.Sum256(body)
+		uuid := hex.EncodeToString(bodySum[:])
+		if _, f := entries[uuid]; f {
+			w.Header().Set("Location", "/api/v1/log/entries/"+uuid)
+			writeJSON(w, http.StatusConflict, models.Error{Code: http.StatusConflict, Message: "entry already exists"})
+			return
+		}
+		integratedTime, logIndex := time.Now().Unix(), int64(len(entries))
+		e := models.LogEntryAnon{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: &integratedTime,
+			LogID:          &logID,
+			LogIndex:       &logIndex,
+		}
+		// The signed entry timestamp is over the canonical JSON of the entry, which has sorted keys.
+		set, _ := json.Marshal(map[string]any{
+			"body":           e.Body,
+			"integratedTime": integratedTime,
+			"logID":          logID,
+			"logIndex":       logIndex,
+		})
+		sig, err := signer.SignMessage(bytes.NewReader(set))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.Verification = &models.LogEntryAnonVerification{SignedEntryTimestamp: sig}
+		entries[uuid] = e
+		writeJSON(w, http.StatusCreated, models.LogEntry{uuid: e})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL, keyFile
+}
+
+// writePublicKey writes a PEM public key, like `cosign public-key`.
+func writePublicKey(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+	pemKey, err := cryptoutils.MarshalPublicKeyToPEM(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "key.pub")
+	if err := os.WriteFile(file, pemKey, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// publicKeyOf writes the public key of a signing key.
+func publicKeyOf(t *testing.T, key string) string {
+	t.Helper()
+	signer, err := loadSigner(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := signer.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePublicKey(t, pub)
+}
+
+func TestImageSigner(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	hub := strings.TrimPrefix(server.URL, "http://")
+	rekorURL, rekorKey := newFakeRekor(t)
+	ctx := context.Background()
+
+	t.Setenv("COSIGN_PASSWORD", "secret")
+	key := writeSigningKey(t, "secret")
+	otherKey := writeSigningKey(t, "secret")
+	opts := signingOptions{key: key, publicKey: publicKeyOf(t, key), rekorURL: rekorURL, rekorPublicKey: rekorKey}
+
+	if signer, err := newImageSigner(ctx, signingOptions{rekorURL: rekorURL}); signer != nil || err != nil {
+		t.Fatalf("expected signing to be disabled without a key, got %v", err)
+	}
+	for name, o := range map[string]signingOptions{
+		"a missing key":               {key: filepath.Join(t.TempDir(), "missing.key")},
+		"an unsupported key scheme":   {key: "unknownkms://key"},
+		"a public key of another key": {key: key, publicKey: publicKeyOf(t, otherKey)},
+		"a missing rekor public key":  {key: key, rekorURL: rekorURL, rekorPublicKey: filepath.Join(t.TempDir(), "missing.pub")},
+		"an invalid rekor public key": {key: key, rekorURL: rekorURL, rekorPublicKey: key},
+	} {
+		if _, err := newImageSigner(ctx, o); err == nil {
+			t.Fatalf("expected an error for %v", name)
+		}
+	}
+	t.Setenv("COSIGN_PASSWORD", "wrong")
+	if _, err := newImageSigner(ctx, opts); err == nil {
+		t.Fatal("expected an error for the wrong password")
+	}
+	t.Setenv("COSIGN_PASSWORD", "secret")
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(hub + "/pilot:1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digestRef := ref.Context().String() + "@" + digest.String()
+	sigTag := cosignTag(ref.Context().Digest(digest.String()), ".sig")
+	attTag := cosignTag(ref.Context().Digest(digest.String()), ".att")
+
+	// Entries are only accepted from the log with the pinned key.
+	untrusted, err := newImageSigner(ctx, signingOptions{key: key, rekorURL: rekorURL, rekorPublicKey: publicKeyOf(t, otherKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := untrusted.sign(digestRef, nil); err == nil {
+		t.Fatal("expected signing to fail with the wrong rekor public key")
+	}
+
+	signer, err := newImageSigner(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signing again is a no-op, as the image already has a signature from the key.
+	for i := 0; i < 2; i++ {
+		if err := signer.sign(digestRef, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := signer.verify(); err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := fetchSignatures(sigTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layers, err := sigs.Layers(); err != nil || len(layers) != 1 {
+		t.Fatalf("expected 1 signature, got %v: %v", len(layers), err)
+	}
+
+	// Attesting the same predicate again is a no-op as well.
+	for i := 0; i < 2; i++ {
+		if err := signer.attest(digestRef, spdxPredicateType, json.RawMessage(`{"spdxVersion":"SPDX-2.3"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	atts, err := fetchSignatures(attTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layers, err := atts.Layers(); err != nil || len(layers) != 1 {
+		t.Fatalf("expected 1 attestation, got %v: %v", len(layers), err)
+	}
+	if err := signer.verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A signature from another key does not verify, and neither does its attestation.
+	other, err := newImageSigner(ctx, signingOptions{key: otherKey, rekorURL: rekorURL, rekorPublicKey: rekorKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.signed[digestRef] = struct{}{}
+	other.attested = signer.attested
+	if err := other.verify(); err == nil || !strings.Contains(err.Error(), "attestation") {
+		t.Fatalf("expected verification with another key to fail, got %v", err)
+	}
+	// An attestation that was replaced in the registry does not verify.
+	if err := remote.Write(attTag, sigs); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.verify(); err == nil || !strings.Contains(err.Error(), "attestation of") {
+		t.Fatalf("expected a missing attestation to fail verification, got %v", err)
+	}
+	if err := remote.Write(attTag, atts); err != nil {
+		t.Fatal(err)
+	}
+	// Neither does a signature without its transparency log entry, as `cosign verify` would reject it.
+	m, err := sigs.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := sigs.LayerByDigest(m.Layers[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped, err := mutate.Append(mutate.MediaType(empty.Image, m.MediaType), mutate.Addendum{
+		Layer:       layer,
+		Annotations: map[string]string{cosignSignatureAnnotation: m.Layers[0].Annotations[cosignSignatureAnnotation]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(sigTag, stripped); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.verify(); err == nil || !strings.Contains(err.Error(), "rekor bundle") {
+		t.Fatalf("expected a signature without a rekor bundle to fail verification, got %v", err)
+	}
+	// Without a transparency log, the signature is enough.
+	offline, err := newImageSigner(ctx, signingOptions{key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offline.signed[digestRef] = struct{}{}
+	if err := offline.verify(); err != nil {
+		t.Fatal(err)
+	}
+	// Signing again adds a signature with a log entry.
+	if err := signer.sign(digestRef, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.verify(); err != nil {
+		t.Fatal(err)
+	}
+	// Images that were never signed do not verify either.
+	unsigned := ref.Context().String() + "@sha256:" + strings.Repeat("0", 64)
+	signer.signed[unsigned] = struct{}{}
+	if err := signer.verify(); err == nil || !strings.Contains(err.Error(), "is not signed") {
+		t.Fatalf("expected an unsigned image to fail verification, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint: gosec
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/ptr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"istio.io/istio/pkg/log"
+)
+
+// Publishing compares against the remote state first, so a publish that is rerun after a partial failure only
+// does the missing work. skippedActions records the actions that were already done.
+var skippedActions = struct {
+	sync.Mutex
+	actions []string
+}{}
+
+// skipUpToDate records an action that is skipped, as the remote is already up to date.
+func skipUpToDate(format string, args ...any) {
+	action := fmt.Sprintf(format, args...)
+	log.Infof("Skipping %v: already up to date", action)
+	skippedActions.Lock()
+	defer skippedActions.Unlock()
+	skippedActions.actions = append(skippedActions.actions, action)
+}
+
+// reportSkipped logs every action that was skipped.
+func reportSkipped() {
+	skippedActions.Lock()
+	defer skippedActions.Unlock()
+	if len(skippedActions.actions) == 0 {
+		return
+	}
+	log.Infof("Skipped %d actions that were already published:\n  %s",
+		len(skippedActions.actions), strings.Join(skippedActions.actions, "\n  "))
+}
+
+// fileMD5 returns the MD5 of a file, as used by GCS and S3 to identify object contents.
+func fileMD5(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md5.New() //nolint: gosec
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to hash %v: %v", file, err)
+	}
+	return h.Sum(nil), nil
+}
+
+func md5Sum(content []byte) []byte {
+	sum := md5.Sum(content) //nolint: gosec
+	return sum[:]
+}
+
+// gcsObjectMatches returns true if the object exists with the given MD5. Composite objects have no MD5, so never match.
+func gcsObjectMatches(ctx context.Context, obj *storage.ObjectHandle, sum []byte) (bool, error) {
+	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to fetch attributes for object %s: %v", obj.ObjectName(), err)
+	}
+	return len(attrs.MD5) > 0 && bytes.Equal(attrs.MD5, sum), nil
+}
+
+// s3ObjectMatches returns true if the object exists with the given MD5, either recorded in its metadata or as its
+// ETag. Multipart uploads have a different ETag, so only match by metadata.
+func s3ObjectMatches(ctx context.Context, client *s3.Client, bucket, key string, sum []byte) (bool, error) {
+	out, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: ptr.String(bucket),
+		Key:    ptr.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		var apiErr smithy.APIError
+		if errors.As(err, &notFound) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound") {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to head object %v: %v", key, err)
+	}
+	if md5, f := out.Metadata[s3MD5Metadata]; f {
+		return md5 == hex.EncodeToString(sum), nil
+	}
+	return out.ETag != nil && strings.Trim(*out.ETag, `"`) == hex.EncodeToString(sum), nil
+}
+
+// remoteDescriptor returns the descriptor a reference currently points to, or nil if it does not exist. When
+// planning, references that are planned to be pushed point to the planned push.
+func remoteDescriptor(ref name.Reference) (*v1.Descriptor, error) {
+	if desc, f := plannedDescriptor(ref); f {
+		return desc, nil
+	}
+	desc, err := remote.Head(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get descriptor for %v: %v", ref, err)
+	}
+	return desc, nil
+}
+
+// remoteHasDigest returns true if ref already points to digest.
+func remoteHasDigest(ref name.Reference, digest v1.Hash) (bool, error) {
+	desc, err := remoteDescriptor(ref)
+	if err != nil || desc == nil {
+		return false, err
+	}
+	return desc.Digest == digest, nil
+}
@@ -0,0 +1,105 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"istio.io/istio/pkg/log"
+)
+
+// uploadObjects uploads objects to dest, running up to --upload-concurrency uploads at a time. Each upload is retried
+// with exponential backoff. upload reports the bytes written, or that the object was already up to date. A summary of
+// the bytes uploaded and the throughput is logged once all uploads finish.
+func uploadObjects(ctx context.Context, dest string, objects []releaseObject,
+	upload func(ctx context.Context, o releaseObject) (PutResult, error),
+) error {
+	start := time.Now()
+	var mu sync.Mutex
+	var written int64
+	uploaded, skipped := 0, 0
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(flags.uploadConcurrency, 1))
+	for _, o := range objects {
+		g.Go(func() error {
+			var res PutResult
+			err := withRetry(ctx, o.key, func() error {
+				var err error
+				res, err = upload(ctx, o)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if res.UpToDate {
+				skipped++
+			} else {
+				uploaded++
+				written += res.Bytes
+			}
+			return nil
+		})
+	}
+	err := g.Wait()
+
+	elapsed := time.Since(start)
+	throughput := float64(written) / max(elapsed.Seconds(), 0.001)
+	log.Infof("Uploaded %d files (%s) to %s in %v (%s/s), %d already up to date",
+		uploaded, formatBytes(float64(written)), dest, elapsed.Round(time.Millisecond), formatBytes(throughput), skipped)
+	return err
+}
+
+// withRetry runs fn, retrying failures up to --upload-retries times. The delay between attempts starts at a second,
+// and doubles after every attempt.
+func withRetry(ctx context.Context, name string, fn func() error) error {
+	attempts := max(flags.uploadRetries, 0) + 1
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == attempts || ctx.Err() != nil {
+			return err
+		}
+		log.Warnf("Upload of %v failed (attempt %d/%d), retrying in %v: %v", name, attempt, attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// formatBytes formats a number of bytes for humans, such as 12.3 MiB.
+func formatBytes(b float64) string {
+	const unit = 1024
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for b >= unit && i < len(units)-1 {
+		b /= unit
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
@@ -0,0 +1,272 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// Image describes a container image: its layers, and the Debian packages installed in it.
+func Image(name, version string, img v1.Image) (*Document, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digest of %v: %v", name, err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config of %v: %v", name, err)
+	}
+	root := &Package{
+		ID:        NewID("image", name, version, cfg.Architecture),
+		Name:      name,
+		Version:   version,
+		Type:      Container,
+		PURL:      fmt.Sprintf("pkg:oci/%s@%s?arch=%s&tag=%s", name, url.QueryEscape(digest.String()), cfg.Architecture, version),
+		Checksums: map[string]string{"SHA256": digest.Hex},
+	}
+	doc := newFragment(root)
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layers of %v: %v", name, err)
+	}
+	for i, l := range layers {
+		ld, err := l.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer digest of %v: %v", name, err)
+		}
+		p := &Package{
+			ID:        NewID("layer", ld.Hex),
+			Name:      fmt.Sprintf("%s layer %d", name, i),
+			Type:      File,
+			Checksums: map[string]string{"SHA256": ld.Hex},
+		}
+		doc.Add(p)
+		doc.Relate(root.ID, Contains, p.ID)
+	}
+
+	files, err := imageFiles(img, func(name string) bool {
+		return name == "etc/os-release" || name == "usr/lib/os-release" ||
+			name == "var/lib/dpkg/status" || strings.HasPrefix(name, "var/lib/dpkg/status.d/")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read files of %v: %v", name, err)
+	}
+	distro := osReleaseID(files["etc/os-release"])
+	if distro == "" {
+		distro = osReleaseID(files["usr/lib/os-release"])
+	}
+	if distro == "" {
+		distro = "debian"
+	}
+	for fname, contents := range files {
+		if !strings.HasPrefix(fname, "var/lib/dpkg/") || strings.HasSuffix(fname, ".md5sums") {
+			continue
+		}
+		for _, deb := range parseDpkgStatus(contents) {
+			p := &Package{
+				ID:      NewID("deb", deb["Package"], deb["Version"], deb["Architecture"]),
+				Name:    deb["Package"],
+				Version: deb["Version"],
+				Type:    Library,
+				PURL: fmt.Sprintf("pkg:deb/%s/%s@%s?arch=%s", distro, deb["Package"],
+					url.PathEscape(deb["Version"]), deb["Architecture"]),
+			}
+			doc.Add(p)
+			doc.Relate(root.ID, Contains, p.ID)
+		}
+	}
+	return doc, nil
+}
+
+// imageFiles returns the contents of the files in the flattened image filesystem that match the filter.
+func imageFiles(img v1.Image, filter func(name string) bool) (map[string][]byte, error) {
+	rc := mutate.Extract(img)
+	defer rc.Close()
+	res := map[string][]byte{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(filepath.Clean(hdr.Name), "/")
+		if hdr.Typeflag != tar.TypeReg || !filter(name) {
+			continue
+		}
+		by, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = by
+	}
+	return res, nil
+}
+
+// osReleaseID returns the ID field of an os-release file, such as debian or ubuntu.
+func osReleaseID(osRelease []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(osRelease))
+	for scanner.Scan() {
+		if v, f := strings.CutPrefix(scanner.Text(), "ID="); f {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
+
+// parseDpkgStatus reads the installed packages from a dpkg status file. Distroless images instead have one file
+// per package under status.d, in the same format but without the Status field.
+func parseDpkgStatus(status []byte) []map[string]string {
+	res := []map[string]string{}
+	cur := map[string]string{}
+	flush := func() {
+		if cur["Package"] != "" && (cur["Status"] == "" || strings.HasSuffix(cur["Status"], " installed")) {
+			res = append(res, cur)
+		}
+		cur = map[string]string{}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			// Continuation of a multi-line field
+			continue
+		}
+		if k, v, f := strings.Cut(line, ":"); f {
+			cur[k] = strings.TrimSpace(v)
+		}
+	}
+	flush()
+	return res
+}
+
+// Artifact describes a release artifact, named by its path within the release. For tar.gz and zip archives,
+// the files inside are included as well.
+func Artifact(name, file string) (*Document, error) {
+	sha, err := fileSha256(file)
+	if err != nil {
+		return nil, err
+	}
+	root := &Package{
+		ID:        NewID("file", name),
+		Name:      name,
+		Type:      File,
+		Checksums: map[string]string{"SHA256": sha},
+	}
+	doc := newFragment(root)
+
+	add := func(entry string, r io.Reader) error {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return fmt.Errorf("failed to read %v in %v: %v", entry, name, err)
+		}
+		p := &Package{
+			ID:        NewID("file", name, entry),
+			Name:      entry,
+			Type:      File,
+			Checksums: map[string]string{"SHA256": hex.EncodeToString(h.Sum(nil))},
+		}
+		doc.Add(p)
+		doc.Relate(root.ID, Contains, p.ID)
+		return nil
+	}
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		root.Type = Archive
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v: %v", file, err)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %v: %v", file, err)
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := add(hdr.Name, tr); err != nil {
+				return nil, err
+			}
+		}
+	case strings.HasSuffix(name, ".zip"):
+		root.Type = Archive
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v: %v", file, err)
+		}
+		defer zr.Close()
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			r, err := zf.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %v in %v: %v", zf.Name, file, err)
+			}
+			err = add(zf.Name, r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+func fileSha256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read %v: %v", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
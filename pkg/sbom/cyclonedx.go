@@ -0,0 +1,135 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"crypto/sha1" //nolint: gosec // only used to derive a stable serial number
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type    string    `json:"type"`
+	BOMRef  string    `json:"bom-ref,omitempty"`
+	Name    string    `json:"name"`
+	Version string    `json:"version,omitempty"`
+	PURL    string    `json:"purl,omitempty"`
+	Hashes  []cdxHash `json:"hashes,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// cdxTypes maps package types onto CycloneDX component types.
+var cdxTypes = map[PackageType]string{
+	Application: "application",
+	Library:     "library",
+	Container:   "container",
+	Source:      "application",
+	Archive:     "file",
+	File:        "file",
+}
+
+// cdxAlgorithms maps SPDX checksum algorithms onto CycloneDX hash algorithms.
+var cdxAlgorithms = map[string]string{
+	"SHA1":   "SHA-1",
+	"SHA256": "SHA-256",
+	"SHA512": "SHA-512",
+}
+
+// CycloneDX serializes the document as CycloneDX 1.5 JSON. CycloneDX has no equivalent of the SPDX relationship
+// types, so both containment and dependencies are written to the dependency graph.
+func (d *Document) CycloneDX() ([]byte, error) {
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: serialNumber(d.Namespace),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: d.Created.Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: Creator}}},
+		},
+		Components:   []cdxComponent{},
+		Dependencies: []cdxDependency{},
+	}
+	for _, p := range d.Packages {
+		c := cdxComponent{
+			Type:    cdxTypes[p.Type],
+			BOMRef:  p.ID,
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.PURL,
+		}
+		if c.Type == "" {
+			c.Type = "library"
+		}
+		for _, alg := range slices.Sorted(maps.Keys(p.Checksums)) {
+			if cdxAlg, f := cdxAlgorithms[alg]; f {
+				c.Hashes = append(c.Hashes, cdxHash{Alg: cdxAlg, Content: p.Checksums[alg]})
+			}
+		}
+		if p.ID == d.Describes {
+			doc.Metadata.Component = c
+		} else {
+			doc.Components = append(doc.Components, c)
+		}
+	}
+	deps := map[string][]string{}
+	for _, r := range d.Relationships {
+		deps[r.From] = append(deps[r.From], r.To)
+	}
+	for _, ref := range slices.Sorted(maps.Keys(deps)) {
+		doc.Dependencies = append(doc.Dependencies, cdxDependency{Ref: ref, DependsOn: deps[ref]})
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// serialNumber derives a stable RFC 4122 URN (a name based, version 5 style UUID) from the document namespace.
+func serialNumber(namespace string) string {
+	h := sha1.Sum([]byte(namespace)) //nolint: gosec
+	h[6] = (h[6] & 0x0f) | 0x50
+	h[8] = (h[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
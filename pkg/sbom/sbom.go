@@ -0,0 +1,150 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sbom builds Software Bills of Materials for the release, and writes them as SPDX 2.3 or CycloneDX 1.5 JSON.
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PackageType classifies a package. These map onto CycloneDX component types, and SPDX primary package purposes.
+type PackageType string
+
+const (
+	Application PackageType = "application"
+	Library     PackageType = "library"
+	Container   PackageType = "container"
+	Source      PackageType = "source"
+	Archive     PackageType = "archive"
+	File        PackageType = "file"
+)
+
+// RelationshipType describes how two elements of a document relate, using the SPDX names.
+type RelationshipType string
+
+const (
+	Contains  RelationshipType = "CONTAINS"
+	DependsOn RelationshipType = "DEPENDS_ON"
+)
+
+// Package is a single component described by an SBOM.
+type Package struct {
+	// ID uniquely identifies the package within a document. Use NewID to construct one.
+	ID      string
+	Name    string
+	Version string
+	Type    PackageType
+	// PURL is the package URL, such as pkg:golang/golang.org/x/mod@v0.1.0
+	PURL string
+	// DownloadLocation is where the package can be retrieved from, if known.
+	DownloadLocation string
+	// Checksums maps an SPDX algorithm name (eg SHA256) to the hex encoded checksum.
+	Checksums map[string]string
+}
+
+// Relationship declares that From relates to To.
+type Relationship struct {
+	From string
+	To   string
+	Type RelationshipType
+}
+
+// Document is a format independent SBOM.
+type Document struct {
+	Name string
+	// Namespace is the unique URI of the document
+	Namespace string
+	Created   time.Time
+	// Describes is the ID of the package the document is about.
+	Describes     string
+	Packages      []*Package
+	Relationships []Relationship
+
+	ids map[string]struct{}
+}
+
+// NewDocument creates a document describing the root package.
+func NewDocument(name, namespace string, root *Package) *Document {
+	d := &Document{
+		Name:      name,
+		Namespace: namespace,
+		Created:   time.Now().UTC(),
+		Describes: root.ID,
+		ids:       map[string]struct{}{},
+	}
+	d.Add(root)
+	return d
+}
+
+// newFragment creates a document describing root, without a name. Fragments are merged into other documents,
+// or turned into a document of their own with Named.
+func newFragment(root *Package) *Document {
+	return NewDocument("", "", root)
+}
+
+// Named returns a copy of the document with the given name and namespace.
+func (d *Document) Named(name, namespace string) *Document {
+	c := *d
+	c.Packages = slices.Clone(d.Packages)
+	c.Relationships = slices.Clone(d.Relationships)
+	c.ids = maps.Clone(d.ids)
+	c.Name = name
+	c.Namespace = namespace
+	c.Created = time.Now().UTC()
+	return &c
+}
+
+// Add adds packages to the document. Packages already in the document, by ID, are ignored.
+func (d *Document) Add(pkgs ...*Package) {
+	for _, p := range pkgs {
+		if _, f := d.ids[p.ID]; f {
+			continue
+		}
+		d.ids[p.ID] = struct{}{}
+		d.Packages = append(d.Packages, p)
+	}
+}
+
+// Relate records a relationship between two packages in the document.
+func (d *Document) Relate(from string, typ RelationshipType, to string) {
+	d.Relationships = append(d.Relationships, Relationship{From: from, To: to, Type: typ})
+}
+
+// Merge adds all packages and relationships of another document, relating its root to parent.
+func (d *Document) Merge(parent string, typ RelationshipType, other *Document) {
+	d.Add(other.Packages...)
+	d.Relationships = append(d.Relationships, other.Relationships...)
+	d.Relate(parent, typ, other.Describes)
+}
+
+var invalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// NewID builds a package ID from its parts, which is valid as an SPDX identifier. Replacing invalid characters
+// can map different parts to the same readable ID, such as github.com/foo/bar-baz and github.com/foo-bar/baz, so the
+// ID ends with a hash of the original parts. Otherwise Add would drop the second package as a duplicate.
+func NewID(parts ...string) string {
+	id := "SPDXRef-Package"
+	for _, p := range parts {
+		id += "-" + invalidIDChars.ReplaceAllString(p, "-")
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return id + "-" + hex.EncodeToString(sum[:6])
+}
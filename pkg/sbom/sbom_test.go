@@ -0,0 +1,214 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGoModules(t *testing.T) {
+	goMod := filepath.Join(t.TempDir(), "go.mod")
+	if err := os.WriteFile(goMod, []byte(`module istio.io/istio
+
+go 1.24
+
+require (
+	github.com/a/b v1.0.0
+	github.com/c/d v0.2.0 // indirect
+	github.com/e/f v1.1.0
+	istio.io/local v0.0.0
+)
+
+replace github.com/e/f => github.com/e/g v1.2.0
+
+replace istio.io/local => ./local
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := GoModules(goMod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, p := range doc.Packages {
+		got = append(got, p.PURL)
+	}
+	want := []string{
+		"pkg:golang/istio.io/istio",
+		"pkg:golang/github.com/a/b@v1.0.0",
+		"pkg:golang/github.com/c/d@v0.2.0",
+		"pkg:golang/github.com/e/g@v1.2.0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if len(doc.Relationships) != 3 {
+		t.Fatalf("expected 3 relationships, got %v", doc.Relationships)
+	}
+}
+
+func TestParseCargoLock(t *testing.T) {
+	got := parseCargoLock([]byte(`# This file is automatically @generated by Cargo.
+version = 3
+
+[[package]]
+name = "ztunnel"
+version = "0.0.0"
+dependencies = [
+ "tokio",
+]
+
+[[package]]
+name = "tokio"
+version = "1.38.0"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+checksum = "abcd"
+
+[metadata]
+foo = "bar"
+`))
+	want := []map[string]string{
+		{"name": "ztunnel", "version": "0.0.0"},
+		{"name": "tokio", "version": "1.38.0", "source": "registry+https://github.com/rust-lang/crates.io-index", "checksum": "abcd"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseDpkgStatus(t *testing.T) {
+	got := parseDpkgStatus([]byte(`Package: libc6
+Status: install ok installed
+Version: 2.36-9
+Architecture: amd64
+Description: GNU C Library
+ multi-line description
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: tzdata
+Version: 2024a-0
+Architecture: all
+`))
+	want := []map[string]string{
+		{"Package": "libc6", "Status": "install ok installed", "Version": "2.36-9", "Architecture": "amd64", "Description": "GNU C Library"},
+		{"Package": "tzdata", "Version": "2024a-0", "Architecture": "all"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	root := &Package{ID: NewID("istio-release"), Name: "istio-release", Version: "1.2.3", Type: Application}
+	child := &Package{
+		ID:        NewID("file", "istio-1.2.3-linux-amd64.tar.gz"),
+		Name:      "istio-1.2.3-linux-amd64.tar.gz",
+		Type:      Archive,
+		Checksums: map[string]string{"SHA256": "abcd"},
+	}
+	doc := NewDocument("Istio Release 1.2.3", "https://example.com/releases/1.2.3/istio-release.spdx.json", root)
+	doc.Add(child)
+	doc.Relate(root.ID, Contains, child.ID)
+
+	by, err := doc.SPDX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spdx spdxDocument
+	if err := json.Unmarshal(by, &spdx); err != nil {
+		t.Fatal(err)
+	}
+	if spdx.SPDXVersion != "SPDX-2.3" || len(spdx.Packages) != 2 || len(spdx.Relationships) != 2 {
+		t.Fatalf("unexpected spdx document: %s", by)
+	}
+	if id := spdx.Packages[1].SPDXID; id != child.ID || !strings.HasPrefix(id, "SPDXRef-Package-file-istio-1.2.3-linux-amd64.tar.gz-") {
+		t.Fatalf("unexpected spdx id %v", spdx.Packages[1].SPDXID)
+	}
+
+	tv := string(doc.SPDXTagValue())
+	for _, want := range []string{
+		"SPDXVersion: SPDX-2.3\n",
+		"DocumentNamespace: https://example.com/releases/1.2.3/istio-release.spdx.json\n",
+		"PackageName: istio-1.2.3-linux-amd64.tar.gz\nSPDXID: " + child.ID + "\n",
+		"PackageChecksum: SHA256: abcd\n",
+		"Relationship: SPDXRef-DOCUMENT DESCRIBES " + root.ID + "\n",
+		"Relationship: " + root.ID + " CONTAINS " + child.ID + "\n",
+	} {
+		if !strings.Contains(tv, want) {
+			t.Fatalf("expected tag-value document to contain %q, got:\n%s", want, tv)
+		}
+	}
+
+	by, err = doc.CycloneDX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cdx cdxDocument
+	if err := json.Unmarshal(by, &cdx); err != nil {
+		t.Fatal(err)
+	}
+	if cdx.SpecVersion != "1.5" || cdx.Metadata.Component.Name != "istio-release" || len(cdx.Components) != 1 {
+		t.Fatalf("unexpected cyclonedx document: %s", by)
+	}
+	if got := cdx.Components[0].Hashes; len(got) != 1 || got[0].Alg != "SHA-256" {
+		t.Fatalf("unexpected hashes %v", got)
+	}
+	if got := cdx.Dependencies; len(got) != 1 || got[0].Ref != root.ID || got[0].DependsOn[0] != child.ID {
+		t.Fatalf("unexpected dependencies %v", got)
+	}
+}
+
+func TestNewID(t *testing.T) {
+	valid := regexp.MustCompile(`^SPDXRef-[a-zA-Z0-9.-]+$`)
+	ids := map[string][]string{}
+	for _, parts := range [][]string{
+		{"go", "github.com/foo/bar-baz", "v1.0.0"},
+		{"go", "github.com/foo-bar/baz", "v1.0.0"},
+		{"file", "a-b"},
+		{"file", "a", "b"},
+		{"file", "a/b"},
+	} {
+		id := NewID(parts...)
+		if !valid.MatchString(id) {
+			t.Fatalf("invalid spdx id %v for %v", id, parts)
+		}
+		if other, f := ids[id]; f {
+			t.Fatalf("%v and %v have the same id %v", parts, other, id)
+		}
+		ids[id] = parts
+		if again := NewID(parts...); again != id {
+			t.Fatalf("expected a stable id for %v, got %v and %v", parts, id, again)
+		}
+	}
+
+	// Packages whose names only differ in invalid characters are both kept.
+	doc := NewDocument("test", "https://example.com/test.spdx.json", &Package{ID: NewID("root"), Name: "root"})
+	doc.Add(
+		&Package{ID: NewID("go", "github.com/foo/bar-baz", "v1.0.0"), Name: "github.com/foo/bar-baz"},
+		&Package{ID: NewID("go", "github.com/foo-bar/baz", "v1.0.0"), Name: "github.com/foo-bar/baz"},
+	)
+	if len(doc.Packages) != 3 {
+		t.Fatalf("expected both modules to be kept, got %v packages", len(doc.Packages))
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// GoModules describes the module graph of the Go module in goModFile. The root module depends on every module
+// in its build list; since Go 1.17 go.mod lists all of these, including indirect dependencies.
+// Replaced modules are reported at their replacement.
+func GoModules(goModFile string) (*Document, error) {
+	by, err := os.ReadFile(goModFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v: %v", goModFile, err)
+	}
+	mf, err := modfile.Parse(goModFile, by, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", goModFile, err)
+	}
+	if mf.Module == nil {
+		return nil, fmt.Errorf("%v has no module directive", goModFile)
+	}
+	replaces := map[string]*modfile.Replace{}
+	for _, r := range mf.Replace {
+		replaces[r.Old.Path] = r
+	}
+
+	root := &Package{
+		ID:   NewID("go", mf.Module.Mod.Path),
+		Name: mf.Module.Mod.Path,
+		Type: Source,
+		PURL: "pkg:golang/" + mf.Module.Mod.Path,
+	}
+	doc := newFragment(root)
+	for _, req := range mf.Require {
+		mod := req.Mod
+		if r, f := replaces[mod.Path]; f {
+			if r.New.Version == "" {
+				// Replaced with a local directory, which is part of the source itself
+				continue
+			}
+			mod = r.New
+		}
+		p := &Package{
+			ID:      NewID("go", mod.Path, mod.Version),
+			Name:    mod.Path,
+			Version: mod.Version,
+			Type:    Library,
+			PURL:    fmt.Sprintf("pkg:golang/%s@%s", mod.Path, mod.Version),
+		}
+		doc.Add(p)
+		doc.Relate(root.ID, DependsOn, p.ID)
+	}
+	return doc, nil
+}
+
+// CargoPackages describes the crates in a Cargo.lock file. The root package, describing the workspace, depends on
+// every crate.
+func CargoPackages(name, cargoLock string) (*Document, error) {
+	by, err := os.ReadFile(cargoLock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v: %v", cargoLock, err)
+	}
+	root := &Package{
+		ID:   NewID("cargo", name),
+		Name: name,
+		Type: Source,
+	}
+	doc := newFragment(root)
+	for _, c := range parseCargoLock(by) {
+		if c["source"] == "" {
+			// Local workspace crates are part of the source itself
+			continue
+		}
+		p := &Package{
+			ID:      NewID("cargo", c["name"], c["version"]),
+			Name:    c["name"],
+			Version: c["version"],
+			Type:    Library,
+			PURL:    fmt.Sprintf("pkg:cargo/%s@%s", c["name"], c["version"]),
+		}
+		if strings.HasPrefix(c["source"], "registry+") {
+			p.DownloadLocation = fmt.Sprintf("https://crates.io/api/v1/crates/%s/%s/download", c["name"], c["version"])
+		}
+		if c["checksum"] != "" {
+			p.Checksums = map[string]string{"SHA256": c["checksum"]}
+		}
+		doc.Add(p)
+		doc.Relate(root.ID, DependsOn, p.ID)
+	}
+	return doc, nil
+}
+
+// parseCargoLock reads the string fields of each [[package]] table in a Cargo.lock. Cargo.lock is generated, so only
+// the simple `key = "value"` form needs to be handled; arrays such as dependencies are skipped.
+func parseCargoLock(by []byte) []map[string]string {
+	res := []map[string]string{}
+	var cur map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(by))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			cur = nil
+			if line == "[[package]]" {
+				cur = map[string]string{}
+				res = append(res, cur)
+			}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		key, value, f := strings.Cut(line, "=")
+		if !f {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) || len(value) < 2 {
+			continue
+		}
+		cur[strings.TrimSpace(key)] = value[1 : len(value)-1]
+	}
+	return res
+}
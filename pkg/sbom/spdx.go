@@ -0,0 +1,183 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Creator identifies this tool in generated documents.
+const Creator = "istio-release-builder"
+
+const (
+	spdxDocumentID = "SPDXRef-DOCUMENT"
+	noAssertion    = "NOASSERTION"
+)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	LicenseConcluded      string            `json:"licenseConcluded"`
+	LicenseDeclared       string            `json:"licenseDeclared"`
+	CopyrightText         string            `json:"copyrightText"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SpdxElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+// SPDX serializes the document as SPDX 2.3 JSON.
+func (d *Document) SPDX() ([]byte, error) {
+	return json.MarshalIndent(d.spdxDocument(), "", "  ")
+}
+
+// SPDXTagValue serializes the document in the SPDX 2.3 tag-value format, as written by the bom tool for earlier
+// releases.
+func (d *Document) SPDXTagValue() []byte {
+	doc := d.spdxDocument()
+	sb := &strings.Builder{}
+	tag := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(sb, "%s: %s\n", name, value)
+		}
+	}
+	tag("SPDXVersion", doc.SPDXVersion)
+	tag("DataLicense", doc.DataLicense)
+	tag("SPDXID", doc.SPDXID)
+	tag("DocumentName", doc.Name)
+	tag("DocumentNamespace", doc.DocumentNamespace)
+	for _, c := range doc.CreationInfo.Creators {
+		tag("Creator", c)
+	}
+	tag("Created", doc.CreationInfo.Created)
+	for _, p := range doc.Packages {
+		fmt.Fprintf(sb, "\n##### Package: %s\n\n", p.Name)
+		tag("PackageName", p.Name)
+		tag("SPDXID", p.SPDXID)
+		tag("PackageVersion", p.VersionInfo)
+		tag("PackageDownloadLocation", p.DownloadLocation)
+		tag("FilesAnalyzed", strconv.FormatBool(p.FilesAnalyzed))
+		for _, c := range p.Checksums {
+			tag("PackageChecksum", c.Algorithm+": "+c.ChecksumValue)
+		}
+		tag("PackageLicenseConcluded", p.LicenseConcluded)
+		tag("PackageLicenseDeclared", p.LicenseDeclared)
+		tag("PackageCopyrightText", p.CopyrightText)
+		tag("PrimaryPackagePurpose", p.PrimaryPackagePurpose)
+		for _, r := range p.ExternalRefs {
+			tag("ExternalRef", strings.Join([]string{r.ReferenceCategory, r.ReferenceType, r.ReferenceLocator}, " "))
+		}
+	}
+	sb.WriteString("\n")
+	for _, r := range doc.Relationships {
+		tag("Relationship", strings.Join([]string{r.SpdxElementID, r.RelationshipType, r.RelatedSpdxElement}, " "))
+	}
+	return []byte(sb.String())
+}
+
+func (d *Document) spdxDocument() spdxDocument {
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            spdxDocumentID,
+		Name:              d.Name,
+		DocumentNamespace: d.Namespace,
+		CreationInfo: spdxCreationInfo{
+			Created:  d.Created.Format(time.RFC3339),
+			Creators: []string{"Tool: " + Creator},
+		},
+		Packages: []spdxPackage{},
+		Relationships: []spdxRelationship{{
+			SpdxElementID:      spdxDocumentID,
+			RelationshipType:   "DESCRIBES",
+			RelatedSpdxElement: d.Describes,
+		}},
+	}
+	for _, p := range d.Packages {
+		sp := spdxPackage{
+			SPDXID:                p.ID,
+			Name:                  p.Name,
+			VersionInfo:           p.Version,
+			DownloadLocation:      noAssertion,
+			LicenseConcluded:      noAssertion,
+			LicenseDeclared:       noAssertion,
+			CopyrightText:         noAssertion,
+			PrimaryPackagePurpose: strings.ToUpper(string(p.Type)),
+		}
+		if p.DownloadLocation != "" {
+			sp.DownloadLocation = p.DownloadLocation
+		}
+		for _, alg := range slices.Sorted(maps.Keys(p.Checksums)) {
+			sp.Checksums = append(sp.Checksums, spdxChecksum{Algorithm: alg, ChecksumValue: p.Checksums[alg]})
+		}
+		if p.PURL != "" {
+			sp.ExternalRefs = append(sp.ExternalRefs, spdxExternalRef{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  p.PURL,
+			})
+		}
+		doc.Packages = append(doc.Packages, sp)
+	}
+	for _, r := range d.Relationships {
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SpdxElementID:      r.From,
+			RelationshipType:   string(r.Type),
+			RelatedSpdxElement: r.To,
+		})
+	}
+	return doc
+}
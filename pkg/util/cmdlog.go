@@ -0,0 +1,286 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Command is a command started by VerboseCommand. Its output is also captured to the stage log, if enabled, however
+// it is run: Run, Output, CombinedOutput, or Start and Wait.
+type Command struct {
+	*exec.Cmd
+	log *commandLog
+}
+
+// commandLog is the log of a started command.
+type commandLog struct {
+	file    string
+	partial *os.File
+	start   time.Time
+}
+
+var commandLogs = struct {
+	sync.Mutex
+	dir   string
+	stage string
+	count map[string]int
+}{count: map[string]int{}}
+
+// EnableCommandLogs captures the output of every command run with VerboseCommand into
+// <dir>/<stage>/<n>-<command>.log, in addition to streaming it to stdout and stderr.
+func EnableCommandLogs(dir string) {
+	commandLogs.Lock()
+	defer commandLogs.Unlock()
+	commandLogs.dir = dir
+}
+
+// SetLogStage sets the stage that following commands are logged under.
+func SetLogStage(stage string) {
+	commandLogs.Lock()
+	defer commandLogs.Unlock()
+	commandLogs.stage = stage
+}
+
+var unsafeLogName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// nextCommandLog returns the log file of the next command in the current stage, or false if logs are disabled.
+func nextCommandLog(name string) (string, bool) {
+	commandLogs.Lock()
+	defer commandLogs.Unlock()
+	if commandLogs.dir == "" {
+		return "", false
+	}
+	stage := commandLogs.stage
+	if stage == "" {
+		stage = "other"
+	}
+	commandLogs.count[stage]++
+	file := fmt.Sprintf("%03d-%s.log", commandLogs.count[stage], unsafeLogName.ReplaceAllString(filepath.Base(name), "_"))
+	return filepath.Join(commandLogs.dir, stage, file), true
+}
+
+// Run runs the command. When logs are enabled, the output is written to the command's log file, below a header
+// with the command line, working directory, environment changes, exit code and duration.
+func (c *Command) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Start starts the command, capturing its output to the command's log file when logs are enabled.
+func (c *Command) Start() error {
+	file, f := nextCommandLog(c.Args[0])
+	if !f {
+		return c.Cmd.Start()
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o750); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+	// Output is streamed to a partial log while the command runs, so it is available even if the build is
+	// killed, then placed below the header once the command exits.
+	partial, err := os.Create(file + ".partial")
+	if err != nil {
+		return fmt.Errorf("failed to create command log: %v", err)
+	}
+	w := &lockedWriter{w: partial}
+	c.Stdout = teeWriter(c.Stdout, w)
+	c.Stderr = teeWriter(c.Stderr, w)
+	c.log = &commandLog{file: file, partial: partial, start: time.Now()}
+	if err := c.Cmd.Start(); err != nil {
+		return c.finishLog(err)
+	}
+	return nil
+}
+
+// Wait waits for the command to exit, then completes its log file.
+func (c *Command) Wait() error {
+	return c.finishLog(c.Cmd.Wait())
+}
+
+// Output runs the command and returns its standard output, like exec.Cmd.Output.
+func (c *Command) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	stdout := &bytes.Buffer{}
+	c.Stdout = stdout
+	var stderr *bytes.Buffer
+	if c.Stderr == nil {
+		stderr = &bytes.Buffer{}
+		c.Stderr = stderr
+	}
+	err := c.Run()
+	var exitErr *exec.ExitError
+	if stderr != nil && errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard output and standard error, like
+// exec.Cmd.CombinedOutput.
+func (c *Command) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	out := &bytes.Buffer{}
+	w := &lockedWriter{w: out}
+	c.Stdout = w
+	c.Stderr = w
+	err := c.Run()
+	return out.Bytes(), err
+}
+
+// finishLog writes the log file of a command that exited with runErr, or failed to start. runErr is returned as is.
+func (c *Command) finishLog(runErr error) error {
+	l := c.log
+	if l == nil {
+		return runErr
+	}
+	c.log = nil
+	defer l.partial.Close()
+	duration := time.Since(l.start)
+
+	exitCode := 0
+	if runErr != nil {
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "# command: %s\n", strings.Join(redactArgs(c.Args), " "))
+	fmt.Fprintf(sb, "# dir: %s\n", c.Dir)
+	for _, e := range envDelta(os.Environ(), c.Env) {
+		fmt.Fprintf(sb, "# env: %s\n", e)
+	}
+	fmt.Fprintf(sb, "# exit code: %d\n", exitCode)
+	fmt.Fprintf(sb, "# duration: %v\n\n", duration.Round(time.Millisecond))
+	if err := writeCommandLog(l.file, sb.String(), l.partial); err != nil {
+		return fmt.Errorf("failed to write command log: %v", err)
+	}
+	_ = os.Remove(l.partial.Name())
+	return runErr
+}
+
+func writeCommandLog(file, header string, output *os.File) error {
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(f, output)
+	return err
+}
+
+func teeWriter(w io.Writer, log io.Writer) io.Writer {
+	if w == nil {
+		return log
+	}
+	return io.MultiWriter(w, log)
+}
+
+// lockedWriter serializes writes from the stdout and stderr of a command into the same log.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// secretName matches environment variables and flags whose values are not written to logs.
+var secretName = regexp.MustCompile(`(?i)token|secret|password|passphrase|credential|key`)
+
+// redactArgs hides the values of secret flags, given as `--flag=value` or `--flag value`.
+func redactArgs(args []string) []string {
+	res := slices.Clone(args)
+	for i := 1; i < len(res); i++ {
+		if !strings.HasPrefix(res[i], "-") {
+			continue
+		}
+		flag, _, hasValue := strings.Cut(res[i], "=")
+		if !secretName.MatchString(flag) {
+			continue
+		}
+		if hasValue {
+			res[i] = flag + "=<redacted>"
+		} else if i+1 < len(res) && !strings.HasPrefix(res[i+1], "-") {
+			res[i+1] = "<redacted>"
+			i++
+		}
+	}
+	return res
+}
+
+// envDelta describes how the environment of a command differs from the inherited environment: variables that are
+// added or changed as `+KEY=value`, and removed variables as `-KEY`. A nil environment is inherited unchanged.
+func envDelta(base, env []string) []string {
+	if env == nil {
+		return nil
+	}
+	toMap := func(env []string) map[string]string {
+		m := map[string]string{}
+		for _, e := range env {
+			k, v, _ := strings.Cut(e, "=")
+			m[k] = v
+		}
+		return m
+	}
+	baseEnv, cmdEnv := toMap(base), toMap(env)
+	res := []string{}
+	for k, v := range cmdEnv {
+		if bv, f := baseEnv[k]; f && bv == v {
+			continue
+		}
+		if secretName.MatchString(k) {
+			v = "<redacted>"
+		}
+		res = append(res, "+"+k+"="+v)
+	}
+	for k := range baseEnv {
+		if _, f := cmdEnv[k]; !f {
+			res = append(res, "-"+k)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
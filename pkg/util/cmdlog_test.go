@@ -0,0 +1,116 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandLogs(t *testing.T) {
+	dir := t.TempDir()
+	EnableCommandLogs(dir)
+	defer EnableCommandLogs("")
+	SetLogStage("test")
+	defer SetLogStage("")
+
+	cmd := VerboseCommand("sh", "-c", "echo out; echo err >&2; exit 3")
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	cmd.Env = append(os.Environ(), "EXTRA=1", "GITHUB_TOKEN=hunter2")
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected command to fail")
+	}
+	by, err := os.ReadFile(filepath.Join(dir, "test", "001-sh.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(by)
+	for _, want := range []string{
+		"# command: sh -c echo out; echo err >&2; exit 3\n",
+		"# env: +EXTRA=1\n",
+		"# env: +GITHUB_TOKEN=<redacted>\n",
+		"# exit code: 3\n",
+		"# duration: ",
+		"out\n",
+		"err\n",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log missing %q:\n%s", want, log)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test", "001-sh.log.partial")); !os.IsNotExist(err) {
+		t.Errorf("expected partial log to be removed: %v", err)
+	}
+}
+
+func TestCommandLogsOutput(t *testing.T) {
+	dir := t.TempDir()
+	EnableCommandLogs(dir)
+	defer EnableCommandLogs("")
+	SetLogStage("output")
+	defer SetLogStage("")
+
+	cmd := VerboseCommand("sh", "-c", "echo out; echo err >&2", "sh", "--token", "hunter2")
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "out\n" {
+		t.Fatalf("expected stdout only, got %q", out)
+	}
+	cmd = VerboseCommand("sh", "-c", "echo out; echo err >&2")
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	if out, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(out), "err") {
+		t.Fatalf("expected combined output, got %q: %v", out, err)
+	}
+	for file, want := range map[string]string{
+		"001-sh.log": "# command: sh -c echo out; echo err >&2 sh --token <redacted>\n",
+		"002-sh.log": "err\n",
+	} {
+		by, err := os.ReadFile(filepath.Join(dir, "output", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(by), want) || !strings.Contains(string(by), "out\n") {
+			t.Errorf("log %v missing %q:\n%s", file, want, by)
+		}
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	got := redactArgs([]string{"tool", "--password=hunter2", "--token", "abc", "--verbose", "--api-key", "--other", "value"})
+	want := []string{"tool", "--password=<redacted>", "--token", "<redacted>", "--verbose", "--api-key", "--other", "value"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestEnvDelta(t *testing.T) {
+	got := envDelta([]string{"A=1", "B=2", "C=3"}, []string{"A=1", "B=changed", "D=4"})
+	want := []string{"+B=changed", "+D=4", "-C"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := envDelta([]string{"A=1"}, nil); got != nil {
+		t.Fatalf("expected inherited environment to have no delta, got %v", got)
+	}
+}
@@ -36,12 +36,12 @@ import (
 )
 
 // VerboseCommand runs a command, outputting stderr and stdout
-func VerboseCommand(name string, arg ...string) *exec.Cmd {
-	log.Infof("Running command: %v %v", name, strings.Join(arg, " "))
+func VerboseCommand(name string, arg ...string) *Command {
+	log.Infof("Running command: %v %v", name, strings.Join(redactArgs(append([]string{name}, arg...))[1:], " "))
 	cmd := exec.Command(name, arg...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
-	return cmd
+	return &Command{Cmd: cmd}
 }
 
 // RunWithOutput runs a command, outputting stderr and stdout, and returning the command's stdout
@@ -0,0 +1,203 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+const (
+	// ImageRefNameAnnotation is the standard OCI annotation holding the tag of an entry in an image layout index.
+	ImageRefNameAnnotation = "org.opencontainers.image.ref.name"
+	// ImageVariantAnnotation records the variant (eg, distroless) of each entry in the index of an OCI image layout.
+	ImageVariantAnnotation = "io.istio.image.variant"
+)
+
+// knownArchitectures are architectures images may be built for. An image name ending in one of these, once the
+// architectures of the release are parsed, was built for an architecture the release does not include.
+var knownArchitectures = []string{"amd64", "arm64", "arm", "386", "ppc64le", "s390x", "riscv64", "mips64le", "loong64"}
+
+// ImageNameVariant determines the name of the image (eg, pilot), variant (eg, distroless) and architecture.
+// This is derived from the file name of a docker archive, such as pilot-distroless-arm64.tar.gz, matched against the
+// architectures and variants of the release. The architecture of amd64 images, which are not suffixed, is empty.
+// File names which do not match an architecture of the release are an error.
+func ImageNameVariant(manifest model.Manifest, fname string) (name string, variant string, arch string, err error) {
+	imageName, _, _ := strings.Cut(fname, ".")
+	amd64 := false
+	for _, plat := range manifest.Architectures {
+		_, a, _ := strings.Cut(plat, "/")
+		if a == "amd64" {
+			amd64 = true
+			continue
+		}
+		if n, f := strings.CutSuffix(imageName, "-"+a); f {
+			imageName, arch = n, a
+			break
+		}
+	}
+	if arch == "" && !amd64 {
+		return "", "", "", fmt.Errorf("image %v does not match any architecture of %v", fname, manifest.Architectures)
+	}
+	for _, v := range manifest.Variants {
+		if n, f := strings.CutSuffix(imageName, "-"+v); f {
+			imageName, variant = n, v
+			break
+		}
+	}
+	if imageName == "" {
+		return "", "", "", fmt.Errorf("image %v has no name", fname)
+	}
+	if i := strings.LastIndex(imageName, "-"); i >= 0 && slices.Contains(knownArchitectures, imageName[i+1:]) {
+		return "", "", "", fmt.Errorf("image %v is for architecture %v, which is not one of %v (or is not the last suffix)",
+			fname, imageName[i+1:], manifest.Architectures)
+	}
+	return imageName, variant, arch, nil
+}
+
+// ImageArchiveName is the inverse of ImageNameVariant, returning the base name (without extension) used for
+// files describing a single image. amd64 images are not suffixed.
+func ImageArchiveName(name, variant, arch string) string {
+	res := name
+	if variant != "" {
+		res += "-" + variant
+	}
+	if arch != "" && arch != "amd64" {
+		res += "-" + arch
+	}
+	return res
+}
+
+// ImageFromArchive reads the single image in a `docker save` archive, which may be gzip compressed.
+func ImageFromArchive(archive string) (v1.Image, error) {
+	img, err := tarball.Image(func() (io.ReadCloser, error) {
+		f, err := os.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+		br := bufio.NewReader(f)
+		if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+			return readCloser{Reader: br, closer: f}, nil
+		}
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return readCloser{Reader: gz, closer: f}, nil
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image from %v: %v", archive, err)
+	}
+	return img, nil
+}
+
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.closer.Close()
+}
+
+// PlatformIndex builds an index of the given media type from single architecture images, using the platform from each
+// image config. Images pushed to registries use a docker manifest list, while OCI image layouts need an OCI image index.
+func PlatformIndex(images []v1.Image, mediaType types.MediaType) (v1.ImageIndex, error) {
+	var index v1.ImageIndex = empty.Index
+	index = mutate.IndexMediaType(index, mediaType)
+	for _, img := range images {
+		mt, err := img.MediaType()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get mediatype: %w", err)
+		}
+
+		h, err := img.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute digest: %w", err)
+		}
+
+		size, err := img.Size()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute size: %w", err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config file: %w", err)
+		}
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				MediaType: mt,
+				Size:      size,
+				Digest:    h,
+				Platform: &v1.Platform{
+					Architecture: cfg.Architecture,
+					OS:           cfg.OS,
+					OSVersion:    cfg.OSVersion,
+					Variant:      cfg.Variant,
+					Features:     nil,
+				},
+			},
+		})
+	}
+	return index, nil
+}
+
+// LayoutImages returns the single architecture images in an OCI image layout written by the build, by variant.
+func LayoutImages(dir string) (map[string][]v1.Image, error) {
+	index, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image layout %v: %v", dir, err)
+	}
+	im, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index of %v: %v", dir, err)
+	}
+	images := map[string][]v1.Image{}
+	for _, desc := range im.Manifests {
+		variant := desc.Annotations[ImageVariantAnnotation]
+		variantIndex, err := index.ImageIndex(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read variant %q of %v: %v", variant, dir, err)
+		}
+		vim, err := variantIndex.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read variant %q of %v: %v", variant, dir, err)
+		}
+		for _, m := range vim.Manifests {
+			img, err := variantIndex.Image(m.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image %v of %v: %v", m.Digest, dir, err)
+			}
+			images[variant] = append(images[variant], img)
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,113 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+func TestImageNameVariant(t *testing.T) {
+	manifest := model.Manifest{
+		Architectures: []string{"linux/amd64", "linux/arm64", "linux/ppc64le", "linux/s390x"},
+		Variants:      []string{"debug", "distroless"},
+	}
+	cases := []struct {
+		file    string
+		name    string
+		variant string
+		arch    string
+		err     bool
+	}{
+		{file: "pilot.tar.gz", name: "pilot"},
+		{file: "pilot-distroless.tar.gz", name: "pilot", variant: "distroless"},
+		{file: "install-cni-debug-arm64.tar.gz", name: "install-cni", variant: "debug", arch: "arm64"},
+		{file: "proxyv2-distroless-ppc64le.tar.gz", name: "proxyv2", variant: "distroless", arch: "ppc64le"},
+		{file: "ztunnel-s390x.tar.gz", name: "ztunnel", arch: "s390x"},
+		// Not an architecture of the release
+		{file: "pilot-riscv64.tar.gz", err: true},
+		// Architecture before the variant
+		{file: "pilot-arm64-distroless.tar.gz", err: true},
+		// amd64 is never suffixed
+		{file: "pilot-amd64.tar.gz", err: true},
+		{file: "-debug.tar.gz", err: true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.file, func(t *testing.T) {
+			name, variant, arch, err := ImageNameVariant(manifest, tt.file)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v %v %v", name, variant, arch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.name || variant != tt.variant || arch != tt.arch {
+				t.Fatalf("expected %v %v %v, got %v %v %v", tt.name, tt.variant, tt.arch, name, variant, arch)
+			}
+			if got := ImageArchiveName(name, variant, arch) + ".tar.gz"; got != tt.file {
+				t.Fatalf("expected archive name %v, got %v", tt.file, got)
+			}
+		})
+	}
+
+	// Without amd64, unsuffixed images are not part of the release.
+	if _, _, _, err := ImageNameVariant(model.Manifest{Architectures: []string{"linux/arm64"}}, "pilot.tar.gz"); err == nil {
+		t.Fatal("expected error for amd64 image")
+	}
+}
+
+func TestPlatformIndex(t *testing.T) {
+	var images []v1.Image
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.OS, cfg.Architecture = "linux", arch
+		if img, err = mutate.ConfigFile(img, cfg); err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, img)
+	}
+	for _, mt := range []types.MediaType{types.DockerManifestList, types.OCIImageIndex} {
+		index, err := PlatformIndex(images, mt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		im, err := index.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if im.MediaType != mt {
+			t.Fatalf("expected media type %v, got %v", mt, im.MediaType)
+		}
+		if len(im.Manifests) != 2 || im.Manifests[1].Platform == nil || im.Manifests[1].Platform.Architecture != "arm64" {
+			t.Fatalf("expected an amd64 and arm64 image, got %+v", im.Manifests)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// makefileAssignment matches a simple variable assignment, such as `export BASE_VERSION ?= 1.0 # comment`.
+// Groups: prefix (up to and including the operator and spacing), value, and trailing comment.
+var makefileAssignment = regexp.MustCompile(`^(\s*(?:export\s+|override\s+)?([A-Za-z0-9_.-]+)\s*(?:\?=|:=|::=|=)\s*)(.*?)(\s*#.*)?$`)
+
+// MakefileVariable is an assignment of a variable in a Makefile.
+type MakefileVariable struct {
+	// Line is the index of the line holding the assignment.
+	Line  int
+	Value string
+
+	prefix  string
+	comment string
+}
+
+// FindMakefileVariable returns the first assignment of a variable in the Makefile contents.
+func FindMakefileVariable(contents, name string) (MakefileVariable, error) {
+	for i, line := range strings.Split(contents, "\n") {
+		m := makefileAssignment.FindStringSubmatch(line)
+		if m == nil || m[2] != name {
+			continue
+		}
+		return MakefileVariable{Line: i, Value: strings.TrimSpace(m[3]), prefix: m[1], comment: m[4]}, nil
+	}
+	return MakefileVariable{}, fmt.Errorf("variable %v not found", name)
+}
+
+// SetMakefileVariable returns the Makefile contents with the first assignment of a variable updated to value.
+// The assignment operator and any trailing comment are preserved.
+func SetMakefileVariable(contents, name, value string) (string, error) {
+	v, err := FindMakefileVariable(contents, name)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(contents, "\n")
+	lines[v.Line] = v.prefix + value + v.comment
+	return strings.Join(lines, "\n"), nil
+}
+
+// ReadMakefileVariable reads the value of a variable assigned in a Makefile.
+func ReadMakefileVariable(file, name string) (string, error) {
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	v, err := FindMakefileVariable(string(by), name)
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", file, err)
+	}
+	return v.Value, nil
+}
+
+// WriteMakefileVariable updates the value of a variable assigned in a Makefile.
+func WriteMakefileVariable(file, name, value string) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	updated, err := SetMakefileVariable(string(by), name, value)
+	if err != nil {
+		return fmt.Errorf("%v: %v", file, err)
+	}
+	return os.WriteFile(file, []byte(updated), fi.Mode().Perm())
+}
@@ -0,0 +1,53 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+)
+
+func TestMakefileVariable(t *testing.T) {
+	makefile := `# Base images
+BASE_VERSION_SUFFIX := foo
+export  BASE_VERSION   ?=  1.27-2025-01-01T00-00-00 # updated automatically
+
+build: BASE_VERSION=ignored
+`
+	v, err := FindMakefileVariable(makefile, "BASE_VERSION")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != "1.27-2025-01-01T00-00-00" || v.Line != 2 {
+		t.Fatalf("unexpected variable %+v", v)
+	}
+
+	updated, err := SetMakefileVariable(makefile, "BASE_VERSION", "1.28-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Base images
+BASE_VERSION_SUFFIX := foo
+export  BASE_VERSION   ?=  1.28-new # updated automatically
+
+build: BASE_VERSION=ignored
+`
+	if updated != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", updated, want)
+	}
+
+	if _, err := FindMakefileVariable(makefile, "MISSING"); err == nil {
+		t.Fatal("expected error for missing variable")
+	}
+}
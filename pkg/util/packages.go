@@ -0,0 +1,34 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "fmt"
+
+// SidecarPackageName returns the file name of the sidecar package for an architecture, such as
+// istio-sidecar-arm64.rpm. amd64 keeps the historical unsuffixed name.
+func SidecarPackageName(arch, ext string) string {
+	if arch == "amd64" {
+		return "istio-sidecar." + ext
+	}
+	return fmt.Sprintf("istio-sidecar-%s.%s", arch, ext)
+}
+
+// RpmArchitectures maps Go architectures to the names used by RPM.
+var RpmArchitectures = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
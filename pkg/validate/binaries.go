@@ -0,0 +1,251 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"debug/buildinfo"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// platform is the target of a binary, using Go's GOOS and GOARCH names.
+type platform struct {
+	os   string
+	arch string
+}
+
+func (p platform) String() string {
+	return p.os + "/" + p.arch
+}
+
+// archivePlatform returns the platform of an archive name suffix, such as linux-armv7, osx or win-amd64.
+// Archives without an architecture are amd64.
+func archivePlatform(name string) (platform, error) {
+	osName, arch, _ := strings.Cut(name, "-")
+	p := platform{arch: "amd64"}
+	switch osName {
+	case "linux":
+		p.os = "linux"
+	case "osx":
+		p.os = "darwin"
+	case "win":
+		p.os = "windows"
+	default:
+		return p, fmt.Errorf("unknown os %q in %v", osName, name)
+	}
+	switch arch {
+	case "", "amd64":
+	case "arm64":
+		p.arch = "arm64"
+	case "armv7":
+		p.arch = "arm"
+	default:
+		return p, fmt.Errorf("unknown architecture %q in %v", arch, name)
+	}
+	return p, nil
+}
+
+// binaryPlatform reads the platform a binary was built for from its ELF, Mach-O or PE headers.
+func binaryPlatform(r io.ReaderAt) (platform, error) {
+	if f, err := elf.NewFile(r); err == nil {
+		p := platform{os: "linux"}
+		switch f.Machine {
+		case elf.EM_X86_64:
+			p.arch = "amd64"
+		case elf.EM_AARCH64:
+			p.arch = "arm64"
+		case elf.EM_ARM:
+			p.arch = "arm"
+		case elf.EM_386:
+			p.arch = "386"
+		default:
+			return p, fmt.Errorf("unknown ELF machine %v", f.Machine)
+		}
+		return p, nil
+	}
+	if f, err := macho.NewFile(r); err == nil {
+		p := platform{os: "darwin"}
+		switch f.Cpu {
+		case macho.CpuAmd64:
+			p.arch = "amd64"
+		case macho.CpuArm64:
+			p.arch = "arm64"
+		default:
+			return p, fmt.Errorf("unknown Mach-O cpu %v", f.Cpu)
+		}
+		return p, nil
+	}
+	if f, err := pe.NewFile(r); err == nil {
+		p := platform{os: "windows"}
+		switch f.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			p.arch = "amd64"
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			p.arch = "arm64"
+		case pe.IMAGE_FILE_MACHINE_I386:
+			p.arch = "386"
+		default:
+			return p, fmt.Errorf("unknown PE machine %#x", f.Machine)
+		}
+		return p, nil
+	}
+	return platform{}, fmt.Errorf("not an ELF, Mach-O or PE binary")
+}
+
+// verifyBinary checks the binary is built for the expected platform, from the expected istio revision.
+func verifyBinary(r io.ReaderAt, want platform, revision string) error {
+	got, err := binaryPlatform(r)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("expected binary for %v, got %v", want, got)
+	}
+	info, err := buildinfo.Read(r)
+	if err != nil {
+		return fmt.Errorf("failed to read build info: %v", err)
+	}
+	for _, s := range info.Settings {
+		if s.Key != "vcs.revision" {
+			continue
+		}
+		if s.Value != revision {
+			return fmt.Errorf("expected vcs revision %v, got %v", revision, s.Value)
+		}
+		return nil
+	}
+	return fmt.Errorf("no vcs revision in build info")
+}
+
+// TestIstioctlBinaries verifies the istioctl binary of every release and stand-alone istioctl archive is built
+// for the platform in the archive name, from the istio revision in the manifest. The binaries are inspected
+// rather than executed, so all platforms can be checked from any host.
+func TestIstioctlBinaries(r ReleaseInfo) error {
+	istio := r.manifest.Dependencies.Istio
+	if istio == nil || istio.Sha == "" {
+		return fmt.Errorf("manifest has no istio sha")
+	}
+	revision := istio.Sha
+	entries, err := os.ReadDir(r.release)
+	if err != nil {
+		return err
+	}
+	checked := 0
+	for _, e := range entries {
+		name := e.Name()
+		var ext string
+		switch {
+		case strings.HasSuffix(name, ".tar.gz"):
+			ext = ".tar.gz"
+		case strings.HasSuffix(name, ".zip"):
+			ext = ".zip"
+		default:
+			continue
+		}
+		var suffix string
+		if s, f := strings.CutPrefix(name, fmt.Sprintf("istio-%s-", r.manifest.Version)); f {
+			suffix = s
+		} else if s, f := strings.CutPrefix(name, fmt.Sprintf("istioctl-%s-", r.manifest.Version)); f {
+			suffix = s
+		} else {
+			continue
+		}
+		want, err := archivePlatform(strings.TrimSuffix(suffix, ext))
+		if err != nil {
+			return err
+		}
+		binary, err := readIstioctl(filepath.Join(r.release, name))
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		if err := verifyBinary(bytes.NewReader(binary), want, revision); err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("no istioctl archives found")
+	}
+	return nil
+}
+
+// isIstioctl returns true if an archive entry is the istioctl binary, either at the root of a stand-alone
+// archive or in the bin directory of a release archive.
+func isIstioctl(name string) bool {
+	base := path.Base(name)
+	if base != "istioctl" && base != "istioctl.exe" {
+		return false
+	}
+	dir := path.Dir(path.Clean(name))
+	return dir == "." || path.Base(dir) == "bin"
+}
+
+// readIstioctl reads the istioctl binary from a tar.gz or zip archive.
+func readIstioctl(archive string) ([]byte, error) {
+	if strings.HasSuffix(archive, ".zip") {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if !isIstioctl(f.Name) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+		return nil, fmt.Errorf("istioctl not found")
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("istioctl not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && isIstioctl(hdr.Name) {
+			return io.ReadAll(tr)
+		}
+	}
+}
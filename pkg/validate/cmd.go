@@ -24,7 +24,8 @@ import (
 
 var (
 	flags = struct {
-		release string
+		release      string
+		gpgPublicKey string
 	}{}
 
 	validateCmd = &cobra.Command{
@@ -33,7 +34,7 @@ var (
 		SilenceUsage: true,
 		Args:         cobra.ExactArgs(0),
 		RunE: func(c *cobra.Command, _ []string) error {
-			passed, info, failed := CheckRelease(flags.release)
+			passed, info, failed := CheckRelease(flags.release, flags.gpgPublicKey)
 			for _, pass := range passed {
 				log.Infof("Check passed: %v", pass)
 			}
@@ -53,6 +54,8 @@ var (
 func init() {
 	validateCmd.PersistentFlags().StringVar(&flags.release, "release", flags.release,
 		"The release to validate.")
+	validateCmd.PersistentFlags().StringVar(&flags.gpgPublicKey, "gpgpublickey", flags.gpgPublicKey,
+		"The file containing an armored GPG public key. When set, the deb and rpm packages must be signed with it.")
 }
 
 func GetValidateCommand() *cobra.Command {
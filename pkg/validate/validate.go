@@ -16,7 +16,11 @@ package validate
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"os"
 	"path"
@@ -25,6 +29,10 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"sigs.k8s.io/yaml"
 
 	"istio.io/istio/pkg/log"
@@ -33,7 +41,7 @@ import (
 	"istio.io/release-builder/pkg/util"
 )
 
-func NewReleaseInfo(release string) ReleaseInfo {
+func NewReleaseInfo(release string, gpgPublicKey string) ReleaseInfo {
 	tmpDir, err := os.MkdirTemp("/tmp", "release-test")
 	if err != nil {
 		panic(err)
@@ -54,6 +62,8 @@ func NewReleaseInfo(release string) ReleaseInfo {
 		manifest: manifest,
 		archive:  filepath.Join(tmpDir, "istio-"+manifest.Version),
 		release:  release,
+
+		gpgPublicKey: gpgPublicKey,
 	}
 }
 
@@ -64,16 +74,20 @@ type ReleaseInfo struct {
 	manifest model.Manifest
 	archive  string
 	release  string
+
+	// gpgPublicKey, if set, is the armored public key the deb and rpm packages must be signed with.
+	gpgPublicKey string
 }
 
-func CheckRelease(release string) ([]string, string, []error) {
+func CheckRelease(release string, gpgPublicKey string) ([]string, string, []error) {
 	if release == "" {
 		return nil, "", []error{fmt.Errorf("--release must be passed")}
 	}
-	r := NewReleaseInfo(release)
+	r := NewReleaseInfo(release, gpgPublicKey)
 	checks := map[string]ValidationFunction{
 		"IstioctlArchive":    TestIstioctlArchive,
 		"IstioctlStandalone": TestIstioctlStandalone,
+		"IstioctlBinaries":   TestIstioctlBinaries,
 		"TestDocker":         TestDocker,
 		"HelmVersionsIstio":  TestHelmVersionsIstio,
 		"HelmChartVersions":  TestHelmChartVersions,
@@ -85,6 +99,8 @@ func CheckRelease(release string) ([]string, string, []error) {
 		"ProxyVersion":       TestProxyVersion,
 		"Debian":             TestDebian,
 		"Rpm":                TestRpm,
+		"PackageRepository":  TestPackageRepository,
+		"ImageDigests":       TestImageDigests,
 	}
 	var errors []error
 	var success []string
@@ -221,6 +237,9 @@ func TestDocker(r ReleaseInfo) error {
 		"proxyv2-debug",
 		"proxyv2-distroless",
 	}
+	if r.manifest.DockerOutput == model.DockerOutputOCI {
+		return validateImageLayouts(r, expected)
+	}
 	found := map[string]struct{}{}
 	d, err := os.ReadDir(filepath.Join(r.release, "docker"))
 	if err != nil {
@@ -245,6 +264,69 @@ func TestDocker(r ReleaseInfo) error {
 	return nil
 }
 
+// validateImageLayouts checks each expected image variant is present in the OCI image layouts, for all architectures.
+func validateImageLayouts(r ReleaseInfo, expected []string) error {
+	for _, i := range expected {
+		imageName, variant, _, err := util.ImageNameVariant(r.manifest, i)
+		if err != nil {
+			return err
+		}
+		images, err := util.LayoutImages(filepath.Join(r.release, "docker", imageName))
+		if err != nil {
+			return err
+		}
+		found := map[string]struct{}{}
+		for _, img := range images[variant] {
+			cfg, err := img.ConfigFile()
+			if err != nil {
+				return fmt.Errorf("failed to read config of %v: %v", i, err)
+			}
+			found[cfg.OS+"/"+cfg.Architecture] = struct{}{}
+		}
+		for _, plat := range r.manifest.Architectures {
+			if _, f := found[plat]; !f {
+				return fmt.Errorf("expected docker image %v for %v, but had %v", i, plat, found)
+			}
+		}
+	}
+	return nil
+}
+
+// TestImageDigests checks the registries still serve every digest in the image lockfile written by publish.
+// Releases that have not been published have no lockfile.
+func TestImageDigests(r ReleaseInfo) error {
+	by, err := os.ReadFile(filepath.Join(r.release, "images.yaml"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	lockfile := model.ImageLockfile{}
+	if err := yaml.Unmarshal(by, &lockfile); err != nil {
+		return fmt.Errorf("failed to parse images.yaml: %v", err)
+	}
+	if lockfile.Version != r.manifest.Version {
+		return fmt.Errorf("images.yaml is for version %v, expected %v", lockfile.Version, r.manifest.Version)
+	}
+	for _, img := range lockfile.Images {
+		ref, err := name.ParseReference(img.Reference)
+		if err != nil {
+			return fmt.Errorf("invalid image reference %v: %v", img.Reference, err)
+		}
+		digests := []string{img.Digest}
+		for _, d := range img.Architectures {
+			digests = append(digests, d)
+		}
+		for _, d := range digests {
+			digestRef := ref.Context().Digest(d)
+			if _, err := remote.Head(digestRef, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+				return fmt.Errorf("registry does not serve %v: %v", digestRef, err)
+			}
+		}
+	}
+	return nil
+}
+
 type DockerManifest struct {
 	Config string `json:"Config"`
 }
@@ -272,12 +354,18 @@ type Version struct {
 }
 
 func TestProxyVersion(r ReleaseInfo) error {
+	image := fmt.Sprintf("%s/%s:%s", r.manifest.Docker, "proxyv2", r.manifest.Version)
 	archive := filepath.Join(r.release, "docker", "proxyv2-debug.tar.gz")
+	if r.manifest.DockerOutput == model.DockerOutputOCI {
+		var err error
+		if archive, err = exportLayoutImage(r, "proxyv2", "debug", image); err != nil {
+			return err
+		}
+	}
 	if err := util.VerboseCommand("docker", "load", "-i", archive).Run(); err != nil {
 		return fmt.Errorf("failed to load proxyv2-debug.tar.gz as docker image: %v", err)
 	}
 	buf := bytes.Buffer{}
-	image := fmt.Sprintf("%s/%s:%s", r.manifest.Docker, "proxyv2", r.manifest.Version)
 	cmd := util.VerboseCommand("docker", "run", "--rm", image, "version", "--short", "-ojson")
 	cmd.Stdout = &buf
 	if err := cmd.Run(); err != nil {
@@ -299,6 +387,34 @@ func TestProxyVersion(r ReleaseInfo) error {
 	return nil
 }
 
+// exportLayoutImage writes the linux/amd64 image of a variant in an OCI image layout to a tarball that can be
+// loaded into docker as the given tag.
+func exportLayoutImage(r ReleaseInfo, imageName, variant, tag string) (string, error) {
+	images, err := util.LayoutImages(filepath.Join(r.release, "docker", imageName))
+	if err != nil {
+		return "", err
+	}
+	ref, err := name.NewTag(tag)
+	if err != nil {
+		return "", err
+	}
+	for _, img := range images[variant] {
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return "", err
+		}
+		if cfg.OS != "linux" || cfg.Architecture != "amd64" {
+			continue
+		}
+		archive := filepath.Join(r.tmpDir, fmt.Sprintf("%s-%s.tar", imageName, variant))
+		if err := tarball.WriteToFile(archive, ref, img); err != nil {
+			return "", fmt.Errorf("failed to export %v: %v", tag, err)
+		}
+		return archive, nil
+	}
+	return "", fmt.Errorf("no linux/amd64 %v image found for variant %q", imageName, variant)
+}
+
 func TestHelmChartVersions(r ReleaseInfo) error {
 	if !util.IsValidSemver(r.manifest.Version) {
 		log.Infof("Skipping TestHelmChartVersions; not a valid semver")
@@ -478,6 +594,26 @@ func TestLicenses(r ReleaseInfo) error {
 	if len(expect) > 0 {
 		return fmt.Errorf("failed to find licenses for: %v", expect)
 	}
+
+	report, err := os.ReadFile(filepath.Join(r.release, "licenses", "report.json"))
+	if err != nil {
+		return fmt.Errorf("failed to read license report: %v", err)
+	}
+	entries := []struct {
+		Module  string `json:"module"`
+		License string `json:"license"`
+	}{}
+	if err := json.Unmarshal(report, &entries); err != nil {
+		return fmt.Errorf("failed to parse license report: %v", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("license report is empty")
+	}
+	for _, e := range entries {
+		if strings.Contains(e.License, "UNKNOWN") {
+			return fmt.Errorf("license report has unknown license for %v", e.Module)
+		}
+	}
 	return nil
 }
 
@@ -492,15 +628,176 @@ func TestCompletionFiles(r ReleaseInfo) error {
 }
 
 func TestDebian(info ReleaseInfo) error {
-	if !fileExists(filepath.Join(info.release, "deb", "istio-sidecar.deb")) {
-		return fmt.Errorf("debian package not found")
+	for _, file := range sidecarPackages(info, "deb") {
+		if !fileExists(file) {
+			return fmt.Errorf("debian package %v not found", filepath.Base(file))
+		}
+	}
+	if info.gpgPublicKey == "" {
+		return nil
+	}
+	home := filepath.Join(info.tmpDir, "gnupg")
+	if err := os.MkdirAll(home, 0o700); err != nil {
+		return err
+	}
+	if err := util.VerboseCommand("gpg", "--homedir", home, "--batch", "--import", info.gpgPublicKey).Run(); err != nil {
+		return fmt.Errorf("failed to import public key: %v", err)
+	}
+	for _, deb := range sidecarPackages(info, "deb") {
+		if err := util.VerboseCommand("gpg", "--homedir", home, "--batch", "--verify", deb+".asc", deb).Run(); err != nil {
+			return fmt.Errorf("invalid signature for %v: %v", deb, err)
+		}
 	}
 	return nil
 }
 
 func TestRpm(info ReleaseInfo) error {
-	if !fileExists(filepath.Join(info.release, "rpm", "istio-sidecar.rpm")) {
-		return fmt.Errorf("rpm package not found")
+	for _, file := range sidecarPackages(info, "rpm") {
+		if !fileExists(file) {
+			return fmt.Errorf("rpm package %v not found", filepath.Base(file))
+		}
+	}
+	if info.gpgPublicKey == "" {
+		return nil
+	}
+	dbPath := filepath.Join(info.tmpDir, "rpmdb")
+	if err := os.MkdirAll(dbPath, 0o700); err != nil {
+		return err
+	}
+	if err := util.VerboseCommand("rpmkeys", "--dbpath", dbPath, "--import", info.gpgPublicKey).Run(); err != nil {
+		return fmt.Errorf("failed to import public key: %v", err)
+	}
+	for _, rpm := range sidecarPackages(info, "rpm") {
+		buf := &bytes.Buffer{}
+		cmd := util.VerboseCommand("rpmkeys", "--dbpath", dbPath, "--checksig", rpm)
+		cmd.Stdout = buf
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("invalid signature for %v: %v", rpm, err)
+		}
+		// An unsigned package still passes the digest checks, so make sure a signature was actually verified
+		if !strings.Contains(buf.String(), "signatures OK") {
+			return fmt.Errorf("%v is not signed: %v", rpm, strings.TrimSpace(buf.String()))
+		}
+	}
+	return nil
+}
+
+// sidecarPackages returns the sidecar packages with the given extension for all architectures in the release.
+func sidecarPackages(info ReleaseInfo, ext string) []string {
+	packages := []string{}
+	for _, plat := range info.manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		packages = append(packages, filepath.Join(info.release, ext, util.SidecarPackageName(arch, ext)))
+	}
+	return packages
+}
+
+// TestPackageRepository checks the APT and YUM repositories, if they were built, index the sidecar packages.
+func TestPackageRepository(info ReleaseInfo) error {
+	aptRepo := filepath.Join(info.release, "apt")
+	if util.FileExists(aptRepo) {
+		if !fileExists(filepath.Join(aptRepo, "dists", "stable", "Release")) {
+			return fmt.Errorf("apt Release file not found")
+		}
+		for _, plat := range info.manifest.Architectures {
+			_, arch, _ := strings.Cut(plat, "/")
+			packages, err := os.ReadFile(filepath.Join(aptRepo, "dists", "stable", "main", "binary-"+arch, "Packages"))
+			if err != nil {
+				return fmt.Errorf("apt Packages for %v not found: %v", arch, err)
+			}
+			filename := fmt.Sprintf("Filename: pool/main/i/istio-sidecar/istio-sidecar_%s_%s.deb", info.manifest.Version, arch)
+			if !strings.Contains(string(packages), filename) {
+				return fmt.Errorf("apt Packages for %v does not index the sidecar package", arch)
+			}
+		}
+	}
+	yumRepo := filepath.Join(info.release, "yum")
+	if util.FileExists(yumRepo) {
+		if err := checkYumRepository(yumRepo, info.manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkYumRepository checks the primary metadata referenced by repomd.xml lists the sidecar package of every
+// architecture, at the location it is served from.
+func checkYumRepository(repo string, manifest model.Manifest) error {
+	repomdFile, err := os.ReadFile(filepath.Join(repo, "repodata", "repomd.xml"))
+	if err != nil {
+		return fmt.Errorf("yum repomd.xml not found: %v", err)
+	}
+	var repomd struct {
+		Data []struct {
+			Type     string `xml:"type,attr"`
+			Checksum struct {
+				Type  string `xml:"type,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"checksum"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+		} `xml:"data"`
+	}
+	if err := xml.Unmarshal(repomdFile, &repomd); err != nil {
+		return fmt.Errorf("invalid yum repomd.xml: %v", err)
+	}
+	primaryHref := ""
+	primarySum := ""
+	for _, d := range repomd.Data {
+		if d.Type == "primary" && d.Checksum.Type == "sha256" {
+			primaryHref, primarySum = d.Location.Href, strings.TrimSpace(d.Checksum.Value)
+		}
+	}
+	if primaryHref == "" {
+		return fmt.Errorf("yum repomd.xml does not reference sha256 primary metadata")
+	}
+	compressed, err := os.ReadFile(filepath.Join(repo, filepath.FromSlash(primaryHref)))
+	if err != nil {
+		return fmt.Errorf("yum primary metadata not found: %v", err)
+	}
+	if sum := sha256.Sum256(compressed); hex.EncodeToString(sum[:]) != primarySum {
+		return fmt.Errorf("yum primary metadata %v does not match the checksum in repomd.xml", primaryHref)
+	}
+	if !strings.HasSuffix(primaryHref, ".gz") {
+		return fmt.Errorf("yum primary metadata %v is not gzip compressed", primaryHref)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return fmt.Errorf("invalid yum primary metadata: %v", err)
+	}
+	defer zr.Close()
+	var primary struct {
+		Packages []struct {
+			Name     string `xml:"name"`
+			Arch     string `xml:"arch"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+		} `xml:"package"`
+	}
+	if err := xml.NewDecoder(zr).Decode(&primary); err != nil {
+		return fmt.Errorf("invalid yum primary metadata: %v", err)
+	}
+	indexed := map[string]string{}
+	for _, p := range primary.Packages {
+		if p.Name == "istio-sidecar" {
+			indexed[p.Arch] = p.Location.Href
+		}
+	}
+	for _, plat := range manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		rpmArch, f := util.RpmArchitectures[arch]
+		if !f {
+			return fmt.Errorf("unsupported rpm architecture: %v", arch)
+		}
+		want := fmt.Sprintf("Packages/istio-sidecar-%s.%s.rpm", manifest.Version, rpmArch)
+		if got := indexed[rpmArch]; got != want {
+			return fmt.Errorf("yum repository does not index the sidecar package for %v: expected %v, got %q", arch, want, got)
+		}
+		if !fileExists(filepath.Join(repo, filepath.FromSlash(want))) {
+			return fmt.Errorf("yum repository indexes %v, but it does not exist", want)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,104 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"istio.io/release-builder/pkg/model"
+)
+
+// writeYumRepository writes the metadata createrepo_c would generate for packages, keyed by rpm architecture.
+func writeYumRepository(t *testing.T, packages map[string]string) string {
+	t.Helper()
+	repo := t.TempDir()
+	primary := &strings.Builder{}
+	primary.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm">`)
+	for arch, href := range packages {
+		fmt.Fprintf(primary, `<package type="rpm"><name>istio-sidecar</name><arch>%s</arch><location href="%s"/></package>`, arch, href)
+		if err := os.MkdirAll(filepath.Join(repo, filepath.Dir(href)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(repo, href), []byte("rpm"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	primary.WriteString(`</metadata>`)
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	if _, err := zw.Write([]byte(primary.String())); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(compressed.Bytes())
+	repomd := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary">
+    <checksum type="sha256">%s</checksum>
+    <location href="repodata/primary.xml.gz"/>
+  </data>
+</repomd>`, hex.EncodeToString(sum[:]))
+	if err := os.MkdirAll(filepath.Join(repo, "repodata"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "repodata", "primary.xml.gz"), compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "repodata", "repomd.xml"), []byte(repomd), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestCheckYumRepository(t *testing.T) {
+	manifest := model.Manifest{Version: "1.2.3", Architectures: []string{"linux/amd64", "linux/arm64"}}
+	both := map[string]string{
+		"x86_64":  "Packages/istio-sidecar-1.2.3.x86_64.rpm",
+		"aarch64": "Packages/istio-sidecar-1.2.3.aarch64.rpm",
+	}
+	if err := checkYumRepository(writeYumRepository(t, both), manifest); err != nil {
+		t.Fatal(err)
+	}
+	amd64Only := map[string]string{"x86_64": "Packages/istio-sidecar-1.2.3.x86_64.rpm"}
+	if err := checkYumRepository(writeYumRepository(t, amd64Only), manifest); err == nil {
+		t.Fatal("expected a repository missing the arm64 package to fail")
+	}
+	oldVersion := map[string]string{
+		"x86_64":  "Packages/istio-sidecar-1.2.2.x86_64.rpm",
+		"aarch64": "Packages/istio-sidecar-1.2.2.aarch64.rpm",
+	}
+	if err := checkYumRepository(writeYumRepository(t, oldVersion), manifest); err == nil {
+		t.Fatal("expected a repository indexing another version to fail")
+	}
+	// Metadata that does not match its checksum in repomd.xml is rejected.
+	repo := writeYumRepository(t, both)
+	if err := os.WriteFile(filepath.Join(repo, "repodata", "primary.xml.gz"), []byte("corrupt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkYumRepository(repo, manifest); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+}
@@ -43,4 +43,4 @@ EOF
 go run main.go build \
   --manifest <(echo "${MANIFEST}") \
   --githubtoken "${GITHUB_TOKEN_FILE:-}" \
-  --build-base-images
+  --build-base-images ${DRY_RUN:+--dry-run}
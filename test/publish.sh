@@ -43,6 +43,11 @@ docker run -d  --rm  \
   --name "release-builder-gcs" \
   gcr.io/istio-testing/fake-gcs-server:1.52.3 \
   -scheme http -port 7481
+docker run -d  --rm  \
+  -p "7482:10000" --label istio-release-builder \
+  --name "release-builder-azurite" \
+  mcr.microsoft.com/azure-storage/azurite \
+  azurite-blob --blobHost 0.0.0.0
 
 # Setup our bucket. Add retry since the registry may not be ready yet
 counter=0
@@ -58,10 +63,23 @@ while : ; do
    counter=$((counter+1))
 done
 
+# Azurite's well known development account
+export AZURE_STORAGE_CONNECTION_STRING=${AZURE_STORAGE_CONNECTION_STRING-"DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==;BlobEndpoint=http://127.0.0.1:7482/devstoreaccount1;"}
+counter=0
+until docker run --rm --network host mcr.microsoft.com/azure-cli \
+  az storage container create --name istio-build --connection-string "${AZURE_STORAGE_CONNECTION_STRING}"; do
+  [[ "$counter" == 10 ]] && exit 1
+  sleep 1
+  echo "Trying again... Try #$counter"
+  counter=$((counter+1))
+done
+
 DOCKER_HUB=${DOCKER_HUB:-"localhost:7480"}
 export GCS_HOST=${GCS_HOST-"http://localhost:7481"}
 GCS_BUCKET=${GCS_BUCKET:-istio-build/test}
 HELM_BUCKET=${HELM_BUCKET:-istio-build/test/charts}
+AZ_BUCKET=${AZ_BUCKET:-istio-build/test}
+AZ_HELM_BUCKET=${AZ_HELM_BUCKET:-istio-build/test/charts}
 VERSION="1.19.0-releasebuilder.$(git rev-parse --short HEAD)"
 COSIGN_KEY=${COSIGN_KEY:-}
 GITHUB_ORG=${GITHUB_ORG:-istio}
@@ -135,6 +153,9 @@ go run main.go publish --release "${WORK_DIR}/out" \
   --helmbucket "${HELM_BUCKET}" \
   --helmhub "${DOCKER_HUB}/charts" \
   --gcsbucket "${GCS_BUCKET}" \
+  --azblobbucket "${AZ_BUCKET}" \
+  --azhelmbucket "${AZ_HELM_BUCKET}" \
+  --azhelmurl "http://127.0.0.1:7482/devstoreaccount1/${AZ_HELM_BUCKET}" \
   --dockerhub "${DOCKER_HUB}" \
   --dockertags "${VERSION}"
 fi